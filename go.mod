@@ -10,7 +10,6 @@ require (
 	github.com/jhunt/go-envirotron v0.0.0-20191007155228-c8f2a184ad0f
 	github.com/jhunt/go-log v0.0.0-20171024033145-ddc1e3b8ed30
 	github.com/jhunt/go-route v0.0.0-20200319192915-8fd729f74247
-	github.com/jhunt/go-s3 v0.0.0-20200530154331-7efb75fe8c97
 	github.com/jhunt/go-sample v0.0.0-20200609235657-8c96b9e8d936
 	github.com/jhunt/go-snapshot v0.0.0-20171017043618-9ad8f5ee37a2 // indirect
 	github.com/mattn/go-isatty v0.0.12 // indirect
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	fmt "github.com/jhunt/go-ansi"
 
@@ -13,6 +18,7 @@ import (
 	"github.com/jhunt/go-log"
 
 	"github.com/jhunt/ssg/pkg/client"
+	"github.com/jhunt/ssg/pkg/secret"
 	"github.com/jhunt/ssg/pkg/ssg"
 )
 
@@ -41,6 +47,21 @@ func main() {
 			Upload   struct{} `cli:"upload"`
 			Download struct{} `cli:"download"`
 			Expunge  struct{} `cli:"expunge, delete, rm"`
+			Hold     struct{} `cli:"hold"`
+			Release  struct{} `cli:"release"`
+			Cancel   struct{} `cli:"cancel, kill"`
+			Reload   struct{} `cli:"reload"`
+			Sign     struct {
+				Upload   bool  `cli:"--upload"`
+				Size     int64 `cli:"-s, --size"`
+				Lifetime int   `cli:"-l, --lifetime"`
+			} `cli:"sign"`
+			Streams struct {
+				Bucket  string `cli:"-b, --bucket"`
+				Kind    string `cli:"-k, --kind"`
+				IdleFor string `cli:"-i, --idle-for"`
+				JSON    bool   `cli:"--json"`
+			} `cli:"streams, ps"`
 		} `cli:"control, c"`
 
 		Stream struct {
@@ -49,14 +70,24 @@ func main() {
 		} `cli:"stream, s"`
 
 		Upload struct {
-			SegmentSize int `cli:"-s, --segment-size"`
+			Segmented   bool `cli:"--segmented"`
+			SegmentSize int  `cli:"-s, --segment-size"`
+			Lease       int  `cli:"-l, --lease"`
 		} `cli:"upload, up"`
 		Download struct{} `cli:"download, down"`
+
+		Tokens struct {
+			Generate struct {
+				Bcrypt bool `cli:"--bcrypt"`
+				Cost   int  `cli:"--cost"`
+			} `cli:"generate, gen"`
+		} `cli:"token"`
 	}
 
 	opts.Log = "info"
 	opts.Server.Config = "/etc/ssg/ssg.yml"
 	opts.Upload.SegmentSize = 1024 * 1024
+	opts.Tokens.Generate.Cost = 12
 	env.Override(&opts)
 	command, args, err := cli.Parse(&opts)
 	if err != nil {
@@ -67,10 +98,14 @@ func main() {
 	if opts.Help {
 		fmt.Printf("@C{ssg} - The @R{Secure} Storage Gateway\n\n")
 		switch command {
-		case "server", "ping", "control buckets":
+		case "server", "ping", "control buckets", "control streams", "control reload", "token generate":
 			fmt.Printf("USAGE: @C{ssg} @M{%s}\n\n", command)
-		case "control upload", "control download", "control expunge":
+		case "control upload", "control download", "control expunge", "control hold", "control release", "control sign":
 			fmt.Printf("USAGE: @C{ssg} @M{%s} @Y{REMOTE-PATH}\n\n", command)
+		case "control cancel":
+			fmt.Printf("USAGE: @C{ssg} @M{%s} @Y{STREAM-ID}\n\n", command)
+			fmt.Printf("The @Y{STREAM-ID} can be abbreviated (as in @M{control streams} output),\n")
+			fmt.Printf("as long as it is the prefix of exactly one active stream.\n\n")
 		case "stream get", "stream put":
 			fmt.Printf("USAGE: @C{ssg} @M{%s} @Y{REMOTE-ID}\n\n", command)
 		case "upload":
@@ -97,7 +132,7 @@ func main() {
 		fmt.Printf("\n")
 
 		switch command {
-		case "control buckets", "control upload", "control download", "control delete", "control expunge", "upload", "download":
+		case "control buckets", "control upload", "control download", "control delete", "control expunge", "control hold", "control release", "control cancel", "control streams", "control reload", "control sign", "upload", "download":
 			fmt.Printf("  -t, --token         Control Token for authentication.\n")
 			fmt.Printf("                      Can be set via the @W{$SSG_CONTROL_TOKEN} env var.\n")
 			fmt.Printf("\n")
@@ -106,6 +141,56 @@ func main() {
 			fmt.Printf("                      Can be set via the @W{$SSG_STREAM_TOKEN} env var.\n")
 			fmt.Printf("\n")
 		}
+
+		if command == "control streams" {
+			fmt.Printf("  -b, --bucket        Only show streams for the given bucket.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -k, --kind          Only show streams of the given kind;\n")
+			fmt.Printf("                      either upload or download.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -i, --idle-for      Only show streams that have not sent or\n")
+			fmt.Printf("                      received data in at least this long,\n")
+			fmt.Printf("                      i.e. 30s, 5m, or 1h.\n")
+			fmt.Printf("\n")
+			fmt.Printf("      --json          Print the streams as JSON, instead of\n")
+			fmt.Printf("                      as a table.\n")
+			fmt.Printf("\n")
+		}
+
+		if command == "control sign" {
+			fmt.Printf("      --upload        Sign an upload URL, instead of a\n")
+			fmt.Printf("                      download URL.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -s, --size          The most bytes that can be uploaded\n")
+			fmt.Printf("                      via the signed URL.  Required for\n")
+			fmt.Printf("                      --upload.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -l, --lifetime      How many seconds the signed URL will\n")
+			fmt.Printf("                      be valid for.\n")
+			fmt.Printf("\n")
+		}
+
+		if command == "token generate" {
+			fmt.Printf("      --bcrypt        Hash the new token with bcrypt, instead\n")
+			fmt.Printf("                      of SHA-256.\n")
+			fmt.Printf("\n")
+			fmt.Printf("      --cost          The bcrypt cost factor to use, between\n")
+			fmt.Printf("                      4 and 31.  Defaults to 12.\n")
+			fmt.Printf("\n")
+		}
+
+		if command == "upload" {
+			fmt.Printf("      --segmented     Upload via base64-encoded JSON segments,\n")
+			fmt.Printf("                      instead of a single binary stream.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -s, --segment-size  How many bytes to send per segment, when\n")
+			fmt.Printf("                      using --segmented.  Defaults to 1MiB.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -l, --lease         How many seconds the upload can sit idle\n")
+			fmt.Printf("                      before the gateway cancels it.  Cannot\n")
+			fmt.Printf("                      exceed the gateway's lease ceiling.\n")
+			fmt.Printf("\n")
+		}
 		os.Exit(0)
 	}
 
@@ -146,14 +231,64 @@ func main() {
 			fmt.Fprintf(os.Stderr, "!! @R{%s}\n", err)
 			os.Exit(1)
 		}
+
+		stopped := make(chan error, 1)
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+		go func() {
+			sig := <-sigs
+			fmt.Fprintf(os.Stderr, "received @Y{%s}; shutting down gracefully (signal again to exit immediately)...\n", sig)
+			go func() {
+				<-sigs
+				os.Exit(3)
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), s.ShutdownGrace+30*time.Second)
+			defer cancel()
+			stopped <- s.Shutdown(ctx)
+		}()
+
+		hups := make(chan os.Signal, 1)
+		signal.Notify(hups, syscall.SIGHUP)
+		go func() {
+			for range hups {
+				s.Reload()
+			}
+		}()
+
 		if err := s.Run(vers); err != nil {
 			fmt.Fprintf(os.Stderr, "!! @R{%s}\n", err)
 			os.Exit(2)
 		}
+		if err := <-stopped; err != nil {
+			fmt.Fprintf(os.Stderr, "!! @R{%s}\n", err)
+			os.Exit(2)
+		}
 
 		os.Exit(0)
 	}
 
+	if command == "token generate" {
+		if len(args) != 0 {
+			fmt.Fprintf(os.Stderr, "!!! extra arguments found\n")
+			os.Exit(1)
+		}
+
+		token := secret.Generate()
+		hash := secret.SHA256(token)
+		if opts.Tokens.Generate.Bcrypt {
+			hash, err = secret.Bcrypt(token, opts.Tokens.Generate.Cost)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "!! unable to hash token: @R{%s}\n", err)
+				os.Exit(2)
+			}
+		}
+
+		fmt.Printf("token: %s\n", token)
+		fmt.Printf("hash:  %s\n", hash)
+		os.Exit(0)
+	}
+
 	if command == "ping" {
 		if opts.URL == "" {
 			fmt.Fprintf(os.Stderr, "!! missing required @Y{--url}\n")
@@ -245,6 +380,130 @@ func main() {
 		os.Exit(0)
 	}
 
+	if command == "control hold" || command == "control release" {
+		c := controller(opts.URL, opts.Token, "SSG_CONTROL_TOKEN")
+		target := needTarget(args, "REMOTE-PATH")
+
+		err := c.Hold(target, command == "control hold")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "!! @W{/control} failed: @R{%s}\n", err)
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+
+	if command == "control streams" {
+		c := controller(opts.URL, opts.Token, "SSG_CONTROL_TOKEN")
+		if len(args) > 0 {
+			fmt.Fprintf(os.Stderr, "!! extra arguments found\n")
+			os.Exit(1)
+		}
+
+		filter := client.StreamFilter{
+			Bucket: opts.Control.Streams.Bucket,
+			Kind:   opts.Control.Streams.Kind,
+		}
+		if opts.Control.Streams.IdleFor != "" {
+			d, err := time.ParseDuration(opts.Control.Streams.IdleFor)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "!! invalid @Y{--idle-for} value: @R{%s}\n", err)
+				os.Exit(1)
+			}
+			filter.IdleFor = d
+		}
+
+		streams, err := c.Streams(filter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "!! @W{/streams} failed: @R{%s}\n", err)
+			os.Exit(2)
+		}
+
+		if opts.Control.Streams.JSON {
+			b, err := json.MarshalIndent(streams, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "!! failed to json: @R{%s}\n", err)
+				os.Exit(3)
+			}
+			fmt.Printf("%s\n", string(b))
+			os.Exit(0)
+		}
+
+		now := time.Now()
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "KIND\tID\tBUCKET\tAGE\tIDLE\tEXPIRES\tBYTES\tSEGMENTS\tRATE\tREMOTE\tIDENTITY\tCANON\n")
+		for _, s := range streams {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s/s\t%s\t%s\t%s\n",
+				s.Kind, abbrev(s.ID, 12), s.Bucket,
+				ago(now.Sub(s.Created)), ago(now.Sub(s.Active)), ago(s.Expires.Sub(now)),
+				human(s.Uncompressed), s.Segments, human(int64(s.Throughput)),
+				s.Remote, s.Identity, s.Canon)
+		}
+		tw.Flush()
+		os.Exit(0)
+	}
+
+	if command == "control reload" {
+		c := controller(opts.URL, opts.Token, "SSG_CONTROL_TOKEN")
+		if len(args) > 0 {
+			fmt.Fprintf(os.Stderr, "!! extra arguments found\n")
+			os.Exit(1)
+		}
+
+		if err := c.Reload(); err != nil {
+			fmt.Fprintf(os.Stderr, "!! @W{/reload} failed: @R{%s}\n", err)
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+
+	if command == "control sign" {
+		c := controller(opts.URL, opts.Token, "SSG_CONTROL_TOKEN")
+		c.Lease = time.Duration(opts.Control.Sign.Lifetime) * time.Second
+		target := needTarget(args, "REMOTE-PATH")
+
+		op := "download"
+		if opts.Control.Sign.Upload {
+			op = "upload"
+		}
+
+		signed, err := c.Sign(op, target, opts.Control.Sign.Size)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "!! @W{/control} failed: @R{%s}\n", err)
+			os.Exit(2)
+		}
+
+		b, err := json.MarshalIndent(signed, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "!! failed to json: @R{%s}\n", err)
+			os.Exit(3)
+		}
+		fmt.Printf("%s\n", string(b))
+		os.Exit(0)
+	}
+
+	if command == "control cancel" {
+		c := controller(opts.URL, opts.Token, "SSG_CONTROL_TOKEN")
+		target, err := resolveStream(c, needTarget(args, "STREAM-ID"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "!! @R{%s}\n", err)
+			os.Exit(2)
+		}
+
+		canceled, err := c.CancelStream(target)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "!! @W{/streams} failed: @R{%s}\n", err)
+			os.Exit(2)
+		}
+
+		b, err := json.MarshalIndent(canceled, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "!! failed to json: @R{%s}\n", err)
+			os.Exit(3)
+		}
+		fmt.Printf("%s\n", string(b))
+		os.Exit(0)
+	}
+
 	if command == "stream get" {
 		c, token := streamer(opts.URL, opts.Token, "SSG_STREAM_TOKEN")
 		target := needTarget(args, "REMOTE-ID")
@@ -273,7 +532,9 @@ func main() {
 
 	if command == "upload" {
 		c := controller(opts.URL, opts.Token, "SSG_CONTROL_TOKEN")
+		c.Segmented = opts.Upload.Segmented
 		c.SegmentSize = opts.Upload.SegmentSize
+		c.Lease = time.Duration(opts.Upload.Lease) * time.Second
 		target := needTarget(args, "REMOTE-PATH")
 
 		stream, err := c.NewUpload(target)
@@ -371,3 +632,64 @@ func needTarget(args []string, name string) string {
 	}
 	return args[0]
 }
+
+// resolveStream expands an abbreviated stream ID (as shown by
+// `control streams`) to the full ID of the one active stream
+// that it is a prefix of.
+func resolveStream(c client.Client, id string) (string, error) {
+	prefix := strings.TrimSuffix(id, "...")
+	if prefix == "" {
+		return "", fmt.Errorf("no stream id given")
+	}
+	streams, err := c.Streams(client.StreamFilter{})
+	if err != nil {
+		return "", fmt.Errorf("unable to list streams: %s", err)
+	}
+
+	matches := make([]string, 0)
+	for _, s := range streams {
+		if s.ID == prefix {
+			return s.ID, nil
+		}
+		if strings.HasPrefix(s.ID, prefix) {
+			matches = append(matches, s.ID)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return prefix, nil // let the gateway say it is not found
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("stream id '%s' is ambiguous; it matches %d streams", id, len(matches))
+	}
+}
+
+func abbrev(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+func ago(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	return d.Truncate(time.Second).String()
+}
+
+func human(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	f := float64(n)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d%s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f%s", f, units[i])
+}
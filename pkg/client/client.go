@@ -9,6 +9,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -27,6 +28,55 @@ type Blob struct {
 	Uncompressed int64 `json:"uncompressed"`
 }
 
+type Status struct {
+	Offset       int64     `json:"offset"`
+	Segments     int       `json:"segments"`
+	Compressed   int64     `json:"compressed"`
+	Uncompressed int64     `json:"uncompressed"`
+	Expires      time.Time `json:"expires"`
+}
+
+type ActiveStream struct {
+	Kind         string    `json:"kind"`
+	ID           string    `json:"id"`
+	Canon        string    `json:"canon"`
+	Bucket       string    `json:"bucket"`
+	Created      time.Time `json:"created"`
+	Active       time.Time `json:"active"`
+	Expires      time.Time `json:"expires"`
+	Received     int64     `json:"received"`
+	Sent         int64     `json:"sent"`
+	Compressed   int64     `json:"compressed"`
+	Uncompressed int64     `json:"uncompressed"`
+	Segments     int       `json:"segments"`
+	Throughput   float64   `json:"throughput"`
+	Remote       string    `json:"remote"`
+	Identity     string    `json:"identity"`
+}
+
+type StreamFilter struct {
+	Bucket  string
+	Kind    string
+	IdleFor time.Duration
+}
+
+type Canceled struct {
+	Kind     string `json:"kind"`
+	ID       string `json:"id"`
+	Canon    string `json:"canon"`
+	Received int64  `json:"received"`
+	Sent     int64  `json:"sent"`
+}
+
+type Signed struct {
+	Kind      string    `json:"kind"`
+	Operation string    `json:"operation"`
+	Canon     string    `json:"canon"`
+	URL       string    `json:"url"`
+	Size      int64     `json:"size,omitempty"`
+	Expires   time.Time `json:"expires"`
+}
+
 type Bucket struct {
 	Key         string `json:"key"`
 	Name        string `json:"name"`
@@ -39,6 +89,10 @@ type Client struct {
 	URL          string
 	ControlToken string
 	SegmentSize  int
+	ChunkSize    int
+	Segmented    bool
+	Retries      int
+	Lease        time.Duration
 
 	Client *http.Client
 }
@@ -47,6 +101,12 @@ func (c *Client) init() {
 	if c.SegmentSize == 0 {
 		c.SegmentSize = 1024 * 1024 // 1MiB
 	}
+	if c.ChunkSize == 0 {
+		c.ChunkSize = 16 * 1024 * 1024 // 16MiB
+	}
+	if c.Retries == 0 {
+		c.Retries = 3
+	}
 	if c.Client == nil {
 		c.Client = &http.Client{}
 	}
@@ -67,9 +127,11 @@ func (c *Client) control(kind, target string) (*Stream, error) {
 	b, err := json.Marshal(struct {
 		Kind   string `json:"kind"`
 		Target string `json:"target"`
+		Lease  int    `json:"lease,omitempty"`
 	}{
 		Kind:   kind,
 		Target: target,
+		Lease:  int(c.Lease.Seconds()),
 	})
 	if err != nil {
 		return nil, err
@@ -105,27 +167,29 @@ func (c *Client) control(kind, target string) (*Stream, error) {
 	return &out, nil
 }
 
-func (c *Client) agent(id, token string, data []byte, eof bool) (int, error) {
+func (c *Client) agent(id, token string, offset int64, data []byte, eof bool) error {
 	c.init()
 
 	var seg struct {
-		Data string `json:"data"`
-		EOF  bool   `json:"eof"`
+		Data   string `json:"data"`
+		EOF    bool   `json:"eof"`
+		Offset int64  `json:"offset"`
 	}
 
 	if data != nil {
 		seg.Data = base64.StdEncoding.EncodeToString(data)
 	}
 	seg.EOF = eof
+	seg.Offset = offset
 
 	b, err := json.Marshal(seg)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
 	req, err := http.NewRequest("POST", c.blob(id), bytes.NewBuffer(b))
 	if err != nil {
-		return 0, err
+		return err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -134,15 +198,80 @@ func (c *Client) agent(id, token string, data []byte, eof bool) (int, error) {
 
 	res, err := c.Client.Do(req)
 	if err != nil {
-		return 0, err
+		return err
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != 200 {
-		return 0, errorFrom(res)
+		return errorFrom(res)
 	}
 
-	return len(data), nil
+	return nil
+}
+
+func (c *Client) chunk(id, token string, offset int64, data []byte, eof bool) error {
+	c.init()
+
+	u := fmt.Sprintf("%s?offset=%d", c.blob(id), offset)
+	if !eof {
+		u += "&partial=1"
+	}
+	req, err := http.NewRequest("PUT", u, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return errorFrom(res)
+	}
+
+	return nil
+}
+
+type sender func(id, token string, offset int64, data []byte, eof bool) error
+
+func (c *Client) resume(send sender, id, token string, offset int64, data []byte, eof bool) error {
+	err := send(id, token, offset, data, eof)
+	for attempt := 1; attempt <= c.Retries && transient(err); attempt++ {
+		time.Sleep(time.Duration(attempt) * time.Second)
+
+		st, serr := c.Status(id, token)
+		if serr != nil {
+			if transient(serr) {
+				err = serr
+				continue
+			}
+			return serr
+		}
+
+		switch st.Offset {
+		case offset:
+			// the server never got our data; send it again.
+			err = send(id, token, offset, data, eof)
+
+		case offset + int64(len(data)):
+			// the server got our data, but we never heard back.
+			if !eof {
+				return nil
+			}
+			// we still have to close out the upload...
+			offset, data = st.Offset, nil
+			err = send(id, token, offset, data, eof)
+
+		default:
+			return fmt.Errorf("unable to resume upload: server has committed %d bytes, but we expected %d", st.Offset, offset)
+		}
+	}
+	return err
 }
 
 func (c *Client) Ping() (string, error) {
@@ -219,31 +348,301 @@ func (c *Client) Expunge(target string) error {
 	return err
 }
 
+// Hold places a legal hold on the target blob, keeping it
+// from being expunged or overwritten until it is released
+// (by calling Hold again, with on set to false).
+func (c *Client) Hold(target string, on bool) error {
+	kind := "release"
+	if on {
+		kind = "hold"
+	}
+	_, err := c.control(kind, target)
+	return err
+}
+
+func (c *Client) Sign(operation, target string, size int64) (*Signed, error) {
+	c.init()
+
+	b, err := json.Marshal(struct {
+		Kind      string `json:"kind"`
+		Operation string `json:"operation"`
+		Target    string `json:"target"`
+		Lease     int    `json:"lease,omitempty"`
+		Size      int64  `json:"size,omitempty"`
+	}{
+		Kind:      "sign",
+		Operation: operation,
+		Target:    target,
+		Lease:     int(c.Lease.Seconds()),
+		Size:      size,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", c.url("control"), bytes.NewBuffer(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.ControlToken)
+
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, errorFrom(res)
+	}
+
+	b, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var out Signed
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	out.URL = c.url(strings.TrimPrefix(out.URL, "/"))
+	return &out, nil
+}
+
+func (c *Client) Streams(filter StreamFilter) ([]ActiveStream, error) {
+	c.init()
+
+	q := url.Values{}
+	if filter.Bucket != "" {
+		q.Set("bucket", filter.Bucket)
+	}
+	if filter.Kind != "" {
+		q.Set("kind", filter.Kind)
+	}
+	if filter.IdleFor > 0 {
+		q.Set("idle_for", filter.IdleFor.String())
+	}
+
+	u := c.url("streams")
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.ControlToken)
+
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != 200 {
+		return nil, errorFrom(res)
+	}
+
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var streams []ActiveStream
+	return streams, json.Unmarshal(b, &streams)
+}
+
+func (c *Client) CancelStream(id string) (*Canceled, error) {
+	c.init()
+
+	req, err := http.NewRequest("DELETE", c.url("streams", id), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.ControlToken)
+
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != 200 {
+		return nil, errorFrom(res)
+	}
+
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var out Canceled
+	return &out, json.Unmarshal(b, &out)
+}
+
+func (c *Client) Reload() error {
+	c.init()
+
+	req, err := http.NewRequest("POST", c.url("reload"), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.ControlToken)
+
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return errorFrom(res)
+	}
+	return nil
+}
+
 func (c *Client) Put(id, token string, in io.Reader, eof bool) (int64, error) {
+	if c.Segmented {
+		return c.PutSegments(id, token, in, eof)
+	}
+	return c.PutStream(id, token, in, eof)
+}
+
+func (c *Client) PutStream(id, token string, in io.Reader, eof bool) (int64, error) {
 	c.init()
+	return c.put(c.chunk, c.ChunkSize, id, token, in, eof)
+}
+
+func (c *Client) PutSegments(id, token string, in io.Reader, eof bool) (int64, error) {
+	c.init()
+	return c.put(c.agent, c.SegmentSize, id, token, in, eof)
+}
+
+func (c *Client) put(send sender, size int, id, token string, in io.Reader, eof bool) (int64, error) {
+	st, err := c.Status(id, token)
+	if err != nil {
+		return 0, err
+	}
+	offset := st.Offset
+
+	// keep the lease alive while we wait on slow readers.
+	stop := c.keepalive(id, token, time.Until(st.Expires)/3)
+	defer stop()
+
+	var total int64
+	buf := make([]byte, size)
+	rd := bufio.NewReader(in)
+	for {
+		n, err := io.ReadFull(rd, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return total, err
+		}
 
-	var size int64
+		_, err = rd.Peek(1)
+		done := err == io.EOF
 
-	scan := bufio.NewScanner(in)
-	scan.Buffer(make([]byte, c.SegmentSize), c.SegmentSize)
-	scan.Split(splitInto(c.SegmentSize))
+		if n > 0 || (done && eof) {
+			if err := c.resume(send, id, token, offset, buf[:n], done && eof); err != nil {
+				return total, err
+			}
+			offset += int64(n)
+			total += int64(n)
+		}
 
-	for scan.Scan() {
-		n, err := c.agent(id, token, scan.Bytes(), false)
-		if err != nil {
-			return 0, err
+		if done {
+			return total, nil
 		}
-		size += int64(n)
 	}
+}
 
-	if eof {
-		_, err := c.agent(id, token, nil, true)
-		if err != nil {
-			return 1, err
+func (c *Client) keepalive(id, token string, every time.Duration) func() {
+	if every < time.Second {
+		every = time.Second
+	}
+
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				c.Keepalive(id, token)
+			}
 		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+func (c *Client) Keepalive(id, token string) (time.Time, error) {
+	c.init()
+
+	req, err := http.NewRequest("POST", c.blob(id)+"/keepalive", nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if res.StatusCode != 200 {
+		return time.Time{}, errorFrom(res)
 	}
 
-	return size, nil
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var out struct {
+		Expires time.Time `json:"expires"`
+	}
+	return out.Expires, json.Unmarshal(b, &out)
+}
+
+func (c *Client) Status(id, token string) (*Status, error) {
+	c.init()
+
+	req, err := http.NewRequest("GET", c.blob(id)+"/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != 200 {
+		return nil, errorFrom(res)
+	}
+
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var out Status
+	return &out, json.Unmarshal(b, &out)
 }
 
 func (c *Client) Get(id, token string) (io.ReadCloser, error) {
@@ -256,6 +655,7 @@ func (c *Client) Get(id, token string) (io.ReadCloser, error) {
 		return nil, err
 	}
 	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept-Encoding", "deflate")
 
 	res, err := c.Client.Do(req)
 	if err != nil {
@@ -266,5 +666,8 @@ func (c *Client) Get(id, token string) (io.ReadCloser, error) {
 		return nil, errorFrom(res)
 	}
 
+	if res.Header.Get("Content-Encoding") == "deflate" {
+		return inflate(res.Body)
+	}
 	return res.Body, err
 }
@@ -2,11 +2,20 @@ package client
 
 import (
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 )
 
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e Error) Error() string {
+	return e.Message
+}
+
 func errorFrom(res *http.Response) error {
 	defer res.Body.Close()
 
@@ -17,8 +26,24 @@ func errorFrom(res *http.Response) error {
 	if err != nil {
 		return err
 	}
-	if err := json.Unmarshal(b, &out); err != nil {
-		return err
+	if err := json.Unmarshal(b, &out); err != nil || out.Error == "" {
+		return Error{
+			Status:  res.StatusCode,
+			Message: "bad HTTP response " + res.Status,
+		}
+	}
+	return Error{
+		Status:  res.StatusCode,
+		Message: out.Error,
+	}
+}
+
+func transient(err error) bool {
+	switch e := err.(type) {
+	case *url.Error:
+		return true
+	case Error:
+		return e.Status == 429 || e.Status == 502 || e.Status == 503 || e.Status == 504
 	}
-	return fmt.Errorf(out.Error)
+	return false
 }
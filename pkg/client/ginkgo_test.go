@@ -0,0 +1,237 @@
+package client_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+
+	"github.com/jhunt/ssg/pkg/client"
+	"github.com/jhunt/ssg/pkg/ssg"
+)
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "SSG Client Test Suite")
+}
+
+// faulty sits in front of a gateway, and fails chosen
+// blob PUTs (counting from 1): dropped PUTs never reach
+// the gateway, and lost PUTs do, but their response never
+// makes it back to the client.
+type faulty struct {
+	next http.Handler
+
+	lock sync.Mutex
+	puts int
+	drop map[int]bool
+	lose map[int]bool
+}
+
+func (f *faulty) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		f.next.ServeHTTP(w, r)
+		return
+	}
+
+	f.lock.Lock()
+	f.puts++
+	n := f.puts
+	f.lock.Unlock()
+
+	if f.drop[n] {
+		w.WriteHeader(503)
+		io.WriteString(w, `{"error":"try again later"}`)
+		return
+	}
+	if f.lose[n] {
+		f.next.ServeHTTP(httptest.NewRecorder(), r)
+		w.WriteHeader(502)
+		return
+	}
+	f.next.ServeHTTP(w, r)
+}
+
+// slow reads data, but only after waiting for a while
+type slow struct {
+	wait time.Duration
+	data io.Reader
+}
+
+func (s *slow) Read(b []byte) (int, error) {
+	time.Sleep(s.wait)
+	s.wait = 0
+	return s.data.Read(b)
+}
+
+var _ = Describe("SSG Client", func() {
+	var (
+		root    string
+		gateway *ssg.Server
+		fault   *faulty
+		server  *httptest.Server
+		c       *client.Client
+	)
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "ssg-client-")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		gateway, err = ssg.NewServerFromString(strings.Replace(`---
+cluster: test
+controlTokens: [admin]
+sweepInterval: 1
+defaultBucket:
+  compression: none
+  encryption:  none
+buckets:
+  - key: files
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+`, "ROOT", root, -1))
+		Ω(err).ShouldNot(HaveOccurred())
+		go gateway.Sweep()
+
+		fault = &faulty{
+			next: gateway.Router("test"),
+			drop: make(map[int]bool),
+			lose: make(map[int]bool),
+		}
+		server = httptest.NewServer(fault)
+		c = &client.Client{
+			URL:          server.URL,
+			ControlToken: "admin",
+		}
+	})
+	AfterEach(func() {
+		server.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		gateway.Shutdown(ctx)
+		os.RemoveAll(root)
+	})
+
+	Context("keepalive", func() {
+		It("should extend the lease of a stream", func() {
+			c.Lease = time.Minute
+			up, err := c.NewUpload("ssg://test/files/a")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			time.Sleep(10 * time.Millisecond)
+			expires, err := c.Keepalive(up.ID, up.Token)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(expires).Should(BeTemporally(">", up.Expires))
+			Ω(expires).Should(BeTemporally("~", time.Now().Add(time.Minute), 5*time.Second))
+
+			st, err := c.Status(up.ID, up.Token)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(st.Expires).Should(BeTemporally(">=", expires))
+		})
+
+		It("should fail for streams that are gone", func() {
+			up, err := c.NewUpload("ssg://test/files/a")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, err = c.Keepalive(up.ID, "not-the-token")
+			Ω(err).Should(HaveOccurred())
+			Ω(err.(client.Error).Status).Should(Equal(404))
+
+			_, err = c.Keepalive("no-such-stream", up.Token)
+			Ω(err).Should(HaveOccurred())
+			Ω(err.(client.Error).Status).Should(Equal(404))
+		})
+
+		It("should keep uploads alive while waiting on slow readers", func() {
+			c.Lease = 2 * time.Second
+			up, err := c.NewUpload("ssg://test/files/slow")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			n, err := c.Put(up.ID, up.Token, &slow{
+				wait: 3500 * time.Millisecond,
+				data: strings.NewReader("eventually"),
+			}, true)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(n).Should(Equal(int64(10)))
+			Ω(ioutil.ReadFile(root + "/slow")).Should(Equal([]byte("eventually")))
+		})
+	})
+
+	Context("putting data", func() {
+		BeforeEach(func() {
+			c.ChunkSize = 4
+			c.Retries = 1
+		})
+
+		It("should send data in chunks", func() {
+			up, err := c.NewUpload("ssg://test/files/a")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			n, err := c.Put(up.ID, up.Token, strings.NewReader("0123456789"), true)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(n).Should(Equal(int64(10)))
+			Ω(fault.puts).Should(Equal(3))
+			Ω(ioutil.ReadFile(root + "/a")).Should(Equal([]byte("0123456789")))
+		})
+
+		It("should resend chunks that never reached the gateway", func() {
+			fault.drop[2] = true
+			up, err := c.NewUpload("ssg://test/files/a")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			n, err := c.Put(up.ID, up.Token, strings.NewReader("0123456789"), true)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(n).Should(Equal(int64(10)))
+			Ω(fault.puts).Should(Equal(4))
+			Ω(ioutil.ReadFile(root + "/a")).Should(Equal([]byte("0123456789")))
+		})
+
+		It("should not resend chunks the gateway committed before the response was lost", func() {
+			fault.lose[1] = true
+			up, err := c.NewUpload("ssg://test/files/a")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			n, err := c.Put(up.ID, up.Token, strings.NewReader("0123456789"), true)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(n).Should(Equal(int64(10)))
+			Ω(fault.puts).Should(Equal(3))
+			Ω(ioutil.ReadFile(root + "/a")).Should(Equal([]byte("0123456789")))
+		})
+
+		It("should resume an upload from where the gateway left off", func() {
+			up, err := c.NewUpload("ssg://test/files/a")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			n, err := c.Put(up.ID, up.Token, strings.NewReader("01234"), false)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(n).Should(Equal(int64(5)))
+
+			n, err = c.Put(up.ID, up.Token, strings.NewReader("56789"), true)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(n).Should(Equal(int64(5)))
+			Ω(ioutil.ReadFile(root + "/a")).Should(Equal([]byte("0123456789")))
+		})
+
+		It("should give up once it runs out of retries", func() {
+			fault.drop[1] = true
+			fault.drop[2] = true
+			up, err := c.NewUpload("ssg://test/files/a")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, err = c.Put(up.ID, up.Token, strings.NewReader("0123456789"), true)
+			Ω(err).Should(MatchError("try again later"))
+			Ω(fault.puts).Should(Equal(2))
+		})
+	})
+})
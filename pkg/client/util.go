@@ -1,19 +1,28 @@
 package client
 
-func splitInto(n int) func([]byte, bool) (int, []byte, error) {
-	return func(data []byte, atEOF bool) (int, []byte, error) {
-		if atEOF && len(data) == 0 {
-			return 0, nil, nil
-		}
+import (
+	"compress/zlib"
+	"io"
+)
 
-		if len(data) >= n {
-			return n, data[0:n], nil
-		}
+type inflater struct {
+	io.ReadCloser
+	body io.Closer
+}
 
-		if atEOF {
-			return len(data), data, nil
-		}
+func (i inflater) Close() error {
+	i.ReadCloser.Close()
+	return i.body.Close()
+}
 
-		return 0, nil, nil
+func inflate(body io.ReadCloser) (io.ReadCloser, error) {
+	zr, err := zlib.NewReader(body)
+	if err != nil {
+		body.Close()
+		return nil, err
 	}
+	return inflater{
+		ReadCloser: zr,
+		body:       body,
+	}, nil
 }
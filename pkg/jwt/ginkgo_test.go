@@ -0,0 +1,132 @@
+package jwt_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+
+	"github.com/jhunt/ssg/pkg/jwt"
+)
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "JSON Web Token Test Suite")
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func segments(header, claims interface{}) string {
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	return b64(h) + "." + b64(c)
+}
+
+func signRS256(key *rsa.PrivateKey, kid string, claims interface{}) string {
+	signed := segments(map[string]string{"alg": "RS256", "kid": kid}, claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	Ω(err).ShouldNot(HaveOccurred())
+	return signed + "." + b64(sig)
+}
+
+func signES256(key *ecdsa.PrivateKey, kid string, claims interface{}) string {
+	signed := segments(map[string]string{"alg": "ES256", "kid": kid}, claims)
+	sum := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, sum[:])
+	Ω(err).ShouldNot(HaveOccurred())
+	sig := make([]byte, 64)
+	copy(sig[32-len(r.Bytes()):32], r.Bytes())
+	copy(sig[64-len(s.Bytes()):], s.Bytes())
+	return signed + "." + b64(sig)
+}
+
+var _ = Describe("JSON Web Tokens", func() {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	jwks := fmt.Sprintf(`{"keys":[
+	  {"kty":"RSA","kid":"r1","use":"sig","alg":"RS256","n":"%s","e":"%s"},
+	  {"kty":"EC","kid":"e1","crv":"P-256","x":"%s","y":"%s"},
+	  {"kty":"RSA","kid":"enc","use":"enc","n":"%s","e":"AQAB"}
+	]}`,
+		b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+		b64(ecKey.X.Bytes()), b64(ecKey.Y.Bytes()),
+		b64(otherKey.N.Bytes()))
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":    "https://ci.example.com",
+		"aud":    []string{"ssg", "other"},
+		"sub":    "repo:acme/backups",
+		"exp":    now.Add(time.Minute).Unix(),
+		"groups": []string{"ops", "backups"},
+	}
+
+	It("should parse RSA and EC signing keys out of a JWKS document", func() {
+		ks, err := jwt.ParseKeySet([]byte(jwks))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(ks.Keys).Should(HaveLen(2))
+		Ω(ks.Keys[0].ID).Should(Equal("r1"))
+		Ω(ks.Keys[1].ID).Should(Equal("e1"))
+	})
+
+	It("should verify RS256 and ES256 signatures", func() {
+		ks, err := jwt.ParseKeySet([]byte(jwks))
+		Ω(err).ShouldNot(HaveOccurred())
+
+		c, err := jwt.Verify(signRS256(rsaKey, "r1", claims), ks)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(c["sub"]).Should(Equal("repo:acme/backups"))
+
+		c, err = jwt.Verify(signES256(ecKey, "e1", claims), ks)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(c.Strings("groups")).Should(Equal([]string{"ops", "backups"}))
+	})
+
+	It("should reject tokens signed by unknown keys", func() {
+		ks, err := jwt.ParseKeySet([]byte(jwks))
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, err = jwt.Verify(signRS256(otherKey, "r1", claims), ks)
+		Ω(err).Should(HaveOccurred())
+
+		_, err = jwt.Verify(signRS256(otherKey, "enc", claims), ks)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should reject unsigned tokens", func() {
+		ks, err := jwt.ParseKeySet([]byte(jwks))
+		Ω(err).ShouldNot(HaveOccurred())
+
+		token := segments(map[string]string{"alg": "none", "kid": "r1"}, claims) + "."
+		_, err = jwt.Verify(token, ks)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should validate the issuer, audience and expiry", func() {
+		ks, _ := jwt.ParseKeySet([]byte(jwks))
+		c, err := jwt.Verify(signRS256(rsaKey, "r1", claims), ks)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(c.Validate("https://ci.example.com", "ssg", now, 0)).Should(Succeed())
+		Ω(c.Validate("https://evil.example.com", "ssg", now, 0)).ShouldNot(Succeed())
+		Ω(c.Validate("https://ci.example.com", "vault", now, 0)).ShouldNot(Succeed())
+		Ω(c.Validate("https://ci.example.com", "ssg", now.Add(2*time.Minute), 0)).ShouldNot(Succeed())
+		Ω(c.Validate("https://ci.example.com", "ssg", now.Add(2*time.Minute), 5*time.Minute)).Should(Succeed())
+	})
+})
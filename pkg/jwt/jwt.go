@@ -0,0 +1,306 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+type Key struct {
+	ID        string
+	Algorithm string
+	Public    crypto.PublicKey
+}
+
+type KeySet struct {
+	Keys []Key
+}
+
+func ParseKeySet(b []byte) (*KeySet, error) {
+	var raw struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("malformed jwks: %s", err)
+	}
+
+	ks := &KeySet{}
+	for i, k := range raw.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeInt(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("malformed modulus for jwks key #%d: %s", i+1, err)
+			}
+			e, err := decodeInt(k.E)
+			if err != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("malformed exponent for jwks key #%d", i+1)
+			}
+			ks.Keys = append(ks.Keys, Key{
+				ID:        k.Kid,
+				Algorithm: k.Alg,
+				Public:    &rsa.PublicKey{N: n, E: int(e.Int64())},
+			})
+
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, err := decodeInt(k.X)
+			if err != nil {
+				return nil, fmt.Errorf("malformed x coordinate for jwks key #%d: %s", i+1, err)
+			}
+			y, err := decodeInt(k.Y)
+			if err != nil {
+				return nil, fmt.Errorf("malformed y coordinate for jwks key #%d: %s", i+1, err)
+			}
+			if !curve.IsOnCurve(x, y) {
+				return nil, fmt.Errorf("jwks key #%d is not on curve %s", i+1, k.Crv)
+			}
+			ks.Keys = append(ks.Keys, Key{
+				ID:        k.Kid,
+				Algorithm: k.Alg,
+				Public:    &ecdsa.PublicKey{Curve: curve, X: x, Y: y},
+			})
+		}
+	}
+	return ks, nil
+}
+
+func (ks *KeySet) Find(kid string) []Key {
+	l := make([]Key, 0)
+	for _, k := range ks.Keys {
+		if kid == "" || k.ID == "" || k.ID == kid {
+			l = append(l, k)
+		}
+	}
+	return l
+}
+
+type Header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+type Claims map[string]interface{}
+
+func Looks(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func Parse(token string) (Header, Claims, error) {
+	var h Header
+	var c Claims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return h, c, fmt.Errorf("malformed token")
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return h, c, fmt.Errorf("malformed token header: %s", err)
+	}
+	if err := json.Unmarshal(b, &h); err != nil {
+		return h, c, fmt.Errorf("malformed token header: %s", err)
+	}
+
+	b, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return h, c, fmt.Errorf("malformed token claims: %s", err)
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return h, c, fmt.Errorf("malformed token claims: %s", err)
+	}
+
+	return h, c, nil
+}
+
+func Verify(token string, ks *KeySet) (Claims, error) {
+	h, c, err := Parse(token)
+	if err != nil {
+		return nil, err
+	}
+
+	i := strings.LastIndex(token, ".")
+	signed := token[:i]
+	sig, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %s", err)
+	}
+
+	keys := ks.Find(h.KeyID)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no key found for key id '%s'", h.KeyID)
+	}
+	for _, k := range keys {
+		if k.Algorithm != "" && k.Algorithm != h.Algorithm {
+			continue
+		}
+		ok, err := verify(h.Algorithm, k.Public, []byte(signed), sig)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("signature verification failed")
+}
+
+func verify(alg string, key crypto.PublicKey, signed, sig []byte) (bool, error) {
+	if len(alg) != 5 {
+		return false, fmt.Errorf("unsupported signing algorithm '%s'", alg)
+	}
+
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return false, fmt.Errorf("unsupported signing algorithm '%s'", alg)
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false, nil
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, sig) == nil, nil
+
+	case "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false, nil
+		}
+		return rsa.VerifyPSS(pub, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil, nil
+
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return false, nil
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return false, nil
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(pub, digest, r, s), nil
+	}
+
+	return false, fmt.Errorf("unsupported signing algorithm '%s'", alg)
+}
+
+func (c Claims) Validate(issuer, audience string, now time.Time, leeway time.Duration) error {
+	if iss, _ := c["iss"].(string); iss != issuer {
+		return fmt.Errorf("token issuer '%s' is not trusted", iss)
+	}
+	if !c.Has("aud", audience) {
+		return fmt.Errorf("token is not intended for audience '%s'", audience)
+	}
+
+	exp, ok := c.time("exp")
+	if !ok {
+		return fmt.Errorf("token has no expiry")
+	}
+	if now.After(exp.Add(leeway)) {
+		return fmt.Errorf("token expired at %s", exp.Format(time.RFC3339))
+	}
+	if nbf, ok := c.time("nbf"); ok && now.Add(leeway).Before(nbf) {
+		return fmt.Errorf("token is not valid until %s", nbf.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{v}
+	case []interface{}:
+		l := make([]string, 0, len(v))
+		for _, x := range v {
+			l = append(l, stringify(x))
+		}
+		return l
+	default:
+		return []string{stringify(v)}
+	}
+}
+
+func (c Claims) Has(name, want string) bool {
+	for _, v := range c.Strings(name) {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Claims) time(name string) (time.Time, bool) {
+	v, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+func stringify(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return big.NewFloat(f).Text('f', -1)
+	}
+	return fmt.Sprint(v)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
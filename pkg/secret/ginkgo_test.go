@@ -0,0 +1,113 @@
+package secret_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+
+	"github.com/jhunt/ssg/pkg/secret"
+)
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Token Secrets Test Suite")
+}
+
+var _ = Describe("secret.Match()", func() {
+	It("should match plaintext tokens exactly", func() {
+		Ω(secret.Match("s3cr3t", "s3cr3t")).Should(BeTrue())
+		Ω(secret.Match("s3cr3t", "s3cr3")).Should(BeFalse())
+		Ω(secret.Match("s3cr3t", "")).Should(BeFalse())
+	})
+
+	It("should match tokens against their sha256 hashes", func() {
+		h := secret.SHA256("s3cr3t")
+		Ω(h).Should(HavePrefix("sha256:"))
+		Ω(secret.Validate(h)).Should(Succeed())
+		Ω(secret.Match(h, "s3cr3t")).Should(BeTrue())
+		Ω(secret.Match(h, "other")).Should(BeFalse())
+		Ω(secret.Match(h, h)).Should(BeFalse())
+	})
+
+	It("should match tokens against their bcrypt hashes", func() {
+		h, err := secret.Bcrypt("s3cr3t", 4)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(h).Should(HavePrefix("bcrypt:"))
+		Ω(secret.Validate(h)).Should(Succeed())
+		Ω(secret.Match(h, "s3cr3t")).Should(BeTrue())
+		Ω(secret.Match(h, "other")).Should(BeFalse())
+	})
+
+	It("should reject malformed hashes", func() {
+		Ω(secret.Validate("sha256:abcd")).ShouldNot(Succeed())
+		Ω(secret.Validate("sha256:zz")).ShouldNot(Succeed())
+		Ω(secret.Validate("bcrypt:not-a-hash")).ShouldNot(Succeed())
+		Ω(secret.Validate("bcrypt:5e884898:$2a$04$dGvKE0q4vwZ8jC3xlxIZ1eo6z9q.4Wn.wGO7xheExYiffNcHTX8a6")).ShouldNot(Succeed())
+		Ω(secret.Validate("")).ShouldNot(Succeed())
+		Ω(secret.Validate("plaintext")).Should(Succeed())
+		Ω(secret.Validate("bcrypt:$2a$04$dGvKE0q4vwZ8jC3xlxIZ1eo6z9q.4Wn.wGO7xheExYiffNcHTX8a6")).Should(Succeed())
+	})
+})
+
+var _ = Describe("secret.Set", func() {
+	var (
+		tokens []string
+		set    *secret.Set
+	)
+
+	BeforeEach(func() {
+		tokens = []string{"plain", secret.SHA256("hashed")}
+		for _, t := range []string{"first", "second", "third"} {
+			h, err := secret.Bcrypt(t, 4)
+			Ω(err).ShouldNot(HaveOccurred())
+			tokens = append(tokens, h)
+		}
+
+		var err error
+		set, err = secret.NewSet(tokens)
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("should find the stored token that a presented token matches", func() {
+		for i, t := range []string{"plain", "hashed", "first", "second", "third"} {
+			n, ok := set.Lookup(t)
+			Ω(ok).Should(BeTrue(), t)
+			Ω(n).Should(Equal(i), t)
+		}
+	})
+
+	It("should not find tokens that match nothing", func() {
+		for _, t := range []string{"", "plai", "other", tokens[1], tokens[2]} {
+			_, ok := set.Lookup(t)
+			Ω(ok).Should(BeFalse(), t)
+		}
+	})
+
+	It("should not store anything about bcrypt-hashed tokens but the hash", func() {
+		h, err := secret.Bcrypt("first", 4)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(h).Should(HavePrefix("bcrypt:$2a$04$"))
+		Ω(strings.Count(h, ":")).Should(Equal(1))
+	})
+
+	It("should refuse more than a handful of bcrypt hashes", func() {
+		l := make([]string, secret.MaxBcrypt+1)
+		for i := range l {
+			l[i] = tokens[2]
+		}
+		_, err := secret.NewSet(l[1:])
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = secret.NewSet(l)
+		Ω(err).Should(HaveOccurred())
+	})
+})
+
+var _ = Describe("secret.Generate()", func() {
+	It("should generate distinct tokens", func() {
+		a, b := secret.Generate(), secret.Generate()
+		Ω(a).ShouldNot(Equal(b))
+		Ω(len(a)).Should(Equal(48))
+	})
+})
@@ -0,0 +1,143 @@
+package secret
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/jhunt/ssg/pkg/rand"
+)
+
+const (
+	SHA256Prefix = "sha256:"
+	BcryptPrefix = "bcrypt:"
+)
+
+func Generate() string {
+	return rand.String(48)
+}
+
+func SHA256(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return SHA256Prefix + hex.EncodeToString(sum[:])
+}
+
+func Bcrypt(token string, cost int) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(token), cost)
+	if err != nil {
+		return "", err
+	}
+	return BcryptPrefix + string(b), nil
+}
+
+func Validate(stored string) error {
+	switch {
+	case strings.HasPrefix(stored, SHA256Prefix):
+		b, err := hex.DecodeString(strings.TrimPrefix(stored, SHA256Prefix))
+		if err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("malformed sha256 hash (expected 64 hexadecimal digits)")
+		}
+
+	case strings.HasPrefix(stored, BcryptPrefix):
+		if _, err := bcrypt.Cost([]byte(strings.TrimPrefix(stored, BcryptPrefix))); err != nil {
+			return fmt.Errorf("malformed bcrypt hash: %s", err)
+		}
+
+	case stored == "":
+		return fmt.Errorf("empty token")
+	}
+	return nil
+}
+
+func Match(stored, presented string) bool {
+	switch {
+	case strings.HasPrefix(stored, SHA256Prefix):
+		want, err := hex.DecodeString(strings.TrimPrefix(stored, SHA256Prefix))
+		if err != nil {
+			return false
+		}
+		got := sha256.Sum256([]byte(presented))
+		return subtle.ConstantTimeCompare(want, got[:]) == 1
+
+	case strings.HasPrefix(stored, BcryptPrefix):
+		return bcrypt.CompareHashAndPassword([]byte(strings.TrimPrefix(stored, BcryptPrefix)), []byte(presented)) == nil
+
+	default:
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(presented)) == 1
+	}
+}
+
+// MaxBcrypt is how many bcrypt hashes a Set will hold.
+// A presented token that matches none of the plaintext or
+// sha256 tokens is checked against each bcrypt hash in
+// turn, so this bounds how much work one request can
+// make us do.
+const MaxBcrypt = 8
+
+// A Set holds a list of stored tokens (see Validate), so
+// that looking up a presented token costs one SHA-256, and
+// at most MaxBcrypt bcrypt comparisons, however many tokens
+// there are.
+type Set struct {
+	stored  []string
+	digests map[[sha256.Size]byte]int
+	bcrypts []int
+}
+
+// NewSet indexes the stored tokens, which must be valid.
+// Sets with more than MaxBcrypt bcrypt hashes are refused.
+func NewSet(stored []string) (*Set, error) {
+	s := &Set{
+		stored:  stored,
+		digests: make(map[[sha256.Size]byte]int),
+	}
+	for i, token := range stored {
+		switch {
+		case strings.HasPrefix(token, SHA256Prefix):
+			var digest [sha256.Size]byte
+			b, err := hex.DecodeString(strings.TrimPrefix(token, SHA256Prefix))
+			if err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("token #%d: malformed sha256 hash", i+1)
+			}
+			copy(digest[:], b)
+			if _, ok := s.digests[digest]; !ok {
+				s.digests[digest] = i
+			}
+
+		case strings.HasPrefix(token, BcryptPrefix):
+			s.bcrypts = append(s.bcrypts, i)
+
+		default:
+			digest := sha256.Sum256([]byte(token))
+			if _, ok := s.digests[digest]; !ok {
+				s.digests[digest] = i
+			}
+		}
+	}
+	if len(s.bcrypts) > MaxBcrypt {
+		return nil, fmt.Errorf("too many bcrypt hashes (%d); at most %d are allowed, use sha256 hashes for the rest", len(s.bcrypts), MaxBcrypt)
+	}
+	return s, nil
+}
+
+// Lookup returns the index of the stored token that the
+// presented token matches, if any.
+func (s *Set) Lookup(presented string) (int, bool) {
+	if s == nil {
+		return -1, false
+	}
+
+	if i, ok := s.digests[sha256.Sum256([]byte(presented))]; ok {
+		return i, true
+	}
+	for _, i := range s.bcrypts {
+		if Match(s.stored[i], presented) {
+			return i, true
+		}
+	}
+	return -1, false
+}
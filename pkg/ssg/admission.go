@@ -0,0 +1,118 @@
+package ssg
+
+import (
+	"fmt"
+
+	"github.com/jhunt/go-route"
+
+	"github.com/jhunt/ssg/pkg/ssg/config"
+)
+
+type busy string
+
+func (e busy) Error() string {
+	return string(e)
+}
+
+func unableToStart(kind string, err error) route.Error {
+	if _, ok := err.(busy); ok {
+		return route.Errorf(503, err, "unable to start %s: %s", kind, err)
+	}
+	if _, ok := err.(protected); ok {
+		return route.Forbidden(err, "unable to start %s: %s", kind, err)
+	}
+	return route.Oops(err, "unable to start %s", kind)
+}
+
+type usage struct {
+	uploads   int
+	downloads int
+	memory    int64
+}
+
+func (u *usage) check(c *config.Concurrency, who, kind string, memory int64) error {
+	if c == nil {
+		return nil
+	}
+
+	switch kind {
+	case "upload":
+		if c.MaxUploads > 0 && u.uploads >= c.MaxUploads {
+			return busy(fmt.Sprintf("%s is already handling %d upload streams (the limit is %d)", who, u.uploads, c.MaxUploads))
+		}
+
+	case "download":
+		if c.MaxDownloads > 0 && u.downloads >= c.MaxDownloads {
+			return busy(fmt.Sprintf("%s is already handling %d download streams (the limit is %d)", who, u.downloads, c.MaxDownloads))
+		}
+	}
+
+	budget := int64(c.MaxBufferMemory) * 1024 * 1024
+	if budget > 0 && memory > 0 && u.memory+memory > budget {
+		return busy(fmt.Sprintf("%s has no room for another %dMiB %s buffer (%dMiB of %dMiB in use)",
+			who, memory/1024/1024, kind, u.memory/1024/1024, c.MaxBufferMemory))
+	}
+	return nil
+}
+
+func (u *usage) add(kind string, n int, memory int64) {
+	if kind == "upload" {
+		u.uploads += n
+	} else {
+		u.downloads += n
+	}
+	u.memory += memory
+}
+
+// reserve admits another stream of the given kind into
+// bucket b, setting aside all of the memory it can hold:
+// its provider's buffers, and (for uploads) a full write
+// queue, whose depth is returned along with the memory.
+func (s *Server) reserve(kind string, b *bucket) (int64, int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	memory := b.buffers[kind]
+	queue := 0
+	if kind == "upload" && s.WriteQueue > 0 {
+		queue = s.WriteQueue
+		memory += int64(queue) * SegmentSize
+	}
+
+	if err := s.usage.check(s.concurrency, "the gateway", kind, memory); err != nil {
+		return 0, 0, err
+	}
+	if err := s.bucketUsage(b.key).check(b.concurrency, "bucket '"+b.key+"'", kind, memory); err != nil {
+		return 0, 0, err
+	}
+
+	s.adjust(kind, b.key, 1, memory)
+	return memory, queue, nil
+}
+
+func (s *Server) unreserve(kind string, b *bucket, memory int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.adjust(kind, b.key, -1, -memory)
+}
+
+// adjust must be called with s.lock held
+func (s *Server) adjust(kind, key string, n int, memory int64) {
+	s.usage.add(kind, n, memory)
+	s.bucketUsage(key).add(kind, n, memory)
+}
+
+// release must be called with s.lock held
+func (s *Server) release(x *stream) {
+	s.adjust(x.kind(), x.bucket.key, -1, -x.reserved)
+}
+
+// bucketUsage must be called with s.lock held
+func (s *Server) bucketUsage(key string) *usage {
+	u, ok := s.inUse[key]
+	if !ok {
+		u = &usage{}
+		s.inUse[key] = u
+	}
+	return u
+}
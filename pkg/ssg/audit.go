@@ -0,0 +1,88 @@
+package ssg
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jhunt/go-log"
+
+	"github.com/jhunt/ssg/pkg/ssg/audit"
+	"github.com/jhunt/ssg/pkg/ssg/config"
+)
+
+func configureAudit(c *config.Audit) (audit.Logger, error) {
+	if c == nil {
+		return audit.Nil, nil
+	}
+
+	switch c.Kind {
+	case "file":
+		log.Infof(LOG+"writing audit records to %s (rotating at %dMiB, keeping %d)", c.File.Path, c.File.MaxSize, c.File.Keep)
+		l, err := audit.File(c.File.Path, int64(c.File.MaxSize)*1024*1024, c.File.Keep)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open audit log %s: %s", c.File.Path, err)
+		}
+		return l, nil
+
+	case "syslog":
+		log.Infof(LOG+"sending audit records to syslog (tag %s)", c.Syslog.Tag)
+		l, err := audit.Syslog(c.Syslog.Network, c.Syslog.Address, c.Syslog.Tag)
+		if err != nil {
+			return nil, fmt.Errorf("unable to connect to syslog for audit records: %s", err)
+		}
+		return l, nil
+	}
+
+	return nil, fmt.Errorf("unrecognized audit kind '%s'", c.Kind)
+}
+
+func (s *Server) record(rec audit.Record) {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	if err := s.auditor.Log(rec); err != nil {
+		log.Errorf(LOG+"unable to write audit record (%s of %s): %s", rec.Event, rec.Canon, err)
+	}
+}
+
+func (by requester) record(event, canon, id string, since time.Time, err error) audit.Record {
+	rec := audit.Record{
+		Event:    event,
+		Identity: by.identity,
+		Remote:   by.remote,
+		Canon:    canon,
+		Stream:   id,
+		Outcome:  "ok",
+		Duration: time.Since(since).Seconds(),
+	}
+	if err != nil {
+		rec.Outcome = "error"
+		rec.Reason = err.Error()
+	}
+	return rec
+}
+
+func (s *stream) record(event, outcome, reason string) audit.Record {
+	p := s.progress(time.Now())
+	return audit.Record{
+		Event:    s.kind() + "." + event,
+		Identity: s.requester.identity,
+		Remote:   s.requester.remote,
+		Canon:    s.canon,
+		Stream:   s.id,
+		Bytes: &audit.Bytes{
+			Compressed:   p.compressed,
+			Uncompressed: p.uncompressed,
+		},
+		Outcome:  outcome,
+		Reason:   reason,
+		Duration: time.Since(s.created).Seconds(),
+	}
+}
+
+func (by requester) String() string {
+	if by.identity == "" {
+		return by.remote
+	}
+	return by.identity + " (" + by.remote + ")"
+}
@@ -0,0 +1,150 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"sync"
+	"time"
+)
+
+type Bytes struct {
+	Compressed   int64 `json:"compressed"`
+	Uncompressed int64 `json:"uncompressed"`
+}
+
+type Record struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Identity string    `json:"identity"`
+	Remote   string    `json:"remote"`
+	Canon    string    `json:"canon"`
+	Stream   string    `json:"stream,omitempty"`
+	Bytes    *Bytes    `json:"bytes,omitempty"`
+	Outcome  string    `json:"outcome"`
+	Reason   string    `json:"reason,omitempty"`
+	Duration float64   `json:"duration"`
+}
+
+type Logger interface {
+	Log(Record) error
+	Close() error
+}
+
+type nilLogger struct{}
+
+func (nilLogger) Log(_ Record) error { return nil }
+func (nilLogger) Close() error       { return nil }
+
+var Nil Logger = nilLogger{}
+
+type FileLogger struct {
+	lock sync.Mutex
+
+	path    string
+	maxSize int64
+	keep    int
+
+	f    *os.File
+	size int64
+}
+
+func File(path string, maxSize int64, keep int) (*FileLogger, error) {
+	l := &FileLogger{
+		path:    path,
+		maxSize: maxSize,
+		keep:    keep,
+	}
+	return l, l.open()
+}
+
+func (l *FileLogger) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.f = f
+	l.size = fi.Size()
+	return nil
+}
+
+func (l *FileLogger) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.keep))
+	for i := l.keep - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if l.keep > 0 {
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return err
+		}
+	} else {
+		if err := os.Remove(l.path); err != nil {
+			return err
+		}
+	}
+
+	return l.open()
+}
+
+func (l *FileLogger) Log(rec Record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(b)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	return err
+}
+
+func (l *FileLogger) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.f.Close()
+}
+
+type SyslogLogger struct {
+	w *syslog.Writer
+}
+
+func Syslog(network, address, tag string) (*SyslogLogger, error) {
+	w, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogLogger{w: w}, nil
+}
+
+func (l *SyslogLogger) Log(rec Record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return l.w.Info(string(b))
+}
+
+func (l *SyslogLogger) Close() error {
+	return l.w.Close()
+}
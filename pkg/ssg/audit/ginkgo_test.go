@@ -0,0 +1,86 @@
+package audit_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+
+	"github.com/jhunt/ssg/pkg/ssg/audit"
+)
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Audit Log Test Suite")
+}
+
+var _ = Describe("audit", func() {
+	var dir string
+
+	BeforeEach(func() {
+		d, err := ioutil.TempDir("", "ssg-audit-")
+		Ω(err).ShouldNot(HaveOccurred())
+		dir = d
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	rec := audit.Record{
+		Time:     time.Now(),
+		Event:    "expunge",
+		Identity: "backups",
+		Remote:   "10.0.0.1",
+		Canon:    "ssg://cluster/bucket/path/to/blob",
+		Outcome:  "ok",
+	}
+
+	Context("file logging", func() {
+		It("should append one JSON record per line", func() {
+			path := filepath.Join(dir, "audit.log")
+			l, err := audit.File(path, 0, 0)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(l.Log(rec)).Should(Succeed())
+			Ω(l.Log(rec)).Should(Succeed())
+			Ω(l.Close()).Should(Succeed())
+
+			b, err := ioutil.ReadFile(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+			Ω(lines).Should(HaveLen(2))
+
+			var out audit.Record
+			Ω(json.Unmarshal([]byte(lines[0]), &out)).Should(Succeed())
+			Ω(out.Event).Should(Equal("expunge"))
+			Ω(out.Identity).Should(Equal("backups"))
+			Ω(out.Canon).Should(Equal("ssg://cluster/bucket/path/to/blob"))
+		})
+
+		It("should rotate files once they grow past the maximum size", func() {
+			path := filepath.Join(dir, "audit.log")
+			l, err := audit.File(path, 256, 2)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			for i := 0; i < 20; i++ {
+				Ω(l.Log(rec)).Should(Succeed())
+			}
+			Ω(l.Close()).Should(Succeed())
+
+			for _, file := range []string{path, path + ".1", path + ".2"} {
+				fi, err := os.Stat(file)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(fi.Size()).Should(BeNumerically("<=", 256))
+			}
+			_, err = os.Stat(path + ".3")
+			Ω(os.IsNotExist(err)).Should(BeTrue())
+		})
+	})
+})
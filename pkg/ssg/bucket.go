@@ -1,6 +1,8 @@
 package ssg
 
 import (
+	"time"
+
 	"github.com/jhunt/go-log"
 
 	"github.com/jhunt/ssg/pkg/ssg/provider"
@@ -8,6 +10,12 @@ import (
 )
 
 func (b *bucket) Upload(s string) (provider.Uploader, error) {
+	if s != "" {
+		if err := b.protected(s, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
 	uploader, err := b.provider.Upload(s)
 	if err != nil {
 		return nil, err
@@ -55,6 +63,9 @@ func (b *bucket) Download(s string) (provider.Downloader, error) {
 
 func (b *bucket) Expunge(s string) error {
 	log.Debugf(LOG+"expunging %s from bucket", s)
+	if err := b.protected(s, time.Now()); err != nil {
+		return err
+	}
 	if b.encryption != "none" {
 		log.Debugf(LOG+"blobs in bucket %v are encrypted; removing cipher parameters from vault", b.key)
 		if err := b.vault.Provider.Delete(s); err != nil {
@@ -63,3 +74,7 @@ func (b *bucket) Expunge(s string) error {
 	}
 	return b.provider.Expunge(s)
 }
+
+func (b *bucket) Deflated() bool {
+	return b.compression == "zlib" && b.encryption == "none"
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Audit represents the configuration of the structured
+// audit log, which records every control operation, and
+// the completion or cancellation of every stream, as a
+// single JSON object.
+//
+type Audit struct {
+	// Kind identifies where audit records are sent.
+	//
+	// Valid values are 'file' and 'syslog'.
+	//
+	Kind string `yaml:"kind"`
+
+	// File contains the configuration for audit logs
+	// whose `Kind` is set to "file".
+	//
+	File struct {
+		// Path is the absolute path to the audit log file.
+		// Records are appended, one per line.
+		//
+		Path string `yaml:"path"`
+
+		// MaxSize is the size (in MiB) that the audit log
+		// can grow to, before it is rotated out to a file
+		// with a numeric suffix (i.e. audit.log.1).
+		//
+		// Defaults to 100MiB.
+		//
+		MaxSize int `yaml:"maxSize"`
+
+		// Keep is how many rotated audit log files to keep
+		// around.  Older files are removed.
+		//
+		// Defaults to 10.
+		//
+		Keep int `yaml:"keep"`
+	} `yaml:"file"`
+
+	// Syslog contains the configuration for audit logs
+	// whose `Kind` is set to "syslog".
+	//
+	Syslog struct {
+		// Network is the network to use when connecting
+		// to a remote syslog daemon, one of 'tcp' or 'udp'.
+		// If empty, the local syslog daemon is used.
+		//
+		Network string `yaml:"network"`
+
+		// Address is the host:port of the remote syslog
+		// daemon.  Ignored if Network is empty.
+		//
+		Address string `yaml:"address"`
+
+		// Tag is the syslog tag (or program name) to
+		// log audit records under.
+		//
+		// Defaults to 'ssg-audit'.
+		//
+		Tag string `yaml:"tag"`
+	} `yaml:"syslog"`
+}
+
+func (audit *Audit) validate() error {
+	switch audit.Kind {
+	case "file":
+		if audit.File.Path == "" {
+			return fmt.Errorf("no audit file path specified")
+		}
+		if !filepath.IsAbs(audit.File.Path) {
+			return fmt.Errorf("audit file path '%s' is not absolute", audit.File.Path)
+		}
+		if audit.File.MaxSize < 0 {
+			return fmt.Errorf("audit file maxSize '%d' is negative", audit.File.MaxSize)
+		}
+		if audit.File.Keep < 0 {
+			return fmt.Errorf("audit file keep '%d' is negative", audit.File.Keep)
+		}
+
+	case "syslog":
+		switch audit.Syslog.Network {
+		case "":
+		case "tcp", "udp":
+			if audit.Syslog.Address == "" {
+				return fmt.Errorf("no audit syslog address specified")
+			}
+		default:
+			return fmt.Errorf("invalid audit syslog network '%s'", audit.Syslog.Network)
+		}
+
+	default:
+		return fmt.Errorf("unrecognized audit kind '%s'", audit.Kind)
+	}
+
+	return nil
+}
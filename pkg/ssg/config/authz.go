@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Authz represents the configuration for delegating
+// authorization decisions to an external policy engine.
+//
+type Authz struct {
+	// Webhook configures a policy service that will be
+	// consulted for every /control request, after the
+	// caller has been authenticated, and after any scope
+	// attached to their token has been checked.
+	//
+	Webhook *Webhook `yaml:"webhook"`
+}
+
+// Webhook represents an HTTP policy service, like an
+// Open Policy Agent sidecar.
+//
+// For each /control request, SSG will POST a JSON
+// document of the form:
+//
+//   {
+//     "input": {
+//       "identity":  "ci-pipeline",
+//       "kind":      "upload",
+//       "operation": "upload",
+//       "cluster":   "prod",
+//       "bucket":    "backups",
+//       "path":      "db/nightly.tar.gz",
+//       "remote":    "10.0.0.5"
+//     }
+//   }
+//
+// and expects back either `{"result": true}` (which is
+// what OPA returns for a boolean rule), or an object
+// like `{"result": {"allow": true, "reason": "..."}}`.
+// A top-level `{"allow": true}` is also accepted.
+// Anything else is treated as a denial.
+//
+type Webhook struct {
+	// URL is the http(s) endpoint to POST decision
+	// requests to.
+	//
+	URL string `yaml:"url"`
+
+	// Timeout is how long (in seconds) to wait for a
+	// decision before treating the request as failed.
+	//
+	// Defaults to 5.
+	//
+	Timeout int `yaml:"timeout"`
+
+	// CacheTTL is how long (in seconds) to remember a
+	// decision for an identical request.  Both allows
+	// and denials are cached; failures are not.  Set
+	// to -1 to disable caching entirely.
+	//
+	// Defaults to 10.
+	//
+	CacheTTL int `yaml:"cacheTTL"`
+
+	// FailOpen controls what happens when the webhook
+	// cannot be reached, times out, or returns an
+	// error.  By default, such requests are denied.
+	// If FailOpen is set, they are allowed (and the
+	// failure is logged).
+	//
+	FailOpen bool `yaml:"failOpen"`
+}
+
+func (w *Webhook) validate() error {
+	if w.URL == "" {
+		return fmt.Errorf("no webhook url specified")
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url '%s': must be an http(s) url", w.URL)
+	}
+	if w.Timeout < 0 {
+		return fmt.Errorf("webhook timeout cannot be negative")
+	}
+	if w.CacheTTL < -1 {
+		return fmt.Errorf("invalid webhook cacheTTL '%d'", w.CacheTTL)
+	}
+	return nil
+}
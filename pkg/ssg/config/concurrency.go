@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Concurrency represents caps on the number of streams
+// that can be active at any one time, and on the memory
+// that their buffers are allowed to take up.  When
+// a /control request would exceed one of these caps, it
+// is refused with a 503 Service Unavailable, instead of
+// being admitted.
+//
+// Each field defaults to 0, which means "no limit".
+//
+type Concurrency struct {
+	// MaxUploads is the most upload streams that can
+	// be open at once.
+	//
+	MaxUploads int `yaml:"maxUploads"`
+
+	// MaxDownloads is the most download streams that
+	// can be open at once.
+	//
+	MaxDownloads int `yaml:"maxDownloads"`
+
+	// MaxBufferMemory is the most memory (in MiB) that
+	// can be set aside for the buffers of open streams.
+	// Each stream counts the most it can hold at once:
+	//
+	//   - uploads, their provider's send buffer (S3, see
+	//     `partSize` and `parallelParts`; GCS, 16MiB),
+	//     plus a full `writeQueue`; and
+	//   - downloads, `workers + 1` chunks, for providers
+	//     configured to download in ranges.
+	//
+	MaxBufferMemory int `yaml:"maxBufferMemory"`
+}
+
+func (c *Concurrency) validate() error {
+	if c.MaxUploads < 0 {
+		return fmt.Errorf("maxUploads cannot be negative")
+	}
+	if c.MaxDownloads < 0 {
+		return fmt.Errorf("maxDownloads cannot be negative")
+	}
+	if c.MaxBufferMemory < 0 {
+		return fmt.Errorf("maxBufferMemory cannot be negative")
+	}
+	return nil
+}
@@ -15,12 +15,28 @@ type Config struct {
 	//
 	Bind string `yaml:"bind"`
 
+	// TLS configures the API to be served over HTTPS,
+	// instead of plaintext HTTP.  If omitted, bearer
+	// tokens and blob data will cross the network
+	// in the clear.
+	//
+	TLS *TLS `yaml:"tls"`
+
 	// MaxLease defines how many seconds an upload
 	// or download can be idle, before it is canceled,
 	// and the token invalidated.
 	//
 	MaxLease int `yaml:"maxLease"`
 
+	// LeaseCeiling defines the longest lease (in seconds)
+	// that a control request can ask for on a per-stream
+	// basis, for producers that are known to stall for
+	// longer than MaxLease between writes.
+	//
+	// Defaults to MaxLease, and cannot be less than it.
+	//
+	LeaseCeiling int `yaml:"leaseCeiling"`
+
 	// SweepInterval defines how often (in seconds)
 	// leases are examined to determine if any of
 	// them have expired and need to be cancelled,
@@ -28,6 +44,87 @@ type Config struct {
 	//
 	SweepInterval int `yaml:"sweepInterval"`
 
+	// LifecycleInterval defines how often (in seconds)
+	// bucket lifecycle rules are evaluated, to expunge
+	// blobs that have gotten too old.
+	//
+	// Defaults to 3600 seconds (one hour).
+	//
+	LifecycleInterval int `yaml:"lifecycleInterval"`
+
+	// ShutdownGrace defines how long (in seconds) the
+	// gateway will wait for in-flight uploads and downloads
+	// to finish when it is asked to shut down, before it
+	// cancels them outright.  New control requests are
+	// refused for the duration.
+	//
+	// Defaults to 30 seconds.
+	//
+	ShutdownGrace int `yaml:"shutdownGrace"`
+
+	// WriteQueue sets how many segments each upload
+	// stream can accept ahead of the storage provider.
+	// Queued segments are acknowledged right away, and
+	// compressed, encrypted and written in the background,
+	// so that clients can send their next segment while
+	// the backend catches up.  A failed write is reported
+	// on the next segment, or at EOF.
+	//
+	// Each upload stream holds up to this many segments
+	// (of 1MiB each) in memory, on top of any provider
+	// buffers, and counts them against `maxBufferMemory`.
+	//
+	// Defaults to 0, which writes each segment through
+	// to the provider before acknowledging it.
+	//
+	WriteQueue int `yaml:"writeQueue"`
+
+	// Timeouts contains settings for how long the HTTP
+	// server will wait on slow (or absent) clients.  All
+	// timeouts are in seconds.
+	Timeouts struct {
+		// ReadHeader sets how long clients have to send
+		// the headers of each request.
+		//
+		// Defaults to 30 seconds.
+		//
+		ReadHeader int `yaml:"readHeader"`
+
+		// Read sets how long clients have to send an
+		// entire request, including its body.  Since a
+		// raw binary upload can be arbitrarily large,
+		// this is disabled (zero) by default.
+		//
+		Read int `yaml:"read"`
+
+		// Write sets how long the gateway has to send
+		// an entire response, including its body.  Since
+		// a download can be arbitrarily large, this is
+		// disabled (zero) by default.
+		//
+		Write int `yaml:"write"`
+
+		// Idle sets how long keep-alive connections can
+		// sit idle between requests before they are closed.
+		//
+		// Defaults to 120 seconds.
+		//
+		Idle int `yaml:"idle"`
+	} `yaml:"timeouts"`
+
+	// Audit configures the structured audit log of
+	// control operations and stream completions.  If
+	// omitted, no audit records are kept.
+	//
+	Audit *Audit `yaml:"audit"`
+
+	// Concurrency caps the number of streams (and the
+	// amount of buffer memory) that the gateway as a
+	// whole will take on at once.  Buckets can carry
+	// their own, tighter caps.
+	//
+	Concurrency *Concurrency `yaml:"concurrency"`
+
 	// Metrics contains settings related to metrics,
 	// monitoring, and measurements.
 	Metrics struct {
@@ -47,12 +144,51 @@ type Config struct {
 	// are allowed to orchestrate upload, download,
 	// and deletion of blobs.
 	//
+	// Each entry can either be the token itself, or
+	// a hash of the token, as 'sha256:<hex digest>' or
+	// 'bcrypt:<bcrypt hash>'.  `ssg token generate` will
+	// create a new random token and its hash.  Each request
+	// may be checked against every bcrypt hash, so only a
+	// few (see secret.MaxBcrypt) are allowed.
+	//
 	ControlTokens []string `yaml:"controlTokens"`
 
+	// Tokens is a list of named control tokens, each of
+	// which can be limited to certain operations, buckets,
+	// and paths.  These are checked in addition to any
+	// unrestricted ControlTokens.
+	//
+	Tokens []Token `yaml:"tokens"`
+
+	// JWT configures the validation of signed JSON
+	// Web Tokens, so that systems with their own OIDC
+	// identity can authenticate without a static token.
+	//
+	JWT *JWT `yaml:"jwt"`
+
+	// Signing configures the keys and limits for
+	// pre-signed download and upload URLs.  If omitted,
+	// pre-signed URLs are disabled.
+	//
+	Signing *Signing `yaml:"signing"`
+
+	// Tus configures the tus.io resumable upload
+	// endpoints, which are always enabled.
+	//
+	Tus Tus `yaml:"tus"`
+
+	// Authz configures an external policy engine that
+	// gets the final say on every /control request.  If
+	// omitted, token scopes are the only restriction.
+	//
+	Authz *Authz `yaml:"authz"`
+
 	// MonitorTokens is a list of all monitor bearer
 	// tokens, which should be given to systems that
 	// track the health and wellbeing of the cluster.
 	//
+	// As with ControlTokens, entries can be hashed.
+	//
 	MonitorTokens []string `yaml:"monitorTokens"`
 
 	// DefaultBucket contains global defaults for
@@ -134,6 +270,30 @@ type Config struct {
 		//
 		Vault *Vault `yaml:"vault"`
 
+		// Limits caps the bandwidth and request rate of
+		// this bucket, across all callers.  Callers whose
+		// token carries its own limits are subject to
+		// both.
+		//
+		Limits *Limits `yaml:"limits"`
+
+		// Concurrency caps the number of streams (and the
+		// amount of buffer memory) that this bucket will
+		// take on at once, on top of the global caps.
+		//
+		Concurrency *Concurrency `yaml:"concurrency"`
+
+		// Lifecycle lists the rules for expunging old
+		// blobs from this bucket automatically.
+		//
+		Lifecycle []Lifecycle `yaml:"lifecycle"`
+
+		// Retention keeps blobs from being expunged or
+		// overwritten until they have been kept for some
+		// minimum amount of time.
+		//
+		Retention *Retention `yaml:"retention"`
+
 		// Provider specifies the configuration details
 		// of the backing storage provider, and depends
 		// quite heavily on the specific system being
@@ -4,8 +4,35 @@ var Default Config
 
 func init() {
 	Default.Bind = ":8080"
+	Default.TLS = &TLS{
+		MinVersion: "1.2",
+		ClientAuth: "required",
+	}
+	Default.JWT = &JWT{
+		Leeway:   30,
+		Refresh:  3600,
+		Identity: "sub",
+	}
+	Default.Signing = &Signing{
+		MaxLifetime:   86400,
+		MaxUploadSize: 1024 * 1024 * 1024,
+	}
+	Default.Authz = &Authz{
+		Webhook: &Webhook{
+			Timeout:  5,
+			CacheTTL: 10,
+		},
+	}
+	Default.Audit = &Audit{}
+	Default.Audit.File.MaxSize = 100
+	Default.Audit.File.Keep = 10
+	Default.Audit.Syslog.Tag = "ssg-audit"
 	Default.MaxLease = 600
 	Default.SweepInterval = 1
+	Default.LifecycleInterval = 3600
+	Default.ShutdownGrace = 30
+	Default.Timeouts.ReadHeader = 30
+	Default.Timeouts.Idle = 120
 	Default.Metrics.ReservoirSize = 100
 	Default.DefaultBucket.Compression = "none"
 	Default.DefaultBucket.Encryption = "aes256-ctr"
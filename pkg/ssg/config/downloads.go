@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Downloads represents how a storage provider fetches
+// large blobs from its backend.  By default, each blob
+// is retrieved in a single request; with more than one
+// worker, blobs larger than a single chunk are instead
+// retrieved as several concurrent ranged requests, and
+// reassembled in order.
+//
+// Each download holds up to `workers + 1` chunks in
+// memory at once, which counts against `maxBufferMemory`.
+//
+// Every range is pinned to the version of the blob that
+// the download started with (by ETag, or by generation
+// on GCS), so a blob overwritten mid-download fails the
+// download, instead of mixing old and new data.
+//
+type Downloads struct {
+	// Workers sets how many ranged requests a single
+	// download can have in flight at once.
+	//
+	// Defaults to 1 (no ranged requests).
+	//
+	Workers int `yaml:"workers"`
+
+	// ChunkSize sets the size of each ranged request,
+	// in MiB (1024 * 1024 bytes).
+	//
+	// Defaults to 8.
+	//
+	ChunkSize int `yaml:"chunkSize"`
+}
+
+func (d *Downloads) validate() error {
+	if d.Workers < 0 {
+		return fmt.Errorf("download workers cannot be negative")
+	}
+	if d.ChunkSize < 0 {
+		return fmt.Errorf("download chunkSize cannot be negative")
+	}
+	return nil
+}
@@ -30,6 +30,11 @@ type GCS struct {
 	// with a trailing forward slash ('/').
 	//
 	Prefix string `yaml:"prefix"`
+
+	// Downloads configures fetching large objects from
+	// GCS as several concurrent ranged requests.
+	//
+	Downloads Downloads `yaml:"downloads"`
 }
 
 func (gcs *GCS) validate() error {
@@ -41,5 +46,9 @@ func (gcs *GCS) validate() error {
 		return fmt.Errorf("no bucket provided")
 	}
 
+	if err := gcs.Downloads.validate(); err != nil {
+		return err
+	}
+
 	return nil
 }
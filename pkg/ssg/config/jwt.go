@@ -0,0 +1,145 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// JWT represents the configuration for accepting signed
+// JSON Web Tokens (i.e. OIDC identity tokens minted by a
+// CI/CD system) as bearer tokens, alongside the static
+// control and monitor tokens.
+//
+type JWT struct {
+	// JWKS is either the path to a file, or an http(s)
+	// URL, containing the JSON Web Key Set of public keys
+	// that tokens must be signed by.
+	//
+	// Key sets fetched from a URL are refreshed
+	// periodically (see Refresh), and whenever a token
+	// is signed by a key the gateway hasn't seen yet.
+	//
+	JWKS string `yaml:"jwks"`
+
+	// Issuer must match the `iss` claim of every
+	// token, exactly.
+	//
+	Issuer string `yaml:"issuer"`
+
+	// Audience must be present in the `aud` claim
+	// of every token.
+	//
+	Audience string `yaml:"audience"`
+
+	// Leeway is how many seconds of clock skew to allow
+	// when checking the `exp` and `nbf` claims.
+	//
+	// Defaults to 30.
+	//
+	Leeway int `yaml:"leeway"`
+
+	// Refresh is how often (in seconds) to re-fetch
+	// the JWKS, if it was given as a URL.
+	//
+	// Defaults to 3600 (1 hour).
+	//
+	Refresh int `yaml:"refresh"`
+
+	// Identity names the claim to use as the identity
+	// of the caller, in the audit log and the stream
+	// listing.
+	//
+	// Defaults to 'sub'.
+	//
+	Identity string `yaml:"identity"`
+
+	// Roles maps the claims of verified tokens onto SSG
+	// roles and bucket scopes.  The first matching entry
+	// wins; tokens that match no entry are rejected.
+	//
+	Roles []JWTRole `yaml:"roles"`
+}
+
+// A JWTRole grants a role (and for the control role, an
+// optional scope) to all tokens whose claims match.
+//
+type JWTRole struct {
+	// Claims maps claim names to glob patterns (i.e.
+	// 'repo:acme/*') that the claim value must match.
+	// For list-valued claims (like `groups`), any one
+	// value can match.  All entries must match for the
+	// role to be granted.
+	//
+	Claims map[string]string `yaml:"claims"`
+
+	// Role identifies the role to grant; one of
+	// either 'control' or 'monitor'.
+	//
+	Role string `yaml:"role"`
+
+	// Operations, Buckets and Prefixes restrict what a
+	// control token can do, exactly like the fields of
+	// the same name on named `tokens`.
+	//
+	Operations []string `yaml:"operations"`
+	Buckets    []string `yaml:"buckets"`
+	Prefixes   []string `yaml:"prefixes"`
+
+	// Limits caps the bandwidth and request rate of each
+	// identity (see JWT.Identity) granted this role.
+	// Every identity gets its own allowance, so tokens
+	// without the identity claim are refused.
+	//
+	Limits *Limits `yaml:"limits"`
+}
+
+func (jwt *JWT) validate() error {
+	if jwt.JWKS == "" {
+		return fmt.Errorf("no jwks file or url specified")
+	}
+	if strings.HasPrefix(jwt.JWKS, "http://") || strings.HasPrefix(jwt.JWKS, "https://") {
+		if jwt.Refresh <= 0 {
+			return fmt.Errorf("jwt refresh interval must be positive")
+		}
+	} else if !strings.HasPrefix(jwt.JWKS, "/") {
+		return fmt.Errorf("jwks '%s' is neither an absolute path nor an http(s) url", jwt.JWKS)
+	}
+
+	if jwt.Issuer == "" {
+		return fmt.Errorf("no jwt issuer specified")
+	}
+	if jwt.Audience == "" {
+		return fmt.Errorf("no jwt audience specified")
+	}
+	if jwt.Leeway < 0 {
+		return fmt.Errorf("jwt leeway cannot be negative")
+	}
+
+	if len(jwt.Roles) == 0 {
+		return fmt.Errorf("no jwt roles specified")
+	}
+	for i, r := range jwt.Roles {
+		if len(r.Claims) == 0 {
+			return fmt.Errorf("no claims specified for jwt role #%d", i+1)
+		}
+		for claim, pattern := range r.Claims {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern '%s' for claim '%s' of jwt role #%d: %s", pattern, claim, i+1, err)
+			}
+		}
+		if r.Role != "control" && r.Role != "monitor" {
+			return fmt.Errorf("invalid role '%s' for jwt role #%d", r.Role, i+1)
+		}
+		if err := validateScope(r.Operations, r.Buckets, r.Prefixes); err != nil {
+			return fmt.Errorf("%s for jwt role #%d", err, i+1)
+		}
+		if r.Limits != nil {
+			if err := r.Limits.validate(); err != nil {
+				return fmt.Errorf("invalid limits for jwt role #%d: %s", i+1, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Lifecycle represents a rule for pruning old blobs from a
+// bucket, so that operators don't have to write their own
+// cron jobs to do it.  Blobs are expunged through the
+// bucket, so their encryption parameters are removed from
+// the vault as well.
+//
+// Only buckets whose storage providers can list their
+// blobs (fs, gcs and s3) support lifecycle rules.
+//
+type Lifecycle struct {
+	// Prefix limits the rule to blobs whose paths start
+	// with this string.  If omitted, the rule applies to
+	// every blob in the bucket.
+	//
+	Prefix string `yaml:"prefix"`
+
+	// ExpungeAfter sets how old (in days, since it was
+	// last modified) a blob has to be before it is
+	// expunged.
+	//
+	ExpungeAfter int `yaml:"expungeAfter"`
+
+	// DryRun logs what the rule would expunge, without
+	// actually expunging anything.
+	//
+	DryRun bool `yaml:"dryRun"`
+}
+
+func (l Lifecycle) validate() error {
+	if l.ExpungeAfter <= 0 {
+		return fmt.Errorf("expungeAfter must be at least 1 day")
+	}
+	return nil
+}
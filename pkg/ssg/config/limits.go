@@ -0,0 +1,53 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Limits represents a set of token-bucket rate limits,
+// which can be attached to a bucket, or to a named
+// control token (or JWT role).
+//
+type Limits struct {
+	// BytesPerSecond caps the combined throughput of all
+	// streams (uploads and downloads) subject to these
+	// limits.  Streams that go over are slowed down,
+	// rather than failed, so clients see back-pressure.
+	// Up to one second's worth of bytes can be sent in
+	// a single burst.
+	//
+	// If zero, throughput is not limited.
+	//
+	BytesPerSecond int64 `yaml:"bytesPerSecond"`
+
+	// RequestsPerSecond caps how often new streams can
+	// be started (or pre-signed, or expunged) via the
+	// /control endpoint.  Requests that go over are
+	// rejected with a 429 Too Many Requests, with a
+	// Retry-After header.  Fractional rates (i.e. 0.1
+	// for one every ten seconds) are allowed.
+	//
+	// If zero, requests are not limited.
+	//
+	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
+
+	// Burst is how many requests can be made back to
+	// back before RequestsPerSecond kicks in.
+	//
+	// Defaults to RequestsPerSecond, rounded up.
+	//
+	Burst int `yaml:"burst"`
+}
+
+func (l *Limits) validate() error {
+	if l.BytesPerSecond < 0 {
+		return fmt.Errorf("bytesPerSecond cannot be negative")
+	}
+	if l.RequestsPerSecond < 0 {
+		return fmt.Errorf("requestsPerSecond cannot be negative")
+	}
+	if l.Burst < 0 {
+		return fmt.Errorf("burst cannot be negative")
+	}
+	return nil
+}
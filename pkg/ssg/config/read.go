@@ -5,8 +5,11 @@ import (
 	"io/ioutil"
 	"os"
 	"regexp"
+	"time"
 
 	"gopkg.in/yaml.v2"
+
+	"github.com/jhunt/ssg/pkg/secret"
 )
 
 func ReadFile(path string) (Config, error) {
@@ -70,12 +73,79 @@ func (c Config) Resolve() (Config, error) {
 	if c.Bind == "" {
 		c.Bind = Default.Bind
 	}
+	if c.TLS != nil {
+		if c.TLS.MinVersion == "" {
+			c.TLS.MinVersion = Default.TLS.MinVersion
+		}
+		if c.TLS.ClientAuth == "" && c.TLS.ClientCA != nil {
+			c.TLS.ClientAuth = Default.TLS.ClientAuth
+		}
+		for i, r := range c.TLS.Roles {
+			// certificates have to be explicitly unrestricted
+			if r.Role == "control" && !r.Unrestricted && len(r.Operations) == 0 {
+				c.TLS.Roles[i].Operations = []string{"upload", "download"}
+			}
+		}
+	}
+	if c.JWT != nil {
+		if c.JWT.Leeway == 0 {
+			c.JWT.Leeway = Default.JWT.Leeway
+		}
+		if c.JWT.Refresh == 0 {
+			c.JWT.Refresh = Default.JWT.Refresh
+		}
+		if c.JWT.Identity == "" {
+			c.JWT.Identity = Default.JWT.Identity
+		}
+	}
+	if c.Signing != nil {
+		if c.Signing.MaxLifetime == 0 {
+			c.Signing.MaxLifetime = Default.Signing.MaxLifetime
+		}
+		if c.Signing.MaxUploadSize == 0 {
+			c.Signing.MaxUploadSize = Default.Signing.MaxUploadSize
+		}
+	}
+	if c.Authz != nil && c.Authz.Webhook != nil {
+		if c.Authz.Webhook.Timeout == 0 {
+			c.Authz.Webhook.Timeout = Default.Authz.Webhook.Timeout
+		}
+		if c.Authz.Webhook.CacheTTL == 0 {
+			c.Authz.Webhook.CacheTTL = Default.Authz.Webhook.CacheTTL
+		}
+	}
+	if c.Audit != nil {
+		if c.Audit.File.MaxSize == 0 {
+			c.Audit.File.MaxSize = Default.Audit.File.MaxSize
+		}
+		if c.Audit.File.Keep == 0 {
+			c.Audit.File.Keep = Default.Audit.File.Keep
+		}
+		if c.Audit.Syslog.Tag == "" {
+			c.Audit.Syslog.Tag = Default.Audit.Syslog.Tag
+		}
+	}
 	if c.MaxLease <= 0 {
 		c.MaxLease = Default.MaxLease
 	}
+	if c.LeaseCeiling <= 0 {
+		c.LeaseCeiling = c.MaxLease
+	}
 	if c.SweepInterval <= 0 {
 		c.SweepInterval = Default.SweepInterval
 	}
+	if c.LifecycleInterval <= 0 {
+		c.LifecycleInterval = Default.LifecycleInterval
+	}
+	if c.ShutdownGrace <= 0 {
+		c.ShutdownGrace = Default.ShutdownGrace
+	}
+	if c.Timeouts.ReadHeader == 0 {
+		c.Timeouts.ReadHeader = Default.Timeouts.ReadHeader
+	}
+	if c.Timeouts.Idle == 0 {
+		c.Timeouts.Idle = Default.Timeouts.Idle
+	}
 	if c.Metrics.ReservoirSize <= 0 {
 		c.Metrics.ReservoirSize = Default.Metrics.ReservoirSize
 	}
@@ -93,9 +163,84 @@ func (c Config) Resolve() (Config, error) {
 	if c.Cluster == "" {
 		return c, fmt.Errorf("no cluster identity specified")
 	}
-	if c.ControlTokens == nil || len(c.ControlTokens) == 0 {
+	if c.TLS != nil {
+		if err := c.TLS.validate(); err != nil {
+			return c, fmt.Errorf("invalid tls configuration: %s", err)
+		}
+	}
+
+	if err := c.Tus.validate(); err != nil {
+		return c, err
+	}
+
+	if c.Signing != nil {
+		if err := c.Signing.validate(); err != nil {
+			return c, fmt.Errorf("invalid signing configuration: %s", err)
+		}
+	}
+	if c.Authz != nil && c.Authz.Webhook != nil {
+		if err := c.Authz.Webhook.validate(); err != nil {
+			return c, fmt.Errorf("invalid authz webhook configuration: %s", err)
+		}
+	}
+	if c.Concurrency != nil {
+		if err := c.Concurrency.validate(); err != nil {
+			return c, fmt.Errorf("invalid concurrency configuration: %s", err)
+		}
+	}
+	if c.Audit != nil {
+		if err := c.Audit.validate(); err != nil {
+			return c, fmt.Errorf("invalid audit configuration: %s", err)
+		}
+	}
+	if c.Timeouts.ReadHeader < 0 || c.Timeouts.Read < 0 || c.Timeouts.Write < 0 || c.Timeouts.Idle < 0 {
+		return c, fmt.Errorf("http timeouts cannot be negative")
+	}
+	if c.WriteQueue < 0 {
+		return c, fmt.Errorf("writeQueue cannot be negative")
+	}
+	if c.LeaseCeiling < c.MaxLease {
+		return c, fmt.Errorf("leaseCeiling (%d) cannot be less than maxLease (%d)", c.LeaseCeiling, c.MaxLease)
+	}
+	if len(c.ControlTokens) == 0 && len(c.Tokens) == 0 && c.JWT == nil {
 		return c, fmt.Errorf("no controlTokens specified")
 	}
+	if c.JWT != nil {
+		if err := c.JWT.validate(); err != nil {
+			return c, fmt.Errorf("invalid jwt configuration: %s", err)
+		}
+	}
+	for i, token := range c.ControlTokens {
+		if err := secret.Validate(token); err != nil {
+			return c, fmt.Errorf("invalid control token #%d: %s", i+1, err)
+		}
+	}
+	for i, token := range c.MonitorTokens {
+		if err := secret.Validate(token); err != nil {
+			return c, fmt.Errorf("invalid monitor token #%d: %s", i+1, err)
+		}
+	}
+	names := make(map[string]bool)
+	for i, token := range c.Tokens {
+		if err := token.validate(); err != nil {
+			return c, fmt.Errorf("invalid token #%d: %s", i+1, err)
+		}
+		if names[token.Name] {
+			return c, fmt.Errorf("invalid token #%d: duplicate name '%s'", i+1, token.Name)
+		}
+		names[token.Name] = true
+	}
+	control := make([]string, 0, len(c.ControlTokens)+len(c.Tokens))
+	control = append(control, c.ControlTokens...)
+	for _, token := range c.Tokens {
+		control = append(control, token.Token)
+	}
+	if _, err := secret.NewSet(control); err != nil {
+		return c, fmt.Errorf("invalid control tokens: %s", err)
+	}
+	if _, err := secret.NewSet(c.MonitorTokens); err != nil {
+		return c, fmt.Errorf("invalid monitor tokens: %s", err)
+	}
 
 	// validate default bucket configuration
 	if !validCompression(c.DefaultBucket.Compression) {
@@ -142,6 +287,30 @@ func (c Config) Resolve() (Config, error) {
 				return c, fmt.Errorf("invalid vault configuration for encrypted bucket '%s': %s", bucket.Key, err)
 			}
 		}
+		if bucket.Limits != nil {
+			if err := bucket.Limits.validate(); err != nil {
+				return c, fmt.Errorf("invalid limits for bucket '%s': %s", bucket.Key, err)
+			}
+		}
+		if bucket.Concurrency != nil {
+			if err := bucket.Concurrency.validate(); err != nil {
+				return c, fmt.Errorf("invalid concurrency configuration for bucket '%s': %s", bucket.Key, err)
+			}
+		}
+		if bucket.Retention != nil {
+			if err := bucket.Retention.validate(); err != nil {
+				return c, fmt.Errorf("invalid retention for bucket '%s': %s", bucket.Key, err)
+			}
+		}
+		for j, rule := range bucket.Lifecycle {
+			if err := rule.validate(); err != nil {
+				return c, fmt.Errorf("invalid lifecycle rule #%d for bucket '%s': %s", j+1, bucket.Key, err)
+			}
+			if after := time.Duration(rule.ExpungeAfter) * 24 * time.Hour; after < bucket.Retention.Period() {
+				return c, fmt.Errorf("lifecycle rule #%d for bucket '%s' expunges blobs after %d days, before their minimum retention of %s is up",
+					j+1, bucket.Key, rule.ExpungeAfter, bucket.Retention.Minimum)
+			}
+		}
 
 		// validate bucket provider
 		switch bucket.Provider.Kind {
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retention represents write-once-read-many (WORM)
+// protection for the blobs in a bucket.  While a blob is
+// retained, or under legal hold, the gateway refuses to
+// expunge it, or to overwrite it with a new upload.
+//
+// Legal holds are placed on (and released from) specific
+// blobs through the control API, and are available to
+// any bucket whose storage provider supports them (fs,
+// gcs and s3), whether or not it has a Retention.
+//
+type Retention struct {
+	// Minimum sets how long (since it was last modified)
+	// a blob must be kept before it can be expunged or
+	// overwritten.  This is given as a number of days,
+	// hours, minutes or seconds, i.e. '30d' or '36h'.
+	//
+	// For s3 buckets with `objectLock` configured, this
+	// also sets the retain-until date of new uploads.
+	//
+	Minimum string `yaml:"minimum"`
+}
+
+// Period returns the minimum retention period, or 0 if
+// there is none.
+func (r *Retention) Period() time.Duration {
+	if r == nil {
+		return 0
+	}
+	d, _ := parsePeriod(r.Minimum)
+	return d
+}
+
+func (r *Retention) validate() error {
+	d, err := parsePeriod(r.Minimum)
+	if err != nil {
+		return err
+	}
+	if d <= 0 {
+		return fmt.Errorf("minimum retention must be positive")
+	}
+	return nil
+}
+
+func parsePeriod(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("no minimum retention period provided")
+	}
+
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"h": time.Hour,
+		"m": time.Minute,
+		"s": time.Second,
+	}
+	unit, ok := units[s[len(s)-1:]]
+	if !ok {
+		return 0, fmt.Errorf("retention period '%s' has no unit (d, h, m or s)", s)
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s[:len(s)-1]), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("retention period '%s' is malformed", s)
+	}
+	return time.Duration(n) * unit, nil
+}
@@ -50,6 +50,48 @@ type S3 struct {
 	//
 	PartSize int `json:"partSize"`
 
+	// ParallelParts sets how many parts of a single
+	// upload can be sent to the S3 API server at once.
+	// Parts are always assembled in order, no matter
+	// which finishes first.  Each upload holds up to
+	// this many PartSize buffers in memory, which
+	// counts against `maxBufferMemory`.
+	//
+	// Defaults to 1 (one part at a time).
+	//
+	ParallelParts int `yaml:"parallelParts"`
+
+	// Downloads configures fetching large objects from
+	// the S3 API server as several concurrent ranged GETs.
+	//
+	Downloads Downloads `yaml:"downloads"`
+
+	// ObjectLock enables S3 Object Lock for new uploads,
+	// in either 'governance' or 'compliance' mode, so that
+	// the S3 API server itself refuses to delete blobs
+	// before their bucket's minimum retention is up, or
+	// while they are under legal hold.
+	//
+	// The S3 bucket must have been created with Object
+	// Lock enabled.  Leave this unset to enforce retention
+	// and legal holds in the gateway alone.
+	//
+	// Object Lock buckets are always versioned, so once
+	// a blob can be expunged, deleting it only adds a
+	// delete marker; the data is kept (and billed) until
+	// the bucket's lifecycle rules expire old versions.
+	//
+	ObjectLock string `yaml:"objectLock"`
+
+	// Timeout determines how long, in seconds, any single
+	// request to the S3 API can take before it is forcibly
+	// disconnected.  Downloads of whole blobs only have to
+	// start sending data within this time.
+	//
+	// Defaults to 300 (five minutes).
+	//
+	Timeout int `yaml:"timeout"`
+
 	// AccessKeyID contains the Access Key ID to use for
 	// authenticating to the S3 API.
 	//
@@ -89,6 +131,22 @@ func (s3 *S3) validate() error {
 		return fmt.Errorf("no bucket provided")
 	}
 
+	if s3.ParallelParts < 0 {
+		return fmt.Errorf("parallelParts cannot be negative")
+	}
+
+	if err := s3.Downloads.validate(); err != nil {
+		return err
+	}
+
+	if s3.Timeout < 0 {
+		return fmt.Errorf("s3 timeout '%d' is negative", s3.Timeout)
+	}
+
+	if s3.ObjectLock != "" && s3.ObjectLock != "governance" && s3.ObjectLock != "compliance" {
+		return fmt.Errorf("invalid objectLock mode '%s' (must be either 'governance' or 'compliance')", s3.ObjectLock)
+	}
+
 	iam := s3.InstanceMetadata
 	aki := s3.AccessKeyID != "" && s3.SecretAccessKey != ""
 
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Signing represents the configuration for pre-signed
+// URLs, which allow anyone holding the URL to download
+// (or upload) a single blob until the URL expires,
+// without a control token.
+//
+type Signing struct {
+	// Keys lists the HMAC keys used to sign and verify
+	// pre-signed URLs.  New URLs are always signed with
+	// the first key; URLs signed by any of the listed
+	// keys are accepted.  To rotate, add a new key to
+	// the top of the list, and remove the old key once
+	// the URLs it signed have expired.
+	//
+	Keys []SigningKey `yaml:"keys"`
+
+	// MaxLifetime is the longest (in seconds) that a
+	// pre-signed URL can be valid for.
+	//
+	// Defaults to 86400 (24 hours).
+	//
+	MaxLifetime int `yaml:"maxLifetime"`
+
+	// MaxUploadSize is the largest size (in bytes) that
+	// a pre-signed upload URL can allow.  Every pre-signed
+	// upload URL carries its own (smaller) size limit.
+	//
+	// Defaults to 1073741824 (1GiB).
+	//
+	MaxUploadSize int64 `yaml:"maxUploadSize"`
+}
+
+// A SigningKey is a named secret key for signing
+// pre-signed URLs.
+//
+type SigningKey struct {
+	// ID is a short identifier for this key, which is
+	// embedded in every URL it signs.
+	//
+	ID string `yaml:"id"`
+
+	// Secret is the HMAC-SHA256 key itself.  It should
+	// be at least 32 random characters.
+	//
+	Secret string `yaml:"secret"`
+}
+
+func (signing *Signing) validate() error {
+	if len(signing.Keys) == 0 {
+		return fmt.Errorf("no signing keys specified")
+	}
+
+	ids := make(map[string]bool)
+	for i, k := range signing.Keys {
+		if k.ID == "" {
+			return fmt.Errorf("no id specified for signing key #%d", i+1)
+		}
+		if ids[k.ID] {
+			return fmt.Errorf("duplicate id '%s' for signing key #%d", k.ID, i+1)
+		}
+		ids[k.ID] = true
+
+		if len(k.Secret) < 32 {
+			return fmt.Errorf("secret for signing key '%s' is too short (must be at least 32 characters)", k.ID)
+		}
+	}
+
+	if signing.MaxLifetime < 0 {
+		return fmt.Errorf("signing maxLifetime cannot be negative")
+	}
+	if signing.MaxUploadSize < 0 {
+		return fmt.Errorf("signing maxUploadSize cannot be negative")
+	}
+	return nil
+}
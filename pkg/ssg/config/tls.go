@@ -0,0 +1,172 @@
+package config
+
+import (
+	"fmt"
+	"path"
+)
+
+// TLS represents the configuration for serving the
+// SSG API over HTTPS, and (optionally) requiring
+// clients to present X.509 certificates of their own,
+// a.k.a. mutual TLS.
+//
+type TLS struct {
+	// Certificate supplies the path to a file containing
+	// the PEM-encoded X.509 certificate (and any intermediate
+	// certificates) that the SSG will present to clients.
+	//
+	// This file (and Key) will be re-read whenever it
+	// changes on-disk, so that certificates can be rotated
+	// without restarting the gateway.
+	//
+	Certificate string `yaml:"certificate"`
+
+	// Key supplies the path to a file containing the
+	// PEM-encoded private key for Certificate.
+	//
+	Key string `yaml:"key"`
+
+	// MinVersion sets the lowest version of the TLS
+	// protocol that the SSG will negotiate with clients.
+	//
+	// Valid values are '1.0', '1.1', '1.2', and '1.3'.
+	// Defaults to '1.2'.
+	//
+	MinVersion string `yaml:"minVersion"`
+
+	// ClientCA provides the Certificate Authority configuration
+	// to use when validating X.509 Certificates presented by
+	// clients.  If set, clients will be asked for certificates.
+	//
+	// System-provided root authorities are never trusted for
+	// client certificates; only the authorities specified here
+	// are considered.
+	//
+	ClientCA *CA `yaml:"clientCA"`
+
+	// ClientAuth determines whether or not clients must
+	// present a valid certificate to connect, if ClientCA
+	// is set.
+	//
+	// Valid values are 'required' (the default) and 'optional'.
+	// With 'optional', clients that do not present a certificate
+	// can still authenticate via bearer tokens.
+	//
+	ClientAuth string `yaml:"clientAuth"`
+
+	// Roles maps the subjects of verified client certificates
+	// onto SSG roles, allowing clients to authenticate without
+	// a bearer token.  The first matching entry wins.
+	//
+	Roles []TLSRole `yaml:"roles"`
+}
+
+// A TLSRole grants a role to all clients whose
+// certificates match a given subject pattern.
+//
+type TLSRole struct {
+	// Subject is a glob pattern (i.e. 'backup-*'), matched
+	// against either the Common Name of the client certificate,
+	// or its full RFC 2253 distinguished name (i.e.
+	// 'CN=backups,O=Operations').
+	//
+	Subject string `yaml:"subject"`
+
+	// Role identifies the role to grant; one of
+	// either 'control' or 'monitor'.
+	//
+	Role string `yaml:"role"`
+
+	// Operations, Buckets and Prefixes restrict what a
+	// control client can do, exactly like the fields of
+	// the same name on named `tokens`.
+	//
+	// Unlike tokens, a control role that sets none of
+	// these is not unrestricted; its clients can only
+	// upload and download.  Set Unrestricted to grant
+	// everything, including POST /reload.
+	//
+	Operations []string `yaml:"operations"`
+	Buckets    []string `yaml:"buckets"`
+	Prefixes   []string `yaml:"prefixes"`
+
+	// Unrestricted grants control clients every
+	// operation on every bucket, and the ability to
+	// reload the configuration.  It cannot be combined
+	// with Operations, Buckets or Prefixes.
+	//
+	// Releasing legal holds is never implied; only roles
+	// that list 'release' in Operations can do that.
+	//
+	Unrestricted bool `yaml:"unrestricted"`
+
+	// Limits caps the bandwidth and request rate of
+	// each client (by certificate Common Name, or full
+	// subject if it has none) granted this role.
+	//
+	Limits *Limits `yaml:"limits"`
+}
+
+func (tls *TLS) validate() error {
+	if tls.Certificate == "" {
+		return fmt.Errorf("no tls certificate specified")
+	}
+	if tls.Key == "" {
+		return fmt.Errorf("no tls key specified")
+	}
+
+	switch tls.MinVersion {
+	case "1.0", "1.1", "1.2", "1.3":
+	default:
+		return fmt.Errorf("invalid tls minVersion '%s'", tls.MinVersion)
+	}
+
+	if tls.ClientCA != nil {
+		if tls.ClientCA.SkipVerification {
+			return fmt.Errorf("tls clientCA cannot skip verification")
+		}
+		if tls.ClientCA.File == "" && tls.ClientCA.Literal == "" {
+			return fmt.Errorf("no tls clientCA certificates specified")
+		}
+		if tls.ClientCA.File != "" && tls.ClientCA.Literal != "" {
+			return fmt.Errorf("tls clientCA file and literal are mutually exclusive")
+		}
+	}
+
+	switch tls.ClientAuth {
+	case "", "required", "optional":
+	default:
+		return fmt.Errorf("invalid tls clientAuth '%s'", tls.ClientAuth)
+	}
+
+	if len(tls.Roles) > 0 && tls.ClientCA == nil {
+		return fmt.Errorf("tls roles require a clientCA")
+	}
+	for i, r := range tls.Roles {
+		if r.Subject == "" {
+			return fmt.Errorf("no subject specified for tls role #%d", i+1)
+		}
+		if _, err := path.Match(r.Subject, ""); err != nil {
+			return fmt.Errorf("invalid subject pattern '%s' for tls role #%d: %s", r.Subject, i+1, err)
+		}
+		if r.Role != "control" && r.Role != "monitor" {
+			return fmt.Errorf("invalid role '%s' for tls role #%d", r.Role, i+1)
+		}
+		if r.Role != "control" && (r.Unrestricted || len(r.Operations) > 0 || len(r.Buckets) > 0 || len(r.Prefixes) > 0) {
+			return fmt.Errorf("only control tls roles can be scoped (tls role #%d)", i+1)
+		}
+		if r.Unrestricted && (len(r.Operations) > 0 || len(r.Buckets) > 0 || len(r.Prefixes) > 0) {
+			return fmt.Errorf("unrestricted tls role #%d cannot also limit operations, buckets or prefixes", i+1)
+		}
+		if err := validateScope(r.Operations, r.Buckets, r.Prefixes); err != nil {
+			return fmt.Errorf("%s for tls role #%d", err, i+1)
+		}
+		if r.Limits != nil {
+			if err := r.Limits.validate(); err != nil {
+				return fmt.Errorf("invalid limits for tls role #%d: %s", i+1, err)
+			}
+		}
+	}
+
+	return nil
+}
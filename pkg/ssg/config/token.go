@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/jhunt/ssg/pkg/secret"
+)
+
+// A Token is a named control token, which can be
+// restricted to a subset of control operations,
+// buckets, and paths within those buckets.
+//
+// Tokens listed in the flat `controlTokens` list
+// are treated as unnamed tokens with no restrictions.
+//
+type Token struct {
+	// Name is a short, human-friendly identifier for
+	// this token (i.e. 'team-a-backups'), which will be
+	// used in the audit log and the stream listing in
+	// place of a fingerprint of the token itself.
+	//
+	Name string `yaml:"name"`
+
+	// Token is the bearer token that clients must
+	// present to authenticate as this named token.
+	// It can be hashed, as with `controlTokens`.
+	//
+	Token string `yaml:"token"`
+
+	// Operations lists the control operations that
+	// this token is allowed to perform; any of 'upload',
+	// 'download', 'expunge', 'hold' or 'release'.
+	//
+	// If empty, all operations are allowed, except for
+	// 'release'.  Since releasing a legal hold lets a blob
+	// be expunged, it is only ever granted by listing it.
+	//
+	Operations []string `yaml:"operations"`
+
+	// Buckets lists the keys of the buckets that this
+	// token is allowed to operate on, or to see in the
+	// bucket listing.  Each entry can be a glob pattern
+	// (i.e. 'team-a-*').
+	//
+	// If empty, all buckets are allowed.
+	//
+	Buckets []string `yaml:"buckets"`
+
+	// Prefixes lists the paths, relative to the root of
+	// each bucket, that this token is allowed to operate
+	// under (i.e. 'nightly/').  Uploads must specify a
+	// target path under one of these prefixes; they
+	// cannot ask the gateway to pick a random path.
+	//
+	// Prefixes are matched by whole path components, so
+	// 'nightly' covers 'nightly/x', but not 'nightly-x'.
+	//
+	// If empty, all paths are allowed.
+	//
+	Prefixes []string `yaml:"prefixes"`
+
+	// Limits caps the bandwidth and request rate of
+	// everything done with this token, across all
+	// buckets.
+	//
+	Limits *Limits `yaml:"limits"`
+}
+
+func (t *Token) validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("no name specified")
+	}
+	if t.Token == "" {
+		return fmt.Errorf("no token specified for '%s'", t.Name)
+	}
+	if err := secret.Validate(t.Token); err != nil {
+		return fmt.Errorf("invalid token for '%s': %s", t.Name, err)
+	}
+	if err := validateScope(t.Operations, t.Buckets, t.Prefixes); err != nil {
+		return fmt.Errorf("%s for '%s'", err, t.Name)
+	}
+	if t.Limits != nil {
+		if err := t.Limits.validate(); err != nil {
+			return fmt.Errorf("invalid limits for '%s': %s", t.Name, err)
+		}
+	}
+	return nil
+}
+
+func validateScope(operations, buckets, prefixes []string) error {
+	for _, op := range operations {
+		switch op {
+		case "upload", "download", "expunge", "hold", "release":
+		default:
+			return fmt.Errorf("invalid operation '%s'", op)
+		}
+	}
+	for _, pattern := range buckets {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid bucket pattern '%s': %s", pattern, err)
+		}
+	}
+	for _, prefix := range prefixes {
+		if prefix == "" {
+			return fmt.Errorf("empty path prefix")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Tus represents the configuration of the tus.io resumable
+// upload endpoints, under /tus/.
+//
+type Tus struct {
+	// AllowedOrigins lists the web origins (scheme, host
+	// and port, i.e. 'https://app.example.com') that
+	// browser-based tus clients may upload from, via
+	// Cross-Origin Resource Sharing (CORS).  Use '*' to
+	// allow any origin; since tus clients authenticate
+	// with an explicit stream token, not cookies, this is
+	// less dangerous than it sounds.
+	//
+	// If empty, no CORS headers are sent, and browsers
+	// will only allow same-origin uploads.
+	//
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+}
+
+func (tus *Tus) validate() error {
+	for _, origin := range tus.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("invalid tus allowed origin '%s' (must be either '*', or a scheme and host, like 'https://example.com')", origin)
+		}
+	}
+	return nil
+}
@@ -1,7 +1,10 @@
 package config_test
 
 import (
+	"time"
+
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 
 	"github.com/jhunt/ssg/pkg/ssg/config"
@@ -1147,5 +1150,1402 @@ buckets:
 `))
 			Ω(err).Should(HaveOccurred())
 		})
+
+		It("should default the lease ceiling to the maximum lease", func() {
+			c, err := config.Read([]byte(`---
+cluster: test
+maxLease: 300
+controlTokens:
+  - foo
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.MaxLease).Should(Equal(300))
+			Ω(c.LeaseCeiling).Should(Equal(300))
+		})
+
+		It("should fail if we specify a lease ceiling lower than the maximum lease", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+maxLease: 300
+leaseCeiling: 60
+controlTokens:
+  - foo
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should default the shutdown grace period and http timeouts", func() {
+			c, err := config.Read([]byte(`---
+cluster: test
+controlTokens:
+  - foo
+timeouts:
+  write: 3600
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.ShutdownGrace).Should(Equal(30))
+			Ω(c.Timeouts.ReadHeader).Should(Equal(30))
+			Ω(c.Timeouts.Read).Should(Equal(0))
+			Ω(c.Timeouts.Write).Should(Equal(3600))
+			Ω(c.Timeouts.Idle).Should(Equal(120))
+		})
+
+		It("should fail if we specify a negative http timeout", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens:
+  - foo
+timeouts:
+  read: -1
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should default the tls minimum version and client auth mode", func() {
+			c, err := config.Read([]byte(`---
+cluster: test
+controlTokens:
+  - foo
+tls:
+  certificate: /path/to/cert.pem
+  key:         /path/to/key.pem
+  clientCA:
+    file: /path/to/ca.pem
+  roles:
+    - subject: backup-*
+      role:    control
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.TLS).ShouldNot(BeNil())
+			Ω(c.TLS.MinVersion).Should(Equal("1.2"))
+			Ω(c.TLS.ClientAuth).Should(Equal("required"))
+			Ω(c.TLS.Roles).Should(HaveLen(1))
+			Ω(c.TLS.Roles[0].Subject).Should(Equal("backup-*"))
+			Ω(c.TLS.Roles[0].Role).Should(Equal("control"))
+		})
+
+		It("should fail if we forget the tls key", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens:
+  - foo
+tls:
+  certificate: /path/to/cert.pem
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if we specify an invalid tls minimum version", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens:
+  - foo
+tls:
+  certificate: /path/to/cert.pem
+  key:         /path/to/key.pem
+  minVersion:  "0.9"
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if we map tls subjects to roles without a client ca", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens:
+  - foo
+tls:
+  certificate: /path/to/cert.pem
+  key:         /path/to/key.pem
+  roles:
+    - subject: backup-*
+      role:    control
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if we map tls subjects to an unknown role", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens:
+  - foo
+tls:
+  certificate: /path/to/cert.pem
+  key:         /path/to/key.pem
+  clientCA:
+    file: /path/to/ca.pem
+  roles:
+    - subject: backup-*
+      role:    admin
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("audit logging", func() {
+		It("should default the audit file rotation settings", func() {
+			c, err := config.Read([]byte(`---
+cluster: test
+controlTokens:
+  - foo
+audit:
+  kind: file
+  file:
+    path: /var/log/ssg/audit.log
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.Audit).ShouldNot(BeNil())
+			Ω(c.Audit.File.Path).Should(Equal("/var/log/ssg/audit.log"))
+			Ω(c.Audit.File.MaxSize).Should(Equal(100))
+			Ω(c.Audit.File.Keep).Should(Equal(10))
+		})
+
+		It("should fail if the audit file path is relative", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens:
+  - foo
+audit:
+  kind: file
+  file:
+    path: audit.log
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if we send audit records to remote syslog without an address", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens:
+  - foo
+audit:
+  kind: syslog
+  syslog:
+    network: udp
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if we specify an unknown audit kind", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens:
+  - foo
+audit:
+  kind: kafka
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("named control tokens", func() {
+		It("should not require controlTokens if named tokens are given", func() {
+			c, err := config.Read([]byte(`---
+cluster: test
+tokens:
+  - name:       team-a
+    token:      s3cr3t
+    operations: [upload, download]
+    buckets:    [team-a-*]
+    prefixes:   [nightly/]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.ControlTokens).Should(BeEmpty())
+			Ω(c.Tokens).Should(HaveLen(1))
+			Ω(c.Tokens[0].Name).Should(Equal("team-a"))
+			Ω(c.Tokens[0].Operations).Should(Equal([]string{"upload", "download"}))
+			Ω(c.Tokens[0].Buckets).Should(Equal([]string{"team-a-*"}))
+			Ω(c.Tokens[0].Prefixes).Should(Equal([]string{"nightly/"}))
+		})
+
+		It("should fail if a named token has no name", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+tokens:
+  - token: s3cr3t
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if two named tokens share a name", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+tokens:
+  - name:  team-a
+    token: s3cr3t
+  - name:  team-a
+    token: other
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if a named token allows an unknown operation", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+tokens:
+  - name:       team-a
+    token:      s3cr3t
+    operations: [upload, rename]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if a named token has a malformed bucket pattern", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+tokens:
+  - name:    team-a
+    token:   s3cr3t
+    buckets: ["team-[a"]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("hashed tokens", func() {
+		It("should accept sha256 and bcrypt hashes of tokens", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens:
+  - sha256:2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b
+monitorTokens:
+  - bcrypt:$2a$04$dGvKE0q4vwZ8jC3xlxIZ1eo6z9q.4Wn.wGO7xheExYiffNcHTX8a6
+tokens:
+  - name:  team-a
+    token: sha256:2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should fail if there are too many bcrypt hashes", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens:
+  - bcrypt:$2a$04$dGvKE0q4vwZ8jC3xlxIZ1eo6z9q.4Wn.wGO7xheExYiffNcHTX8a6
+  - bcrypt:$2a$04$dGvKE0q4vwZ8jC3xlxIZ1eo6z9q.4Wn.wGO7xheExYiffNcHTX8a6
+  - bcrypt:$2a$04$dGvKE0q4vwZ8jC3xlxIZ1eo6z9q.4Wn.wGO7xheExYiffNcHTX8a6
+  - bcrypt:$2a$04$dGvKE0q4vwZ8jC3xlxIZ1eo6z9q.4Wn.wGO7xheExYiffNcHTX8a6
+  - bcrypt:$2a$04$dGvKE0q4vwZ8jC3xlxIZ1eo6z9q.4Wn.wGO7xheExYiffNcHTX8a6
+tokens:
+  - name:  team-a
+    token: bcrypt:$2a$04$dGvKE0q4vwZ8jC3xlxIZ1eo6z9q.4Wn.wGO7xheExYiffNcHTX8a6
+  - name:  team-b
+    token: bcrypt:$2a$04$dGvKE0q4vwZ8jC3xlxIZ1eo6z9q.4Wn.wGO7xheExYiffNcHTX8a6
+  - name:  team-c
+    token: bcrypt:$2a$04$dGvKE0q4vwZ8jC3xlxIZ1eo6z9q.4Wn.wGO7xheExYiffNcHTX8a6
+  - name:  team-d
+    token: bcrypt:$2a$04$dGvKE0q4vwZ8jC3xlxIZ1eo6z9q.4Wn.wGO7xheExYiffNcHTX8a6
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if a control token hash is malformed", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens:
+  - sha256:2bb80d537b1da3e3
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if a monitor token hash is malformed", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens:
+  - foo
+monitorTokens:
+  - bcrypt:nope
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("jwt authentication", func() {
+		It("should default the jwt leeway, refresh interval and identity claim", func() {
+			c, err := config.Read([]byte(`---
+cluster: test
+jwt:
+  jwks:     https://ci.example.com/.well-known/jwks
+  issuer:   https://ci.example.com
+  audience: ssg
+  roles:
+    - claims:
+        sub: repo:acme/*
+      role:    control
+      buckets: [acme-*]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.JWT).ShouldNot(BeNil())
+			Ω(c.JWT.Leeway).Should(Equal(30))
+			Ω(c.JWT.Refresh).Should(Equal(3600))
+			Ω(c.JWT.Identity).Should(Equal("sub"))
+			Ω(c.JWT.Roles).Should(HaveLen(1))
+			Ω(c.JWT.Roles[0].Claims).Should(HaveKeyWithValue("sub", "repo:acme/*"))
+		})
+
+		It("should fail if no jwt audience is given", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+jwt:
+  jwks:   /etc/ssg/jwks.json
+  issuer: https://ci.example.com
+  roles:
+    - claims: {sub: "*"}
+      role:   monitor
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if the jwks is a relative path", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+jwt:
+  jwks:     jwks.json
+  issuer:   https://ci.example.com
+  audience: ssg
+  roles:
+    - claims: {sub: "*"}
+      role:   monitor
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if a jwt role has no claims to match", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+jwt:
+  jwks:     /etc/ssg/jwks.json
+  issuer:   https://ci.example.com
+  audience: ssg
+  roles:
+    - role: control
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if a jwt role grants an unknown role", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+jwt:
+  jwks:     /etc/ssg/jwks.json
+  issuer:   https://ci.example.com
+  audience: ssg
+  roles:
+    - claims: {sub: "*"}
+      role:   admin
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("pre-signed urls", func() {
+		It("should default the maximum lifetime and upload size", func() {
+			c, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+signing:
+  keys:
+    - id:     k1
+      secret: 0123456789abcdef0123456789abcdef
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.Signing).ShouldNot(BeNil())
+			Ω(c.Signing.MaxLifetime).Should(Equal(86400))
+			Ω(c.Signing.MaxUploadSize).Should(Equal(int64(1073741824)))
+		})
+
+		It("should fail if no signing keys are given", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+signing:
+  maxLifetime: 3600
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if a signing key secret is too short", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+signing:
+  keys:
+    - id:     k1
+      secret: hunter2
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if two signing keys share an id", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+signing:
+  keys:
+    - id:     k1
+      secret: 0123456789abcdef0123456789abcdef
+    - id:     k1
+      secret: fedcba9876543210fedcba9876543210
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("authorization webhooks", func() {
+		It("should default the webhook timeout and cache ttl", func() {
+			c, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+authz:
+  webhook:
+    url: http://127.0.0.1:8181/v1/data/ssg/allow
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.Authz).ShouldNot(BeNil())
+			Ω(c.Authz.Webhook).ShouldNot(BeNil())
+			Ω(c.Authz.Webhook.Timeout).Should(Equal(5))
+			Ω(c.Authz.Webhook.CacheTTL).Should(Equal(10))
+			Ω(c.Authz.Webhook.FailOpen).Should(BeFalse())
+		})
+
+		It("should fail if no webhook url is given", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+authz:
+  webhook:
+    failOpen: true
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if the webhook url is not an http(s) url", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+authz:
+  webhook:
+    url: unix:///var/run/opa.sock
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("rate limits", func() {
+		It("should parse bucket and token limits", func() {
+			c, err := config.Read([]byte(`---
+cluster: test
+tokens:
+  - name:  nightly
+    token: a-token
+    limits:
+      requestsPerSecond: 0.5
+      burst:             3
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    limits:
+      bytesPerSecond: 10485760
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.Tokens[0].Limits).ShouldNot(BeNil())
+			Ω(c.Tokens[0].Limits.RequestsPerSecond).Should(Equal(0.5))
+			Ω(c.Tokens[0].Limits.Burst).Should(Equal(3))
+			Ω(c.Buckets[0].Limits).ShouldNot(BeNil())
+			Ω(c.Buckets[0].Limits.BytesPerSecond).Should(Equal(int64(10485760)))
+		})
+
+		It("should fail if a bucket limit is negative", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    limits:
+      bytesPerSecond: -1
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if a token limit is negative", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+tokens:
+  - name:  nightly
+    token: a-token
+    limits:
+      requestsPerSecond: -5
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("concurrency limits", func() {
+		It("should parse global and per-bucket concurrency limits", func() {
+			c, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+concurrency:
+  maxUploads:      50
+  maxBufferMemory: 512
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    concurrency:
+      maxDownloads: 4
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.Concurrency).ShouldNot(BeNil())
+			Ω(c.Concurrency.MaxUploads).Should(Equal(50))
+			Ω(c.Concurrency.MaxDownloads).Should(Equal(0))
+			Ω(c.Concurrency.MaxBufferMemory).Should(Equal(512))
+			Ω(c.Buckets[0].Concurrency).ShouldNot(BeNil())
+			Ω(c.Buckets[0].Concurrency.MaxDownloads).Should(Equal(4))
+		})
+
+		It("should fail if a global concurrency limit is negative", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+concurrency:
+  maxUploads: -1
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if a bucket buffer memory budget is negative", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    concurrency:
+      maxBufferMemory: -64
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("ranged downloads", func() {
+		It("should parse per-provider download workers and chunk sizes", func() {
+			c, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: in-s3
+    provider:
+      kind: s3
+      s3:
+        region: us-east-1
+        bucket: blobs
+        accessKeyID: AKI
+        secretAccessKey: sekrit
+        downloads:
+          workers:   8
+          chunkSize: 16
+  - key: in-webdav
+    provider:
+      kind: webdav
+      webdav:
+        url: https://store1.example.com:9000
+        downloads:
+          workers: 4
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.Buckets[0].Provider.S3.Downloads.Workers).Should(Equal(8))
+			Ω(c.Buckets[0].Provider.S3.Downloads.ChunkSize).Should(Equal(16))
+			Ω(c.Buckets[1].Provider.WebDAV.Downloads.Workers).Should(Equal(4))
+			Ω(c.Buckets[1].Provider.WebDAV.Downloads.ChunkSize).Should(Equal(0))
+		})
+
+		It("should fail if the number of download workers is negative", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: in-gcs
+    provider:
+      kind: gcs
+      gcs:
+        bucket: blobs
+        downloads:
+          workers: -2
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if the download chunk size is negative", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: in-s3
+    provider:
+      kind: s3
+      s3:
+        region: us-east-1
+        bucket: blobs
+        accessKeyID: AKI
+        secretAccessKey: sekrit
+        downloads:
+          chunkSize: -8
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if the s3 request timeout is negative", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: in-s3
+    provider:
+      kind: s3
+      s3:
+        region: us-east-1
+        bucket: blobs
+        accessKeyID: AKI
+        secretAccessKey: sekrit
+        timeout: -30
+`))
+			Ω(err).Should(MatchError(ContainSubstring("timeout")))
+		})
+	})
+
+	Context("write queues", func() {
+		It("should write segments through to the provider by default", func() {
+			c, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.WriteQueue).Should(Equal(0))
+		})
+
+		It("should parse the write queue depth", func() {
+			c, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+writeQueue: 8
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.WriteQueue).Should(Equal(8))
+		})
+
+		It("should fail if the write queue depth is negative", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+writeQueue: -1
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("lifecycle rules", func() {
+		It("should parse bucket lifecycle rules, and default the interval", func() {
+			c, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    lifecycle:
+      - prefix:       nightly/
+        expungeAfter: 30
+      - expungeAfter: 365
+        dryRun:       true
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.LifecycleInterval).Should(Equal(3600))
+			Ω(c.Buckets[0].Lifecycle).Should(HaveLen(2))
+			Ω(c.Buckets[0].Lifecycle[0].Prefix).Should(Equal("nightly/"))
+			Ω(c.Buckets[0].Lifecycle[0].ExpungeAfter).Should(Equal(30))
+			Ω(c.Buckets[0].Lifecycle[0].DryRun).Should(BeFalse())
+			Ω(c.Buckets[0].Lifecycle[1].Prefix).Should(Equal(""))
+			Ω(c.Buckets[0].Lifecycle[1].DryRun).Should(BeTrue())
+		})
+
+		It("should fail if a lifecycle rule has no age", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    lifecycle:
+      - prefix: nightly/
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("retention", func() {
+		It("should parse a bucket's minimum retention", func() {
+			c, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    retention:
+      minimum: 30d
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+  - key: other
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.Buckets[0].Retention).ShouldNot(BeNil())
+			Ω(c.Buckets[0].Retention.Period()).Should(Equal(30 * 24 * time.Hour))
+			Ω(c.Buckets[1].Retention).Should(BeNil())
+			Ω(c.Buckets[1].Retention.Period()).Should(Equal(time.Duration(0)))
+		})
+
+		It("should accept hours, minutes and seconds", func() {
+			for period, d := range map[string]time.Duration{
+				"36h": 36 * time.Hour,
+				"90m": 90 * time.Minute,
+				"45s": 45 * time.Second,
+			} {
+				c, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    retention:
+      minimum: ` + period + `
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(c.Buckets[0].Retention.Period()).Should(Equal(d))
+			}
+		})
+
+		It("should fail if the minimum retention is missing, malformed or not positive", func() {
+			for _, period := range []string{`""`, "30", "thirty-d", "30w", "0d", "-1d"} {
+				_, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    retention:
+      minimum: ` + period + `
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+				Ω(err).Should(HaveOccurred(), "minimum retention of %s", period)
+			}
+		})
+
+		It("should fail if a lifecycle rule would expunge blobs before their retention is up", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    retention:
+      minimum: 30d
+    lifecycle:
+      - expungeAfter: 7
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(ContainSubstring("minimum retention"))
+		})
+
+		It("should accept s3 object lock modes", func() {
+			for _, mode := range []string{"governance", "compliance"} {
+				c, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    retention:
+      minimum: 30d
+    provider:
+      kind: s3
+      s3:
+        region: us-east-1
+        bucket: backups
+        objectLock: ` + mode + `
+        accessKeyID: AKI
+        secretAccessKey: secret
+`))
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(c.Buckets[0].Provider.S3.ObjectLock).Should(Equal(mode))
+			}
+		})
+
+		It("should fail on an unknown s3 object lock mode", func() {
+			_, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: s3
+      s3:
+        region: us-east-1
+        bucket: backups
+        objectLock: permanent
+        accessKeyID: AKI
+        secretAccessKey: secret
+`))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should allow tokens to hold and release blobs", func() {
+			c, err := config.Read([]byte(`---
+cluster: test
+tokens:
+  - name:       legal
+    token:      s3cr3t
+    operations: [download, hold, release]
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.Tokens[0].Operations).Should(Equal([]string{"download", "hold", "release"}))
+		})
+
+		DescribeTable("tus allowed origins",
+			func(origin string, valid bool) {
+				_, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+tus:
+  allowedOrigins: ['` + origin + `']
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+				if valid {
+					Ω(err).ShouldNot(HaveOccurred())
+				} else {
+					Ω(err).Should(HaveOccurred())
+				}
+			},
+			Entry("allows any origin", "*", true),
+			Entry("allows an https origin", "https://app.example.com", true),
+			Entry("allows an http origin with a port", "http://localhost:3000", true),
+			Entry("rejects a bare hostname", "app.example.com", false),
+			Entry("rejects non-web schemes", "ftp://app.example.com", false),
+			Entry("rejects origins with paths", "https://app.example.com/upload", false),
+			Entry("rejects origins with trailing slashes", "https://app.example.com/", false),
+		)
+
+		DescribeTable("tls roles",
+			func(role string, valid bool) {
+				_, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+tls:
+  certificate: /path/to/cert.pem
+  key:         /path/to/key.pem
+  clientCA:
+    file: /path/to/ca.pem
+  roles:
+    - subject: client-*
+` + role + `
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+				if valid {
+					Ω(err).ShouldNot(HaveOccurred())
+				} else {
+					Ω(err).Should(HaveOccurred())
+				}
+			},
+			Entry("allows a bare control role", "      role: control", true),
+			Entry("allows a scoped control role", "      role: control\n      operations: [expunge]\n      buckets: [store]", true),
+			Entry("allows an unrestricted control role", "      role: control\n      unrestricted: true", true),
+			Entry("rejects unrestricted roles with a scope", "      role: control\n      unrestricted: true\n      buckets: [store]", false),
+			Entry("rejects scoped monitor roles", "      role: monitor\n      operations: [upload]", false),
+			Entry("rejects unknown operations", "      role: control\n      operations: [reload]", false),
+		)
+
+		It("should restrict control tls roles to uploads and downloads by default", func() {
+			c, err := config.Read([]byte(`---
+cluster: test
+controlTokens: [a-token]
+tls:
+  certificate: /path/to/cert.pem
+  key:         /path/to/key.pem
+  clientCA:
+    file: /path/to/ca.pem
+  roles:
+    - subject: client-*
+      role:    control
+    - subject: admin-*
+      role:    control
+      unrestricted: true
+    - subject: metrics-*
+      role:    monitor
+defaultBucket:
+  encryption: none
+
+buckets:
+  - key: store
+    provider:
+      kind: fs
+      fs:
+        root: /tmp
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.TLS.Roles[0].Operations).Should(Equal([]string{"upload", "download"}))
+			Ω(c.TLS.Roles[1].Operations).Should(BeEmpty())
+			Ω(c.TLS.Roles[2].Operations).Should(BeEmpty())
+		})
 	})
 })
@@ -46,6 +46,11 @@ type WebDAV struct {
 	// body before they are forcibly disconnected.
 	//
 	Timeout int `yaml:"timeout"`
+
+	// Downloads configures fetching large files from the
+	// WebDAV server as several concurrent ranged GETs.
+	//
+	Downloads Downloads `yaml:"downloads"`
 }
 
 func (webdav *WebDAV) validate() error {
@@ -72,5 +77,9 @@ func (webdav *WebDAV) validate() error {
 		return fmt.Errorf("webdav timeout '%d' is negative", webdav.Timeout)
 	}
 
+	if err := webdav.Downloads.validate(); err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,1950 @@
+package ssg_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+
+	"github.com/jhunt/ssg/pkg/secret"
+	"github.com/jhunt/ssg/pkg/ssg"
+	"github.com/jhunt/ssg/pkg/ssg/config"
+)
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "SSG Test Suite")
+}
+
+// gateway runs a Server (configured from the given YAML, with
+// ROOT replaced by a fresh fs bucket root) in-process.
+type gateway struct {
+	server *ssg.Server
+	router http.Handler
+	root   string
+}
+
+func newGateway(config string) *gateway {
+	root, err := ioutil.TempDir("", "ssg-test-")
+	Ω(err).ShouldNot(HaveOccurred())
+
+	s, err := ssg.NewServerFromString(strings.Replace(config, "ROOT", root, -1))
+	Ω(err).ShouldNot(HaveOccurred())
+	return &gateway{
+		server: s,
+		router: s.Router("test"),
+		root:   root,
+	}
+}
+
+func (g *gateway) cleanup() {
+	os.RemoveAll(g.root)
+}
+
+func (g *gateway) do(method, url, token string, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, body)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	g.router.ServeHTTP(w, req)
+	return w
+}
+
+// control POSTs a request to /control, returning the HTTP
+// status and decoded response.
+func (g *gateway) control(token string, in interface{}) (int, map[string]interface{}) {
+	b, err := json.Marshal(in)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	w := g.do("POST", "/control", token, bytes.NewReader(b))
+	out := make(map[string]interface{})
+	json.Unmarshal(w.Body.Bytes(), &out)
+	return w.Code, out
+}
+
+// upload starts an upload stream to target, returning its
+// id and token.
+func (g *gateway) upload(token, target string) (string, string) {
+	code, out := g.control(token, map[string]string{"kind": "upload", "target": target})
+	Ω(code).Should(Equal(200), fmt.Sprintf("starting upload to %s: %v", target, out))
+	return out["id"].(string), out["token"].(string)
+}
+
+// tus sends a tus protocol request, with the given headers,
+// authenticated by the stream token.
+func (g *gateway) tus(method, url, token string, headers map[string]string, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, body)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	g.router.ServeHTTP(w, req)
+	return w
+}
+
+// selfSigned writes a throwaway self-signed certificate and
+// key (as cert.pem and key.pem) to a new temporary directory,
+// returning the directory.
+func selfSigned() string {
+	dir, err := ioutil.TempDir("", "ssg-tls-")
+	Ω(err).ShouldNot(HaveOccurred())
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Ω(err).ShouldNot(HaveOccurred())
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ssg-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	Ω(err).ShouldNot(HaveOccurred())
+	b, err := x509.MarshalECPrivateKey(key)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	err = ioutil.WriteFile(dir+"/cert.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	Ω(err).ShouldNot(HaveOccurred())
+	err = ioutil.WriteFile(dir+"/key.pem", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600)
+	Ω(err).ShouldNot(HaveOccurred())
+	return dir
+}
+
+// issuer writes a JSON Web Key Set (as jwks.json) with one
+// new ES256 signing key to a new temporary directory, and
+// returns the directory, and a function that signs tokens
+// with the key.
+func issuer() (string, func(claims map[string]interface{}) string) {
+	dir, err := ioutil.TempDir("", "ssg-jwt-")
+	Ω(err).ShouldNot(HaveOccurred())
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Ω(err).ShouldNot(HaveOccurred())
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"EC","kid":"k1","crv":"P-256","x":"%s","y":"%s"}]}`,
+		b64(key.X.Bytes()), b64(key.Y.Bytes()))
+	Ω(ioutil.WriteFile(dir+"/jwks.json", []byte(jwks), 0600)).Should(Succeed())
+
+	return dir, func(claims map[string]interface{}) string {
+		h, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": "k1"})
+		c, _ := json.Marshal(claims)
+		signed := b64(h) + "." + b64(c)
+
+		sum := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, key, sum[:])
+		Ω(err).ShouldNot(HaveOccurred())
+		sig := make([]byte, 64)
+		copy(sig[32-len(r.Bytes()):32], r.Bytes())
+		copy(sig[64-len(s.Bytes()):], s.Bytes())
+		return signed + "." + b64(sig)
+	}
+}
+
+// asClient sends a request as if over a TLS connection with
+// a verified client certificate for the given Common Name.
+func (g *gateway) asClient(cn, method, url string, in interface{}) int {
+	b, err := json.Marshal(in)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	req := httptest.NewRequest(method, url, bytes.NewReader(b))
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{
+			&x509.Certificate{Subject: pkix.Name{CommonName: cn}},
+		}},
+	}
+	w := httptest.NewRecorder()
+	g.router.ServeHTTP(w, req)
+	return w.Code
+}
+
+// truncated yields some data, and then fails the way
+// net/http does when a client disconnects mid-body.
+type truncated struct {
+	data []byte
+}
+
+func (t *truncated) Read(b []byte) (int, error) {
+	if len(t.data) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(b, t.data)
+	t.data = t.data[n:]
+	return n, nil
+}
+
+const basicConfig = `---
+cluster: test
+controlTokens: [admin]
+defaultBucket:
+  compression: none
+  encryption:  none
+buckets:
+  - key: files
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+`
+
+var _ = Describe("Gateway", func() {
+	Context("receiving request bodies", func() {
+		var g *gateway
+
+		BeforeEach(func() {
+			g = newGateway(basicConfig)
+		})
+		AfterEach(func() {
+			g.cleanup()
+		})
+
+		It("should commit a blob once the body has been read in full", func() {
+			id, token := g.upload("admin", "ssg://test/files/whole")
+			w := g.do("PUT", "/blob/"+id, token, strings.NewReader("all of the data"))
+			Ω(w.Code).Should(Equal(200))
+
+			b, err := ioutil.ReadFile(g.root + "/whole")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(b)).Should(Equal("all of the data"))
+		})
+
+		It("should cancel the upload if the client disconnects mid-body", func() {
+			id, token := g.upload("admin", "ssg://test/files/partial")
+			w := g.do("PUT", "/blob/"+id, token, &truncated{data: []byte("some of the da")})
+			Ω(w.Code).Should(Equal(400))
+			Ω(g.root + "/partial").ShouldNot(BeAnExistingFile())
+
+			w = g.do("PUT", "/blob/"+id, token, strings.NewReader("ta"))
+			Ω(w.Code).Should(Equal(404))
+		})
+
+		It("should keep partial uploads, so they can resume, if the client disconnects mid-body", func() {
+			id, token := g.upload("admin", "ssg://test/files/partial")
+			w := g.do("PUT", "/blob/"+id+"?partial=1", token, &truncated{data: []byte("some of the da")})
+			Ω(w.Code).Should(Equal(400))
+
+			w = g.do("PUT", "/blob/"+id+"?offset=14", token, strings.NewReader("ta"))
+			Ω(w.Code).Should(Equal(200))
+
+			b, err := ioutil.ReadFile(g.root + "/partial")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(b)).Should(Equal("some of the data"))
+		})
+
+		It("should keep tus uploads, so they can resume, if the client disconnects mid-body", func() {
+			id, token := g.upload("admin", "ssg://test/files/tus")
+
+			w := g.tus("POST", "/tus/", token, map[string]string{
+				"Upload-Length":   "10",
+				"Upload-Metadata": "stream " + base64.StdEncoding.EncodeToString([]byte(id)),
+			}, nil)
+			Ω(w.Code).Should(Equal(201))
+
+			w = g.tus("PATCH", "/tus/"+id, token, map[string]string{
+				"Upload-Offset": "0",
+				"Content-Type":  "application/offset+octet-stream",
+			}, &truncated{data: []byte("12345")})
+			Ω(w.Code).Should(Equal(400))
+
+			w = g.tus("HEAD", "/tus/"+id, token, nil, nil)
+			Ω(w.Code).Should(Equal(200))
+			Ω(w.Header().Get("Upload-Offset")).Should(Equal("5"))
+
+			w = g.tus("PATCH", "/tus/"+id, token, map[string]string{
+				"Upload-Offset": "5",
+				"Content-Type":  "application/offset+octet-stream",
+			}, strings.NewReader("67890"))
+			Ω(w.Code).Should(Equal(204))
+
+			b, err := ioutil.ReadFile(g.root + "/tus")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(b)).Should(Equal("1234567890"))
+		})
+	})
+
+	Context("resumable uploads", func() {
+		var g *gateway
+
+		BeforeEach(func() {
+			g = newGateway(basicConfig)
+		})
+		AfterEach(func() {
+			g.cleanup()
+		})
+
+		segment := func(id, token string, in map[string]interface{}) (int, map[string]interface{}) {
+			b, err := json.Marshal(in)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			w := g.do("POST", "/blob/"+id, token, bytes.NewReader(b))
+			out := make(map[string]interface{})
+			json.Unmarshal(w.Body.Bytes(), &out)
+			return w.Code, out
+		}
+		data := func(s string) string {
+			return base64.StdEncoding.EncodeToString([]byte(s))
+		}
+
+		It("should accept segments at the committed offset, and skip retries of the last one", func() {
+			id, token := g.upload("admin", "ssg://test/files/offsets")
+
+			code, _ := segment(id, token, map[string]interface{}{"data": data("hello, "), "offset": 0})
+			Ω(code).Should(Equal(200))
+			code, out := segment(id, token, map[string]interface{}{"data": data("hello, "), "offset": 0})
+			Ω(code).Should(Equal(200))
+			Ω(out["segments"]).Should(BeEquivalentTo(1))
+
+			code, _ = segment(id, token, map[string]interface{}{"data": data("world"), "offset": 3})
+			Ω(code).Should(Equal(409))
+
+			code, _ = segment(id, token, map[string]interface{}{"data": data("world"), "offset": 7, "eof": true})
+			Ω(code).Should(Equal(200))
+
+			b, err := ioutil.ReadFile(g.root + "/offsets")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(b)).Should(Equal("hello, world"))
+		})
+
+		It("should accept segments by sequence number", func() {
+			id, token := g.upload("admin", "ssg://test/files/seq")
+
+			code, _ := segment(id, token, map[string]interface{}{"data": data("one "), "seq": 0})
+			Ω(code).Should(Equal(200))
+			code, _ = segment(id, token, map[string]interface{}{"data": data("two "), "seq": 1})
+			Ω(code).Should(Equal(200))
+			code, _ = segment(id, token, map[string]interface{}{"data": data("two "), "seq": 1})
+			Ω(code).Should(Equal(200))
+			code, _ = segment(id, token, map[string]interface{}{"data": data("four"), "seq": 3})
+			Ω(code).Should(Equal(409))
+			code, _ = segment(id, token, map[string]interface{}{"data": data("three"), "seq": 2, "eof": true})
+			Ω(code).Should(Equal(200))
+
+			b, err := ioutil.ReadFile(g.root + "/seq")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(b)).Should(Equal("one two three"))
+		})
+
+		It("should report the committed offset of an upload", func() {
+			id, token := g.upload("admin", "ssg://test/files/status")
+
+			w := g.do("PUT", "/blob/"+id+"?partial=1&offset=0", token, strings.NewReader("12345"))
+			Ω(w.Code).Should(Equal(200))
+
+			w = g.do("GET", "/blob/"+id+"/status", token, nil)
+			Ω(w.Code).Should(Equal(200))
+			var status struct {
+				Offset   int64 `json:"offset"`
+				Segments int   `json:"segments"`
+			}
+			Ω(json.Unmarshal(w.Body.Bytes(), &status)).Should(Succeed())
+			Ω(status.Offset).Should(Equal(int64(5)))
+			Ω(status.Segments).Should(Equal(1))
+
+			Ω(g.do("PUT", "/blob/"+id+"?offset=0", token, strings.NewReader("67890")).Code).Should(Equal(409))
+			Ω(g.do("PUT", "/blob/"+id+"?offset=5", token, strings.NewReader("67890")).Code).Should(Equal(200))
+
+			b, err := ioutil.ReadFile(g.root + "/status")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(b)).Should(Equal("1234567890"))
+		})
+
+		It("should not report the status of uploads to other tokens", func() {
+			id, _ := g.upload("admin", "ssg://test/files/secret")
+			Ω(g.do("GET", "/blob/"+id+"/status", "not-the-token", nil).Code).Should(Equal(404))
+		})
+	})
+
+	Context("tus uploads", func() {
+		var g *gateway
+
+		BeforeEach(func() {
+			g = newGateway(basicConfig + `
+tus:
+  allowedOrigins: [https://app.example.com]
+`)
+		})
+		AfterEach(func() {
+			g.cleanup()
+		})
+
+		create := func(length string) (string, string) {
+			id, token := g.upload("admin", "ssg://test/files/tus")
+			w := g.tus("POST", "/tus/", token, map[string]string{
+				"Upload-Length":   length,
+				"Upload-Metadata": "stream " + base64.StdEncoding.EncodeToString([]byte(id)),
+			}, nil)
+			Ω(w.Code).Should(Equal(201))
+			return id, token
+		}
+		patch := func(id, token, offset, data string) *httptest.ResponseRecorder {
+			return g.tus("PATCH", "/tus/"+id, token, map[string]string{
+				"Upload-Offset": offset,
+				"Content-Type":  "application/offset+octet-stream",
+			}, strings.NewReader(data))
+		}
+
+		It("should finish the upload once Upload-Length bytes arrive", func() {
+			id, token := create("10")
+
+			w := patch(id, token, "0", "12345")
+			Ω(w.Code).Should(Equal(204))
+			Ω(w.Header().Get("Upload-Offset")).Should(Equal("5"))
+
+			w = g.tus("HEAD", "/tus/"+id, token, nil, nil)
+			Ω(w.Code).Should(Equal(200))
+			Ω(w.Header().Get("Upload-Offset")).Should(Equal("5"))
+			Ω(w.Header().Get("Upload-Length")).Should(Equal("10"))
+
+			w = patch(id, token, "5", "67890")
+			Ω(w.Code).Should(Equal(204))
+			Ω(w.Header().Get("Upload-Offset")).Should(Equal("10"))
+
+			b, err := ioutil.ReadFile(g.root + "/tus")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(b)).Should(Equal("1234567890"))
+		})
+
+		It("should refuse PATCH bodies that run past the Upload-Length", func() {
+			id, token := create("10")
+
+			w := patch(id, token, "0", "12345")
+			Ω(w.Code).Should(Equal(204))
+
+			w = patch(id, token, "5", "67890-and-then-some")
+			Ω(w.Code).Should(Equal(413))
+			Ω(g.root + "/tus").ShouldNot(BeAnExistingFile())
+		})
+
+		It("should refuse PATCHes at anything but the committed offset", func() {
+			id, token := create("10")
+			Ω(patch(id, token, "0", "12345").Code).Should(Equal(204))
+
+			Ω(patch(id, token, "0", "12345").Code).Should(Equal(409))
+			Ω(patch(id, token, "7", "890").Code).Should(Equal(409))
+			Ω(patch(id, token, "five", "67890").Code).Should(Equal(400))
+
+			w := g.tus("HEAD", "/tus/"+id, token, nil, nil)
+			Ω(w.Header().Get("Upload-Offset")).Should(Equal("5"))
+		})
+
+		It("should refuse PATCHes before the upload has been created", func() {
+			id, token := g.upload("admin", "ssg://test/files/tus")
+			Ω(patch(id, token, "0", "12345").Code).Should(Equal(409))
+		})
+
+		It("should refuse requests without the right protocol version or content type", func() {
+			id, token := create("10")
+
+			req := httptest.NewRequest("HEAD", "/tus/"+id, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			g.router.ServeHTTP(w, req)
+			Ω(w.Code).Should(Equal(412))
+			Ω(w.Header().Get("Tus-Version")).Should(Equal("1.0.0"))
+
+			w = g.tus("PATCH", "/tus/"+id, token, map[string]string{
+				"Upload-Offset": "0",
+				"Content-Type":  "text/plain",
+			}, strings.NewReader("12345"))
+			Ω(w.Code).Should(Equal(415))
+		})
+
+		It("should cancel the upload when the client terminates it", func() {
+			id, token := create("10")
+			Ω(patch(id, token, "0", "12345").Code).Should(Equal(204))
+
+			w := g.tus("DELETE", "/tus/"+id, token, nil, nil)
+			Ω(w.Code).Should(Equal(204))
+			Ω(g.root + "/tus").ShouldNot(BeAnExistingFile())
+			Ω(g.tus("HEAD", "/tus/"+id, token, nil, nil).Code).Should(Equal(404))
+		})
+
+		It("should refuse to change the Upload-Length once set", func() {
+			id, token := create("10")
+			w := g.tus("POST", "/tus/", token, map[string]string{
+				"Upload-Length":   "20",
+				"Upload-Metadata": "stream " + base64.StdEncoding.EncodeToString([]byte(id)),
+			}, nil)
+			Ω(w.Code).Should(Equal(409))
+		})
+
+		It("should answer CORS preflights from allowed origins", func() {
+			w := g.tus("OPTIONS", "/tus/", "", map[string]string{
+				"Origin":                        "https://app.example.com",
+				"Access-Control-Request-Method": "PATCH",
+			}, nil)
+			Ω(w.Code).Should(Equal(204))
+			Ω(w.Header().Get("Access-Control-Allow-Origin")).Should(Equal("https://app.example.com"))
+			Ω(w.Header().Get("Access-Control-Allow-Methods")).Should(ContainSubstring("PATCH"))
+			Ω(w.Header().Get("Access-Control-Allow-Headers")).Should(ContainSubstring("Upload-Offset"))
+			Ω(w.Header().Get("Vary")).Should(Equal("Origin"))
+		})
+
+		It("should expose the tus headers to allowed origins", func() {
+			id, token := create("10")
+			w := g.tus("HEAD", "/tus/"+id, token, map[string]string{"Origin": "https://app.example.com"}, nil)
+			Ω(w.Code).Should(Equal(200))
+			Ω(w.Header().Get("Access-Control-Allow-Origin")).Should(Equal("https://app.example.com"))
+			Ω(w.Header().Get("Access-Control-Expose-Headers")).Should(ContainSubstring("Upload-Offset"))
+			Ω(w.Header().Get("Access-Control-Expose-Headers")).Should(ContainSubstring("Location"))
+		})
+
+		It("should not send CORS headers to other origins", func() {
+			w := g.tus("OPTIONS", "/tus/", "", map[string]string{
+				"Origin":                        "https://evil.example.com",
+				"Access-Control-Request-Method": "PATCH",
+			}, nil)
+			Ω(w.Header().Get("Access-Control-Allow-Origin")).Should(Equal(""))
+			Ω(w.Header().Get("Access-Control-Allow-Methods")).Should(Equal(""))
+		})
+	})
+
+	Context("retention and legal holds", func() {
+		var g *gateway
+
+		BeforeEach(func() {
+			g = newGateway(`---
+cluster: test
+controlTokens: [admin]
+tokens:
+  - name:       releaser
+    token:      releaser
+    operations: [release]
+defaultBucket:
+  compression: none
+  encryption:  none
+buckets:
+  - key: files
+    retention:
+      minimum: 1d
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+`)
+		})
+		AfterEach(func() {
+			g.cleanup()
+		})
+
+		It("should allow uploads to new paths", func() {
+			id, token := g.upload("admin", "ssg://test/files/new")
+			w := g.do("PUT", "/blob/"+id, token, strings.NewReader("new data"))
+			Ω(w.Code).Should(Equal(200))
+		})
+
+		It("should refuse to overwrite or expunge retained blobs", func() {
+			id, token := g.upload("admin", "ssg://test/files/kept")
+			Ω(g.do("PUT", "/blob/"+id, token, strings.NewReader("kept")).Code).Should(Equal(200))
+
+			code, _ := g.control("admin", map[string]string{"kind": "upload", "target": "ssg://test/files/kept"})
+			Ω(code).Should(Equal(403))
+			code, _ = g.control("admin", map[string]string{"kind": "expunge", "target": "ssg://test/files/kept"})
+			Ω(code).Should(Equal(403))
+			Ω(g.root + "/kept").Should(BeARegularFile())
+		})
+
+		It("should only let tokens that are explicitly granted it release holds", func() {
+			code, _ := g.control("admin", map[string]string{"kind": "hold", "target": "ssg://test/files/x"})
+			Ω(code).ShouldNot(Equal(403))
+			code, _ = g.control("admin", map[string]string{"kind": "release", "target": "ssg://test/files/x"})
+			Ω(code).Should(Equal(403))
+			code, _ = g.control("releaser", map[string]string{"kind": "release", "target": "ssg://test/files/x"})
+			Ω(code).ShouldNot(Equal(403))
+		})
+	})
+
+	Context("bucket lifecycle", func() {
+		var g *gateway
+
+		BeforeEach(func() {
+			g = newGateway(`---
+cluster: test
+controlTokens: [admin]
+defaultBucket:
+  compression: none
+  encryption:  none
+buckets:
+  - key: files
+    lifecycle:
+      - expungeAfter: 1
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+`)
+			g.server.LifecycleInterval = 10 * time.Millisecond
+			g.server.ShutdownGrace = 0
+		})
+		AfterEach(func() {
+			g.server.Shutdown(context.Background())
+			g.cleanup()
+		})
+
+		It("should leave blobs that are still being uploaded alone, whatever cluster they were started against", func() {
+			g.upload("admin", "ssg://elsewhere/files/uploading")
+			Ω(ioutil.WriteFile(g.root+"/expired", []byte("old"), 0666)).Should(Succeed())
+
+			old := time.Now().Add(-48 * time.Hour)
+			Ω(os.Chtimes(g.root+"/uploading", old, old)).Should(Succeed())
+			Ω(os.Chtimes(g.root+"/expired", old, old)).Should(Succeed())
+
+			go g.server.Lifecycle()
+			Eventually(g.root + "/expired").ShouldNot(BeAnExistingFile())
+			Consistently(g.root+"/uploading", "100ms").Should(BeARegularFile())
+		})
+	})
+
+	Context("pre-signed urls", func() {
+		var g *gateway
+
+		BeforeEach(func() {
+			g = newGateway(basicConfig + `
+signing:
+  maxUploadSize: 16
+  keys:
+    - id:     k1
+      secret: 0123456789abcdef0123456789abcdef
+`)
+		})
+		AfterEach(func() {
+			g.cleanup()
+		})
+
+		sign := func(in map[string]interface{}) string {
+			in["kind"] = "sign"
+			code, out := g.control("admin", in)
+			Ω(code).Should(Equal(200), fmt.Sprintf("signing %v: %v", in, out))
+			return out["url"].(string)
+		}
+
+		It("should upload and download blobs without a token", func() {
+			link := sign(map[string]interface{}{"operation": "upload", "target": "ssg://test/files/signed", "size": 10})
+			w := g.do("PUT", link, "", strings.NewReader("0123456789"))
+			Ω(w.Code).Should(Equal(200))
+
+			link = sign(map[string]interface{}{"operation": "download", "target": "ssg://test/files/signed"})
+			w = g.do("GET", link, "", nil)
+			Ω(w.Code).Should(Equal(200))
+			Ω(w.Body.String()).Should(Equal("0123456789"))
+		})
+
+		It("should refuse signed uploads and downloads while shutting down", func() {
+			up := sign(map[string]interface{}{"operation": "upload", "target": "ssg://test/files/late", "size": 10})
+			Ω(ioutil.WriteFile(g.root+"/existing", []byte("data"), 0666)).Should(Succeed())
+			down := sign(map[string]interface{}{"operation": "download", "target": "ssg://test/files/existing"})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			g.server.Shutdown(ctx)
+
+			Ω(g.do("PUT", up, "", strings.NewReader("0123456789")).Code).Should(Equal(503))
+			Ω(g.do("GET", down, "", nil).Code).Should(Equal(503))
+			Ω(g.root + "/late").ShouldNot(BeAnExistingFile())
+		})
+
+		It("should refuse uploads larger than the signed size", func() {
+			link := sign(map[string]interface{}{"operation": "upload", "target": "ssg://test/files/big", "size": 5})
+			Ω(g.do("PUT", link, "", strings.NewReader("0123456789")).Code).Should(Equal(413))
+			Ω(g.root + "/big").ShouldNot(BeAnExistingFile())
+		})
+
+		It("should neither sign nor accept urls unless signing is enabled", func() {
+			link := sign(map[string]interface{}{"operation": "download", "target": "ssg://test/files/x"})
+
+			plain := newGateway(basicConfig)
+			defer plain.cleanup()
+			code, _ := plain.control("admin", map[string]interface{}{"kind": "sign", "operation": "download", "target": "ssg://test/files/x"})
+			Ω(code).Should(Equal(400))
+			Ω(plain.do("GET", link, "", nil).Code).Should(Equal(404))
+		})
+
+		It("should refuse urls for the other operation", func() {
+			link := sign(map[string]interface{}{"operation": "upload", "target": "ssg://test/files/x", "size": 5})
+			Ω(g.do("GET", link, "", nil).Code).Should(Equal(400))
+		})
+
+		It("should refuse urls that have been tampered with", func() {
+			for param, value := range map[string]string{
+				"canon": "ssg://test/files/y",
+				"size":  "16",
+				"exp":   fmt.Sprintf("%d", time.Now().Add(time.Hour*24*365).Unix()),
+				"by":    "someone-else",
+				"kid":   "k2",
+				"sig":   "AAAA",
+			} {
+				link := sign(map[string]interface{}{"operation": "upload", "target": "ssg://test/files/x", "size": 5})
+				u, err := neturl.Parse(link)
+				Ω(err).ShouldNot(HaveOccurred())
+				q := u.Query()
+				q.Set(param, value)
+				u.RawQuery = q.Encode()
+
+				Ω(g.do("PUT", u.String(), "", strings.NewReader("01234")).Code).Should(Equal(403), "tampered %s", param)
+				Ω(g.root + "/x").ShouldNot(BeAnExistingFile())
+			}
+		})
+
+		It("should refuse to sign unknown operations, or uploads without a path or a sane size", func() {
+			for _, in := range []map[string]interface{}{
+				{"operation": "expunge", "target": "ssg://test/files/x"},
+				{"operation": "upload", "target": "ssg://test/files", "size": 5},
+				{"operation": "upload", "target": "ssg://test/files/x"},
+				{"operation": "upload", "target": "ssg://test/files/x", "size": 17},
+			} {
+				in["kind"] = "sign"
+				code, _ := g.control("admin", in)
+				Ω(code).Should(Equal(400), "signing %v", in)
+			}
+		})
+	})
+
+	Context("policy webhooks", func() {
+		var (
+			g       *gateway
+			webhook *httptest.Server
+
+			lock   sync.Mutex
+			asked  []map[string]interface{}
+			answer string
+			status int
+		)
+
+		BeforeEach(func() {
+			asked, answer, status = nil, `{"result": true}`, 200
+			webhook = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var in struct {
+					Input map[string]interface{} `json:"input"`
+				}
+				json.NewDecoder(r.Body).Decode(&in)
+
+				lock.Lock()
+				defer lock.Unlock()
+				asked = append(asked, in.Input)
+				w.WriteHeader(status)
+				fmt.Fprintf(w, "%s", answer)
+			}))
+		})
+		AfterEach(func() {
+			g.cleanup()
+			webhook.Close()
+		})
+
+		configure := func(webhook string) {
+			g = newGateway(`---
+cluster: test
+controlTokens: [admin]
+tokens:
+  - name:  ci
+    token: ci-secret
+authz:
+  webhook:
+` + webhook + `
+defaultBucket:
+  compression: none
+  encryption:  none
+buckets:
+  - key: files
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+`)
+		}
+		respond := func(code int, body string) {
+			lock.Lock()
+			defer lock.Unlock()
+			status, answer = code, body
+		}
+		calls := func() int {
+			lock.Lock()
+			defer lock.Unlock()
+			return len(asked)
+		}
+
+		It("should describe each control request to the policy service", func() {
+			configure("    url: " + webhook.URL)
+			g.upload("ci-secret", "ssg://test/files/nightly/db.tar")
+
+			Ω(calls()).Should(Equal(1))
+			Ω(asked[0]).Should(HaveKeyWithValue("identity", "ci"))
+			Ω(asked[0]).Should(HaveKeyWithValue("kind", "upload"))
+			Ω(asked[0]).Should(HaveKeyWithValue("operation", "upload"))
+			Ω(asked[0]).Should(HaveKeyWithValue("cluster", "test"))
+			Ω(asked[0]).Should(HaveKeyWithValue("bucket", "files"))
+			Ω(asked[0]).Should(HaveKeyWithValue("path", "nightly/db.tar"))
+		})
+
+		It("should honor the webhook's decisions", func() {
+			configure("    url: " + webhook.URL + "\n    cacheTTL: -1")
+			for body, allowed := range map[string]bool{
+				`{"result": true}`:                                  true,
+				`{"result": {"allow": true}}`:                       true,
+				`{"allow": true}`:                                   true,
+				`{"result": {"allow": false, "reason": "because"}}`: false,
+				`{"allow": false, "reason": "because"}`:             false,
+			} {
+				respond(200, body)
+				code, out := g.control("admin", map[string]string{"kind": "upload", "target": fmt.Sprintf("ssg://test/files/x%d", calls())})
+				if allowed {
+					Ω(code).Should(Equal(200), "decision %s", body)
+				} else {
+					Ω(code).Should(Equal(403), "decision %s", body)
+					Ω(fmt.Sprint(out)).Should(ContainSubstring("because"))
+				}
+			}
+		})
+
+		It("should fail closed, unless told to fail open", func() {
+			respond(500, "oops")
+
+			configure("    url: " + webhook.URL)
+			code, _ := g.control("admin", map[string]string{"kind": "upload", "target": "ssg://test/files/x"})
+			Ω(code).Should(Equal(403))
+			g.cleanup()
+
+			configure("    url: " + webhook.URL + "\n    failOpen: true")
+			code, _ = g.control("admin", map[string]string{"kind": "upload", "target": "ssg://test/files/x"})
+			Ω(code).Should(Equal(200))
+		})
+
+		It("should cache decisions, unless told not to", func() {
+			configure("    url: " + webhook.URL)
+			g.control("admin", map[string]string{"kind": "download", "target": "ssg://test/files/x"})
+			g.control("admin", map[string]string{"kind": "download", "target": "ssg://test/files/x"})
+			Ω(calls()).Should(Equal(1))
+			g.control("admin", map[string]string{"kind": "download", "target": "ssg://test/files/y"})
+			Ω(calls()).Should(Equal(2))
+			g.cleanup()
+
+			configure("    url: " + webhook.URL + "\n    cacheTTL: -1")
+			g.control("admin", map[string]string{"kind": "download", "target": "ssg://test/files/x"})
+			g.control("admin", map[string]string{"kind": "download", "target": "ssg://test/files/x"})
+			Ω(calls()).Should(Equal(4))
+		})
+	})
+
+	Context("token scopes", func() {
+		var g *gateway
+
+		BeforeEach(func() {
+			g = newGateway(`---
+cluster: test
+controlTokens: [admin]
+tokens:
+  - name:       team-a
+    token:      team-a-secret
+    operations: [expunge]
+    buckets:    [files]
+    prefixes:   [team-a/, nightly]
+  - name:       team-a-uploads
+    token:      team-a-uploader
+    operations: [upload]
+    buckets:    [files]
+    prefixes:   [team-a/]
+defaultBucket:
+  compression: none
+  encryption:  none
+buckets:
+  - key: files
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+  - key: other
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+`)
+		})
+		AfterEach(func() {
+			g.cleanup()
+		})
+
+		permitted := func(token, kind, target string) bool {
+			code, _ := g.control(token, map[string]string{"kind": kind, "target": target})
+			return code != 403
+		}
+
+		It("should permit paths under a token's prefixes", func() {
+			Ω(permitted("team-a-secret", "expunge", "ssg://test/files/team-a/x")).Should(BeTrue())
+			Ω(permitted("team-a-secret", "expunge", "ssg://test/files/nightly/x")).Should(BeTrue())
+			Ω(permitted("team-a-secret", "expunge", "ssg://test/files/nightly")).Should(BeTrue())
+			Ω(permitted("team-a-secret", "expunge", "ssg://test/files/team-a//./x")).Should(BeTrue())
+		})
+
+		It("should refuse paths, buckets and operations outside a token's scope", func() {
+			Ω(permitted("team-a-secret", "expunge", "ssg://test/files/nightly-evil/x")).Should(BeFalse())
+			Ω(permitted("team-a-secret", "expunge", "ssg://test/files/team-b/x")).Should(BeFalse())
+			Ω(permitted("team-a-secret", "expunge", "ssg://test/files/team-a/../team-b/x")).Should(BeFalse())
+			Ω(permitted("team-a-secret", "expunge", "ssg://test/files/team-a/../../../etc/passwd")).Should(BeFalse())
+			Ω(permitted("team-a-secret", "expunge", "ssg://test/other/team-a/x")).Should(BeFalse())
+			Ω(permitted("team-a-secret", "download", "ssg://test/files/team-a/x")).Should(BeFalse())
+		})
+
+		It("should not let unrestricted tokens climb out of the bucket", func() {
+			Ω(permitted("admin", "expunge", "ssg://test/files/x")).Should(BeTrue())
+			Ω(permitted("admin", "expunge", "ssg://test/files/../x")).Should(BeFalse())
+		})
+
+		It("should refuse tokens that match nothing", func() {
+			code, _ := g.control("team-b-secret", map[string]string{"kind": "expunge", "target": "ssg://test/files/x"})
+			Ω(code).Should(Equal(403))
+		})
+
+		It("should only list the buckets a token can see", func() {
+			keys := func(token string) []string {
+				w := g.do("GET", "/buckets", token, nil)
+				Ω(w.Code).Should(Equal(200))
+				var l []struct {
+					Key string `json:"key"`
+				}
+				Ω(json.Unmarshal(w.Body.Bytes(), &l)).Should(Succeed())
+				keys := make([]string, len(l))
+				for i := range l {
+					keys[i] = l[i].Key
+				}
+				return keys
+			}
+			Ω(keys("admin")).Should(ConsistOf("files", "other"))
+			Ω(keys("team-a-secret")).Should(ConsistOf("files"))
+		})
+
+		It("should only list and cancel streams in buckets a token can see", func() {
+			id, _ := g.upload("admin", "ssg://test/other/x")
+
+			w := g.do("GET", "/streams", "team-a-secret", nil)
+			Ω(w.Code).Should(Equal(200))
+			Ω(w.Body.String()).ShouldNot(ContainSubstring(id))
+			Ω(g.do("DELETE", "/streams/"+id, "team-a-secret", nil).Code).Should(Equal(404))
+
+			w = g.do("GET", "/streams", "admin", nil)
+			Ω(w.Body.String()).Should(ContainSubstring(id))
+			Ω(g.do("DELETE", "/streams/"+id, "admin", nil).Code).Should(Equal(200))
+		})
+
+		It("should only cancel streams that a token could have started", func() {
+			Ω(os.MkdirAll(g.root+"/team-a", 0777)).Should(Succeed())
+			Ω(ioutil.WriteFile(g.root+"/team-a/existing", []byte("data"), 0666)).Should(Succeed())
+
+			mine, _ := g.upload("admin", "ssg://test/files/team-a/x")
+			theirs, _ := g.upload("admin", "ssg://test/files/team-b/x")
+			code, out := g.control("admin", map[string]string{"kind": "download", "target": "ssg://test/files/team-a/existing"})
+			Ω(code).Should(Equal(200))
+			download := out["id"].(string)
+
+			Ω(g.do("DELETE", "/streams/"+mine, "team-a-secret", nil).Code).Should(Equal(403))
+			Ω(g.do("DELETE", "/streams/"+theirs, "team-a-uploader", nil).Code).Should(Equal(403))
+			Ω(g.do("DELETE", "/streams/"+download, "team-a-uploader", nil).Code).Should(Equal(403))
+			Ω(g.do("DELETE", "/streams/"+mine, "team-a-uploader", nil).Code).Should(Equal(200))
+
+			w := g.do("GET", "/streams", "admin", nil)
+			Ω(w.Body.String()).ShouldNot(ContainSubstring(mine))
+			Ω(w.Body.String()).Should(ContainSubstring(theirs))
+			Ω(w.Body.String()).Should(ContainSubstring(download))
+		})
+	})
+
+	Context("hashed tokens", func() {
+		var g *gateway
+
+		BeforeEach(func() {
+			hashed, err := secret.Bcrypt("team-a-secret", 4)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			g = newGateway(`---
+cluster: test
+controlTokens: ['` + secret.SHA256("admin") + `']
+monitorTokens: ['` + secret.SHA256("metrics") + `']
+tokens:
+  - name:  team-a
+    token: '` + hashed + `'
+defaultBucket:
+  compression: none
+  encryption:  none
+buckets:
+  - key: files
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+`)
+		})
+		AfterEach(func() {
+			g.cleanup()
+		})
+
+		It("should authenticate the tokens that the hashes are of", func() {
+			Ω(g.do("GET", "/buckets", "admin", nil).Code).Should(Equal(200))
+			Ω(g.do("GET", "/buckets", "team-a-secret", nil).Code).Should(Equal(200))
+			Ω(g.do("GET", "/metrics", "metrics", nil).Code).Should(Equal(200))
+		})
+
+		It("should not authenticate the hashes themselves", func() {
+			Ω(g.do("GET", "/buckets", secret.SHA256("admin"), nil).Code).Should(Equal(403))
+			Ω(g.do("GET", "/buckets", "team-b-secret", nil).Code).Should(Equal(403))
+			Ω(g.do("GET", "/buckets", "metrics", nil).Code).Should(Equal(403))
+		})
+	})
+
+	Context("jwt authentication", func() {
+		var (
+			g    *gateway
+			keys string
+			sign func(map[string]interface{}) string
+		)
+
+		BeforeEach(func() {
+			keys, sign = issuer()
+			g = newGateway(strings.Replace(`---
+cluster: test
+controlTokens: [admin]
+jwt:
+  jwks:     KEYS/jwks.json
+  issuer:   https://ci.example.com
+  audience: ssg
+  roles:
+    - claims:
+        sub: repo:acme/*
+      role:    control
+      buckets: [files]
+    - claims:
+        groups: ops
+      role: monitor
+    - claims:
+        team: limited
+      role:   control
+      limits: {requestsPerSecond: 10}
+defaultBucket:
+  compression: none
+  encryption:  none
+buckets:
+  - key: files
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+  - key: other
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+`, "KEYS", keys, -1))
+		})
+		AfterEach(func() {
+			g.cleanup()
+			os.RemoveAll(keys)
+		})
+
+		token := func(claims map[string]interface{}) string {
+			base := map[string]interface{}{
+				"iss": "https://ci.example.com",
+				"aud": "ssg",
+				"exp": time.Now().Add(time.Minute).Unix(),
+			}
+			for k, v := range claims {
+				base[k] = v
+			}
+			return sign(base)
+		}
+		permitted := func(token, target string) int {
+			code, _ := g.control(token, map[string]string{"kind": "upload", "target": target})
+			return code
+		}
+
+		It("should grant the scope of the first role the claims match", func() {
+			t := token(map[string]interface{}{"sub": "repo:acme/backups"})
+			Ω(permitted(t, "ssg://test/files/x")).Should(Equal(200))
+			Ω(permitted(t, "ssg://test/other/x")).Should(Equal(403))
+			Ω(g.do("GET", "/metrics", t, nil).Code).Should(Equal(403))
+		})
+
+		It("should grant the monitor role to tokens with matching list claims", func() {
+			t := token(map[string]interface{}{"sub": "someone", "groups": []string{"dev", "ops"}})
+			Ω(g.do("GET", "/metrics", t, nil).Code).Should(Equal(200))
+			Ω(permitted(t, "ssg://test/files/x")).Should(Equal(403))
+		})
+
+		It("should reject tokens that match no role, or are not meant for us", func() {
+			for _, claims := range []map[string]interface{}{
+				{"sub": "repo:evil/backups"},
+				{"sub": "repo:acme/x", "iss": "https://evil.example.com"},
+				{"sub": "repo:acme/x", "aud": "other"},
+				{"sub": "repo:acme/x", "exp": time.Now().Add(-time.Hour).Unix()},
+				{"sub": "repo:acme/x", "nbf": time.Now().Add(time.Hour).Unix()},
+			} {
+				Ω(permitted(token(claims), "ssg://test/files/x")).Should(Equal(403), "claims %v", claims)
+			}
+		})
+
+		It("should refuse rate-limited tokens that do not say who the caller is", func() {
+			Ω(permitted(token(map[string]interface{}{"team": "limited"}), "ssg://test/files/x")).Should(Equal(403))
+			Ω(permitted(token(map[string]interface{}{"team": "limited", "sub": "someone"}), "ssg://test/files/x")).Should(Equal(200))
+		})
+
+		It("should reject tokens whose signatures do not verify", func() {
+			t := token(map[string]interface{}{"sub": "repo:acme/backups"})
+			forged := strings.Replace(t, t[strings.Index(t, ".")+1:strings.LastIndex(t, ".")],
+				base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"https://ci.example.com","aud":"ssg","sub":"repo:acme/x"}`)), 1)
+			Ω(permitted(forged, "ssg://test/files/x")).Should(Equal(403))
+		})
+	})
+
+	Context("client certificate roles", func() {
+		var (
+			g     *gateway
+			certs string
+		)
+
+		BeforeEach(func() {
+			certs = selfSigned()
+			g = newGateway(strings.Replace(`---
+cluster: test
+controlTokens: [admin]
+tls:
+  certificate: CERTS/cert.pem
+  key:         CERTS/key.pem
+  clientCA:
+    file: CERTS/cert.pem
+  roles:
+    - subject: default-*
+      role:    control
+    - subject: team-a-*
+      role:    control
+      operations: [expunge]
+      prefixes:   [team-a/]
+    - subject: admin-*
+      role:    control
+      unrestricted: true
+    - subject: metrics-*
+      role:    monitor
+defaultBucket:
+  compression: none
+  encryption:  none
+buckets:
+  - key: files
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+`, "CERTS", certs, -1))
+		})
+		AfterEach(func() {
+			g.cleanup()
+			os.RemoveAll(certs)
+		})
+
+		control := func(cn, kind, target string) int {
+			return g.asClient(cn, "POST", "/control", map[string]string{"kind": kind, "target": target})
+		}
+
+		It("should let default roles upload and download, but nothing else", func() {
+			Ω(control("default-1", "upload", "ssg://test/files/x")).ShouldNot(Equal(403))
+			Ω(control("default-1", "download", "ssg://test/files/x")).ShouldNot(Equal(403))
+			Ω(control("default-1", "expunge", "ssg://test/files/x")).Should(Equal(403))
+			Ω(control("default-1", "release", "ssg://test/files/x")).Should(Equal(403))
+		})
+
+		It("should hold scoped roles to their operations and prefixes", func() {
+			Ω(control("team-a-1", "expunge", "ssg://test/files/team-a/x")).ShouldNot(Equal(403))
+			Ω(control("team-a-1", "expunge", "ssg://test/files/team-b/x")).Should(Equal(403))
+			Ω(control("team-a-1", "upload", "ssg://test/files/team-a/x")).Should(Equal(403))
+		})
+
+		It("should let unrestricted roles do anything but release holds", func() {
+			Ω(control("admin-1", "expunge", "ssg://test/files/x")).ShouldNot(Equal(403))
+			Ω(control("admin-1", "release", "ssg://test/files/x")).Should(Equal(403))
+		})
+
+		It("should only let unrestricted roles reload the configuration", func() {
+			Ω(g.asClient("default-1", "POST", "/reload", nil)).Should(Equal(403))
+			Ω(g.asClient("team-a-1", "POST", "/reload", nil)).Should(Equal(403))
+
+			// our gateway has no configuration file to reload
+			Ω(g.asClient("admin-1", "POST", "/reload", nil)).ShouldNot(Equal(403))
+		})
+
+		It("should not grant control to monitor roles", func() {
+			Ω(control("metrics-1", "upload", "ssg://test/files/x")).Should(Equal(401))
+			Ω(g.asClient("metrics-1", "GET", "/metrics", nil)).Should(Equal(200))
+		})
+	})
+
+	Context("rate limits", func() {
+		var (
+			g     *gateway
+			certs string
+		)
+
+		BeforeEach(func() {
+			certs = selfSigned()
+			g = newGateway(strings.Replace(`---
+cluster: test
+tokens:
+  - name:  team
+    token: team-secret
+    limits:
+      requestsPerSecond: 0.01
+      burst: 1
+tls:
+  certificate: CERTS/cert.pem
+  key:         CERTS/key.pem
+  clientCA:
+    file: CERTS/cert.pem
+  roles:
+    - subject: team
+      role:    control
+      limits:
+        requestsPerSecond: 0.01
+        burst: 1
+defaultBucket:
+  compression: none
+  encryption:  none
+buckets:
+  - key: files
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+`, "CERTS", certs, -1))
+		})
+		AfterEach(func() {
+			g.cleanup()
+			os.RemoveAll(certs)
+		})
+
+		start := func(target string) map[string]string {
+			return map[string]string{"kind": "upload", "target": "ssg://test/files/" + target}
+		}
+
+		It("should not share limits between identities that authenticate differently", func() {
+			code, _ := g.control("team-secret", start("a"))
+			Ω(code).Should(Equal(200))
+			code, _ = g.control("team-secret", start("b"))
+			Ω(code).Should(Equal(429))
+
+			Ω(g.asClient("team", "POST", "/control", start("c"))).Should(Equal(200))
+			Ω(g.asClient("team", "POST", "/control", start("d"))).Should(Equal(429))
+		})
+	})
+
+	Context("bucket limits", func() {
+		var g *gateway
+
+		BeforeEach(func() {
+			g = newGateway(`---
+cluster: test
+controlTokens: [admin]
+defaultBucket:
+  compression: none
+  encryption:  none
+buckets:
+  - key: slow
+    limits:
+      requestsPerSecond: 0.01
+      burst: 1
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+  - key: narrow
+    limits:
+      bytesPerSecond: 1000
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+`)
+		})
+		AfterEach(func() {
+			g.cleanup()
+		})
+
+		It("should tell callers over a bucket's request rate when to retry", func() {
+			code, _ := g.control("admin", map[string]string{"kind": "upload", "target": "ssg://test/slow/a"})
+			Ω(code).Should(Equal(200))
+
+			w := g.do("POST", "/control", "admin", strings.NewReader(`{"kind":"upload","target":"ssg://test/slow/b"}`))
+			Ω(w.Code).Should(Equal(429))
+			Ω(w.Header().Get("Retry-After")).Should(Equal("100"))
+
+			code, _ = g.control("admin", map[string]string{"kind": "upload", "target": "ssg://test/narrow/c"})
+			Ω(code).Should(Equal(200))
+		})
+
+		It("should hold transfers to a bucket's bandwidth", func() {
+			id, token := g.upload("admin", "ssg://test/narrow/big")
+
+			started := time.Now()
+			w := g.do("PUT", "/blob/"+id, token, strings.NewReader(strings.Repeat("x", 1500)))
+			Ω(w.Code).Should(Equal(200))
+			Ω(time.Since(started)).Should(BeNumerically(">=", 400*time.Millisecond))
+		})
+	})
+
+	Context("concurrency limits", func() {
+		var g *gateway
+
+		BeforeEach(func() {
+			g = newGateway(`---
+cluster: test
+controlTokens: [admin]
+concurrency:
+  maxUploads: 2
+defaultBucket:
+  compression: none
+  encryption:  none
+buckets:
+  - key: files
+    concurrency:
+      maxDownloads: 1
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+  - key: other
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+`)
+			Ω(ioutil.WriteFile(g.root+"/existing", []byte("data"), 0666)).Should(Succeed())
+		})
+		AfterEach(func() {
+			g.cleanup()
+		})
+
+		start := func(kind, target string) int {
+			code, _ := g.control("admin", map[string]string{"kind": kind, "target": target})
+			return code
+		}
+
+		It("should turn away uploads over the gateway limit until one finishes", func() {
+			id, token := g.upload("admin", "ssg://test/files/a")
+			g.upload("admin", "ssg://test/other/b")
+			Ω(start("upload", "ssg://test/files/c")).Should(Equal(503))
+
+			Ω(g.do("PUT", "/blob/"+id, token, strings.NewReader("a")).Code).Should(Equal(200))
+			Ω(start("upload", "ssg://test/files/c")).Should(Equal(200))
+		})
+
+		It("should turn away downloads over the bucket limit until one is canceled", func() {
+			Ω(start("download", "ssg://test/files/existing")).Should(Equal(200))
+			Ω(start("download", "ssg://test/files/existing")).Should(Equal(503))
+			Ω(start("download", "ssg://test/other/existing")).Should(Equal(200))
+
+			w := g.do("GET", "/streams?kind=download", "admin", nil)
+			var l []struct {
+				ID     string `json:"id"`
+				Bucket string `json:"bucket"`
+			}
+			Ω(json.Unmarshal(w.Body.Bytes(), &l)).Should(Succeed())
+			for _, x := range l {
+				if x.Bucket == "files" {
+					Ω(g.do("DELETE", "/streams/"+x.ID, "admin", nil).Code).Should(Equal(200))
+				}
+			}
+			Ω(start("download", "ssg://test/files/existing")).Should(Equal(200))
+		})
+	})
+
+	Context("buffer memory limits", func() {
+		var g *gateway
+		var dav *httptest.Server
+
+		BeforeEach(func() {
+			dav = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"v1"`)
+				http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(make([]byte, 5*1024*1024)))
+			}))
+			g = newGateway(strings.Replace(`---
+cluster: test
+controlTokens: [admin]
+writeQueue: 4
+concurrency:
+  maxBufferMemory: 10
+defaultBucket:
+  compression: none
+  encryption:  none
+buckets:
+  - key: files
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+  - key: dav
+    concurrency:
+      maxBufferMemory: 8
+    provider:
+      kind: webdav
+      webdav:
+        url: DAV
+        downloads:
+          workers: 2
+          chunkSize: 2
+`, "DAV", dav.URL, -1))
+		})
+		AfterEach(func() {
+			g.cleanup()
+			dav.Close()
+		})
+
+		start := func(kind, target string) int {
+			code, _ := g.control("admin", map[string]string{"kind": kind, "target": target})
+			return code
+		}
+
+		It("should count the write queue of every upload against the budget", func() {
+			id, token := g.upload("admin", "ssg://test/files/a")
+			g.upload("admin", "ssg://test/files/b")
+			Ω(start("upload", "ssg://test/files/c")).Should(Equal(503))
+
+			Ω(g.do("PUT", "/blob/"+id, token, strings.NewReader("a")).Code).Should(Equal(200))
+			Ω(start("upload", "ssg://test/files/c")).Should(Equal(200))
+		})
+
+		It("should count the chunks of every ranged download against the budget", func() {
+			Ω(start("download", "ssg://test/dav/blob")).Should(Equal(200))
+			Ω(start("download", "ssg://test/dav/blob")).Should(Equal(503))
+
+			g.upload("admin", "ssg://test/files/a")
+			Ω(start("upload", "ssg://test/files/b")).Should(Equal(503))
+		})
+	})
+
+	Context("reloading the configuration", func() {
+		var (
+			g     *gateway
+			keys  string
+			sign  func(map[string]interface{}) string
+			other string
+		)
+
+		const reloadable = `---
+cluster: test
+controlTokens: [admin]
+tokens:
+  - name:  team
+    token: team-secret
+    limits:
+      requestsPerSecond: 0.01
+      burst: 1
+jwt:
+  jwks:     KEYS/jwks.json
+  issuer:   https://ci.example.com
+  audience: ssg
+  roles:
+    - claims:
+        sub: repo:acme/*
+      role: control
+defaultBucket:
+  compression: none
+  encryption:  none
+buckets:
+  - key: files
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+`
+
+		BeforeEach(func() {
+			keys, sign = issuer()
+			var err error
+			other, err = ioutil.TempDir("", "ssg-test-")
+			Ω(err).ShouldNot(HaveOccurred())
+			g = newGateway(strings.Replace(reloadable, "KEYS", keys, -1))
+		})
+		AfterEach(func() {
+			g.cleanup()
+			os.RemoveAll(keys)
+			os.RemoveAll(other)
+		})
+
+		reload := func(yaml, root string) error {
+			yaml = strings.Replace(yaml, "KEYS", keys, -1)
+			c, err := config.Read([]byte(strings.Replace(yaml, "ROOT", root, -1)))
+			Ω(err).ShouldNot(HaveOccurred())
+			return g.server.Reconfigure(c)
+		}
+		start := func(token, target string) int {
+			code, _ := g.control(token, map[string]string{"kind": "upload", "target": target})
+			return code
+		}
+
+		It("should swap in the new buckets and tokens", func() {
+			Ω(reload(strings.NewReplacer(
+				"[admin]", "[root]",
+				"key: files", "key: archive",
+			).Replace(reloadable), other)).Should(Succeed())
+
+			Ω(start("admin", "ssg://test/files/a")).Should(Equal(403))
+			Ω(start("root", "ssg://test/files/a")).ShouldNot(Equal(200))
+
+			id, token := g.upload("root", "ssg://test/archive/a")
+			Ω(g.do("PUT", "/blob/"+id, token, strings.NewReader("archived")).Code).Should(Equal(200))
+			Ω(ioutil.ReadFile(other + "/a")).Should(Equal([]byte("archived")))
+		})
+
+		It("should let streams in flight finish against the bucket they started with", func() {
+			id, token := g.upload("admin", "ssg://test/files/kept")
+			Ω(reload(reloadable, other)).Should(Succeed())
+
+			Ω(g.do("PUT", "/blob/"+id, token, strings.NewReader("kept")).Code).Should(Equal(200))
+			Ω(ioutil.ReadFile(g.root + "/kept")).Should(Equal([]byte("kept")))
+			Ω(other + "/kept").ShouldNot(BeAnExistingFile())
+		})
+
+		It("should reject an invalid configuration, and keep the current one", func() {
+			Ω(reload(strings.NewReplacer(
+				"[admin]", "[root]",
+				"jwks.json", "missing.json",
+			).Replace(reloadable), other)).ShouldNot(Succeed())
+
+			Ω(start("root", "ssg://test/files/a")).Should(Equal(403))
+			id, token := g.upload("admin", "ssg://test/files/a")
+			Ω(g.do("PUT", "/blob/"+id, token, strings.NewReader("current")).Code).Should(Equal(200))
+			Ω(ioutil.ReadFile(g.root + "/a")).Should(Equal([]byte("current")))
+		})
+
+		It("should keep rate limits and jwt keys when their configuration is unchanged", func() {
+			Ω(start("team-secret", "ssg://test/files/a")).Should(Equal(200))
+			Ω(start("team-secret", "ssg://test/files/b")).Should(Equal(429))
+
+			// the keys can no longer be (re-)loaded from disk
+			Ω(os.Remove(keys + "/jwks.json")).Should(Succeed())
+			Ω(reload(reloadable, g.root)).Should(Succeed())
+
+			Ω(start("team-secret", "ssg://test/files/c")).Should(Equal(429))
+			Ω(start(sign(map[string]interface{}{
+				"iss": "https://ci.example.com",
+				"aud": "ssg",
+				"exp": time.Now().Add(time.Minute).Unix(),
+				"sub": "repo:acme/backups",
+			}), "ssg://test/files/d")).Should(Equal(200))
+		})
+	})
+
+	Context("content encoding", func() {
+		var g *gateway
+
+		BeforeEach(func() {
+			g = newGateway(`---
+cluster: test
+controlTokens: [admin]
+defaultBucket:
+  compression: zlib
+  encryption:  none
+buckets:
+  - key: files
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+`)
+		})
+		AfterEach(func() {
+			g.cleanup()
+		})
+
+		const data = "the quick brown fox jumps over the lazy dog, again and again and again"
+
+		deflated := func(s string) []byte {
+			var b bytes.Buffer
+			z := zlib.NewWriter(&b)
+			_, err := z.Write([]byte(s))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(z.Close()).Should(Succeed())
+			return b.Bytes()
+		}
+		inflated := func(b []byte) string {
+			z, err := zlib.NewReader(bytes.NewReader(b))
+			Ω(err).ShouldNot(HaveOccurred())
+			out, err := ioutil.ReadAll(z)
+			Ω(err).ShouldNot(HaveOccurred())
+			return string(out)
+		}
+
+		// put uploads body to target, as the given encoding
+		put := func(target, encoding string, body []byte) {
+			id, token := g.upload("admin", target)
+			req := httptest.NewRequest("PUT", "/blob/"+id, bytes.NewReader(body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set("Content-Encoding", encoding)
+			w := httptest.NewRecorder()
+			g.router.ServeHTTP(w, req)
+			Ω(w.Code).Should(Equal(200), w.Body.String())
+		}
+
+		// get downloads target, accepting the given encodings
+		get := func(target, accept string) *httptest.ResponseRecorder {
+			code, out := g.control("admin", map[string]string{"kind": "download", "target": target})
+			Ω(code).Should(Equal(200))
+			req := httptest.NewRequest("GET", "/blob/"+out["id"].(string), nil)
+			req.Header.Set("Authorization", "Bearer "+out["token"].(string))
+			if accept != "" {
+				req.Header.Set("Accept-Encoding", accept)
+			}
+			w := httptest.NewRecorder()
+			g.router.ServeHTTP(w, req)
+			Ω(w.Code).Should(Equal(200))
+			Ω(w.Header().Get("Vary")).Should(Equal("Accept-Encoding"))
+			return w
+		}
+
+		It("should store deflated uploads as-is, and send them back deflated", func() {
+			put("ssg://test/files/deflated", "deflate", deflated(data))
+			Ω(ioutil.ReadFile(g.root + "/deflated")).Should(Equal(deflated(data)))
+
+			w := get("ssg://test/files/deflated", "gzip, deflate")
+			Ω(w.Header().Get("Content-Encoding")).Should(Equal("deflate"))
+			Ω(inflated(w.Body.Bytes())).Should(Equal(data))
+
+			w = get("ssg://test/files/deflated", "")
+			Ω(w.Header().Get("Content-Encoding")).Should(Equal(""))
+			Ω(w.Body.String()).Should(Equal(data))
+		})
+
+		It("should compress identity uploads, and only send them deflated on request", func() {
+			put("ssg://test/files/plain", "identity", []byte(data))
+			stored, err := ioutil.ReadFile(g.root + "/plain")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(inflated(stored)).Should(Equal(data))
+
+			w := get("ssg://test/files/plain", "deflate;q=0, identity")
+			Ω(w.Header().Get("Content-Encoding")).Should(Equal(""))
+			Ω(w.Body.String()).Should(Equal(data))
+
+			w = get("ssg://test/files/plain", "deflate")
+			Ω(w.Header().Get("Content-Encoding")).Should(Equal("deflate"))
+			Ω(inflated(w.Body.Bytes())).Should(Equal(data))
+		})
+	})
+
+	Context("metrics", func() {
+		var g *gateway
+
+		BeforeEach(func() {
+			g = newGateway(`---
+cluster: test
+controlTokens: [admin]
+monitorTokens: [metrics]
+defaultBucket:
+  compression: none
+  encryption:  none
+buckets:
+  - key: files
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+`)
+		})
+		AfterEach(func() {
+			g.cleanup()
+		})
+
+		scrape := func() string {
+			w := g.do("GET", "/metrics/prometheus", "metrics", nil)
+			Ω(w.Code).Should(Equal(200))
+			Ω(w.Header().Get("Content-Type")).Should(HavePrefix("text/plain; version=0.0.4"))
+			return w.Body.String()
+		}
+
+		It("should expose counters, histograms and gauges that survive a reset", func() {
+			id, token := g.upload("admin", "ssg://test/files/a")
+			Ω(g.do("PUT", "/blob/"+id, token, strings.NewReader("0123456789")).Code).Should(Equal(200))
+			code, _ := g.control("admin", map[string]string{"kind": "download", "target": "ssg://test/files/a"})
+			Ω(code).Should(Equal(200))
+
+			Ω(g.do("DELETE", "/metrics", "metrics", nil).Code).Should(Equal(200))
+			w := g.do("GET", "/metrics", "metrics", nil)
+			Ω(w.Code).Should(Equal(200))
+			Ω(w.Body.String()).Should(ContainSubstring(`"operations":{"upload":0,"download":0,"expunge":0}`))
+
+			out := scrape()
+			Ω(out).Should(ContainSubstring(`ssg_build_info{version="test",goversion="` + runtime.Version() + `"} 1` + "\n"))
+			Ω(out).Should(ContainSubstring(`ssg_operations_total{bucket="files",operation="upload"} 1` + "\n"))
+			Ω(out).Should(ContainSubstring(`ssg_operations_total{bucket="files",operation="download"} 1` + "\n"))
+			Ω(out).Should(ContainSubstring(`ssg_transfer_bytes_total{bucket="files",side="front",direction="in"} 10` + "\n"))
+			Ω(out).Should(ContainSubstring("# TYPE ssg_segment_bytes histogram\n"))
+			Ω(out).Should(ContainSubstring(`ssg_segment_bytes_bucket{bucket="files",le="1024"} 1` + "\n"))
+			Ω(out).Should(ContainSubstring(`ssg_segment_bytes_bucket{bucket="files",le="+Inf"} 1` + "\n"))
+			Ω(out).Should(ContainSubstring(`ssg_segment_bytes_sum{bucket="files"} 10` + "\n"))
+			Ω(out).Should(ContainSubstring("# TYPE ssg_active_streams gauge\n"))
+			Ω(out).Should(ContainSubstring(`ssg_active_streams{bucket="files",kind="upload"} 0` + "\n"))
+			Ω(out).Should(ContainSubstring(`ssg_active_streams{bucket="files",kind="download"} 1` + "\n"))
+		})
+
+		It("should record how long requests, and the backend calls they make, take", func() {
+			id, token := g.upload("admin", "ssg://test/files/a")
+			Ω(g.do("PUT", "/blob/"+id, token, strings.NewReader("0123456789")).Code).Should(Equal(200))
+			code, out := g.control("admin", map[string]string{"kind": "download", "target": "ssg://test/files/a"})
+			Ω(code).Should(Equal(200))
+			w := g.do("GET", "/blob/"+out["id"].(string), out["token"].(string), nil)
+			Ω(w.Body.String()).Should(Equal("0123456789"))
+
+			id, token = g.upload("admin", "ssg://test/files/b")
+			Ω(g.do("PUT", "/blob/"+id, token, &truncated{data: []byte("01234")}).Code).Should(Equal(400))
+
+			scraped := scrape()
+			for _, series := range []string{
+				`ssg_request_duration_seconds_bucket{bucket="files",kind="upload",le="+Inf"} 2`,
+				`ssg_request_duration_seconds_count{bucket="files",kind="upload"} 2`,
+				`ssg_request_errors_total{bucket="files",kind="upload"} 1`,
+				`ssg_request_duration_seconds_count{bucket="files",kind="download"} 1`,
+				`ssg_request_errors_total{bucket="files",kind="download"} 0`,
+				`ssg_backend_call_duration_seconds_count{bucket="files",operation="upload_open"} 2`,
+				`ssg_backend_call_duration_seconds_count{bucket="files",operation="upload_write"} 2`,
+				`ssg_backend_call_duration_seconds_count{bucket="files",operation="upload_close"} 1`,
+				`ssg_backend_call_duration_seconds_count{bucket="files",operation="download_open"} 1`,
+			} {
+				Ω(scraped).Should(ContainSubstring(series + "\n"))
+			}
+
+			w = g.do("GET", "/metrics", "metrics", nil)
+			Ω(w.Code).Should(Equal(200))
+			var m map[string]struct {
+				Requests map[string]struct {
+					Calls  int `json:"calls"`
+					Errors int `json:"errors"`
+				} `json:"requests"`
+				Backend map[string]struct {
+					Calls int `json:"calls"`
+				} `json:"backend"`
+			}
+			Ω(json.Unmarshal(w.Body.Bytes(), &m)).Should(Succeed())
+			Ω(m["files"].Requests["upload"].Calls).Should(Equal(2))
+			Ω(m["files"].Requests["upload"].Errors).Should(Equal(1))
+			Ω(m["files"].Requests["download"].Calls).Should(Equal(1))
+			Ω(m["files"].Backend["upload_write"].Calls).Should(Equal(2))
+		})
+
+		It("should only let monitors scrape metrics", func() {
+			Ω(g.do("GET", "/metrics/prometheus", "", nil).Code).Should(Equal(401))
+			Ω(g.do("GET", "/metrics/prometheus", "admin", nil).Code).Should(Equal(403))
+		})
+	})
+
+	Context("listing and canceling streams", func() {
+		var g *gateway
+
+		BeforeEach(func() {
+			g = newGateway(basicConfig)
+		})
+		AfterEach(func() {
+			g.cleanup()
+		})
+
+		streams := func() []map[string]interface{} {
+			w := g.do("GET", "/streams", "admin", nil)
+			Ω(w.Code).Should(Equal(200))
+			var l []map[string]interface{}
+			Ω(json.Unmarshal(w.Body.Bytes(), &l)).Should(Succeed())
+			return l
+		}
+
+		It("should report the progress of uploads while they are in flight", func() {
+			id, token := g.upload("admin", "ssg://test/files/busy")
+
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				defer close(done)
+				for i := 0; i < 50; i++ {
+					w := g.do("PUT", "/blob/"+id+"?partial=1", token, strings.NewReader("0123456789"))
+					Ω(w.Code).Should(Equal(200))
+				}
+			}()
+			for i := 0; i < 50; i++ {
+				streams()
+				runtime.Gosched()
+			}
+			<-done
+
+			l := streams()
+			Ω(l).Should(HaveLen(1))
+			Ω(l[0]["id"]).Should(Equal(id))
+			Ω(l[0]["received"]).Should(BeNumerically("==", 500))
+			Ω(l[0]["segments"]).Should(BeNumerically("==", 50))
+		})
+
+		cancel := func(id string) map[string]interface{} {
+			w := g.do("DELETE", "/streams/"+id, "admin", nil)
+			Ω(w.Code).Should(Equal(200))
+			out := make(map[string]interface{})
+			Ω(json.Unmarshal(w.Body.Bytes(), &out)).Should(Succeed())
+			return out
+		}
+
+		It("should report the bytes received when canceling uploads", func() {
+			id, token := g.upload("admin", "ssg://test/files/canceled")
+			w := g.do("PUT", "/blob/"+id+"?partial=1", token, strings.NewReader("0123456789"))
+			Ω(w.Code).Should(Equal(200))
+
+			out := cancel(id)
+			Ω(out["kind"]).Should(Equal("upload"))
+			Ω(out["received"]).Should(BeNumerically("==", 10))
+			Ω(out["sent"]).Should(BeNumerically("==", 0))
+
+			Ω(g.do("PUT", "/blob/"+id, token, strings.NewReader("more")).Code).Should(Equal(404))
+			Ω(g.root + "/canceled").ShouldNot(BeAnExistingFile())
+		})
+
+		It("should report the bytes sent when canceling downloads", func() {
+			Ω(ioutil.WriteFile(g.root+"/existing", []byte("0123456789"), 0644)).Should(Succeed())
+			code, out := g.control("admin", map[string]string{"kind": "download", "target": "ssg://test/files/existing"})
+			Ω(code).Should(Equal(200))
+
+			out = cancel(out["id"].(string))
+			Ω(out["kind"]).Should(Equal("download"))
+			Ω(out["received"]).Should(BeNumerically("==", 0))
+			Ω(out).Should(HaveKey("sent"))
+		})
+	})
+
+	Context("write queues", func() {
+		var g *gateway
+
+		BeforeEach(func() {
+			g = newGateway(strings.Replace(basicConfig, "controlTokens:", "writeQueue: 2\ncontrolTokens:", 1))
+		})
+		AfterEach(func() {
+			g.cleanup()
+		})
+
+		It("should write queued segments through in order", func() {
+			id, token := g.upload("admin", "ssg://test/files/queued")
+
+			var want bytes.Buffer
+			offset := 0
+			for i := 0; i < 16; i++ {
+				chunk := fmt.Sprintf("segment %02d;", i)
+				b, err := json.Marshal(map[string]interface{}{
+					"data":   base64.StdEncoding.EncodeToString([]byte(chunk)),
+					"offset": offset,
+					"eof":    i == 15,
+				})
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(g.do("POST", "/blob/"+id, token, bytes.NewReader(b)).Code).Should(Equal(200))
+				want.WriteString(chunk)
+				offset += len(chunk)
+			}
+
+			b, err := ioutil.ReadFile(g.root + "/queued")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(b)).Should(Equal(want.String()))
+		})
+
+		It("should drain partial PUTs before finishing the upload", func() {
+			id, token := g.upload("admin", "ssg://test/files/parts")
+			Ω(g.do("PUT", "/blob/"+id+"?partial=1", token, strings.NewReader("the first part, ")).Code).Should(Equal(200))
+			Ω(g.do("PUT", "/blob/"+id+"?partial=1", token, strings.NewReader("the second part, ")).Code).Should(Equal(200))
+			Ω(g.do("PUT", "/blob/"+id, token, strings.NewReader("and the last")).Code).Should(Equal(200))
+
+			b, err := ioutil.ReadFile(g.root + "/parts")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(b)).Should(Equal("the first part, the second part, and the last"))
+		})
+	})
+
+	Context("expiring streams", func() {
+		var g *gateway
+
+		BeforeEach(func() {
+			g = newGateway(`---
+cluster: test
+controlTokens: [admin]
+sweepInterval: 1
+writeQueue: 4
+defaultBucket:
+  compression: none
+  encryption:  none
+buckets:
+  - key: files
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+  - key: slow
+    limits:
+      bytesPerSecond: 131072
+      burst:          32768
+    provider:
+      kind: fs
+      fs:
+        root: ROOT
+`)
+			go g.server.Sweep()
+		})
+		AfterEach(func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			g.server.Shutdown(ctx)
+			g.cleanup()
+		})
+
+		It("should cancel expired uploads, and stop their write queues", func() {
+			before := runtime.NumGoroutine()
+
+			code, out := g.control("admin", map[string]interface{}{"kind": "upload", "target": "ssg://test/files/slow", "lease": 1})
+			Ω(code).Should(Equal(200))
+			id, token := out["id"].(string), out["token"].(string)
+
+			w := g.do("PUT", "/blob/"+id+"?partial=1", token, strings.NewReader("the first part"))
+			Ω(w.Code).Should(Equal(200))
+			Ω(runtime.NumGoroutine()).Should(BeNumerically(">", before))
+
+			Eventually(g.root+"/slow", 5*time.Second, 250*time.Millisecond).ShouldNot(BeAnExistingFile())
+			Ω(g.do("PUT", "/blob/"+id+"?partial=1", token, strings.NewReader("more")).Code).Should(Equal(404))
+			Eventually(runtime.NumGoroutine, 2*time.Second).Should(BeNumerically("<=", before))
+		})
+
+		It("should keep renewing the lease on downloads while they are sent", func() {
+			data := bytes.Repeat([]byte("0123456789abcdef"), 24*1024)
+			Ω(ioutil.WriteFile(g.root+"/large", data, 0666)).Should(Succeed())
+
+			code, out := g.control("admin", map[string]interface{}{"kind": "download", "target": "ssg://test/slow/large", "lease": 1})
+			Ω(code).Should(Equal(200))
+
+			started := time.Now()
+			w := g.do("GET", "/blob/"+out["id"].(string), out["token"].(string), nil)
+			Ω(time.Since(started)).Should(BeNumerically(">", 2*time.Second))
+			Ω(w.Code).Should(Equal(200))
+			Ω(w.Body.Len()).Should(Equal(len(data)))
+			Ω(bytes.Equal(w.Body.Bytes(), data)).Should(BeTrue())
+		})
+	})
+})
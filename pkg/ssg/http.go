@@ -1,16 +1,29 @@
 package ssg
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/jhunt/go-log"
 	"github.com/jhunt/go-route"
 
+	"github.com/jhunt/ssg/pkg/jwt"
 	"github.com/jhunt/ssg/pkg/url"
 )
 
+const SegmentSize = 1024 * 1024 // 1MiB
+
 func (s *Server) Router(helo string) *route.Router {
 	r := &route.Router{}
 
@@ -19,7 +32,8 @@ func (s *Server) Router(helo string) *route.Router {
 	})
 
 	r.Dispatch("GET /buckets", func(r *route.Request) {
-		if !authz(r, s.ControlTokens) {
+		scope, ok := s.authz(r, "control")
+		if !ok {
 			return
 		}
 
@@ -31,26 +45,39 @@ func (s *Server) Router(helo string) *route.Router {
 			Encryption  string `json:"encryption"`
 		}
 
-		l := make([]Bucket, len(s.buckets))
-		for i, b := range s.buckets {
-			l[i].Key = b.key
-			l[i].Name = b.name
-			l[i].Description = b.description
-			l[i].Compression = b.compression
-			l[i].Encryption = b.encryption
+		l := make([]Bucket, 0)
+		for _, b := range s.allBuckets() {
+			if !scope.sees(b.key) {
+				continue
+			}
+			l = append(l, Bucket{
+				Key:         b.key,
+				Name:        b.name,
+				Description: b.description,
+				Compression: b.compression,
+				Encryption:  b.encryption,
+			})
 		}
 
 		r.OK(l)
 	})
 
 	r.Dispatch("POST /control", func(r *route.Request) {
-		if !authz(r, s.ControlTokens) {
+		scope, ok := s.authz(r, "control")
+		if !ok {
+			return
+		}
+		if s.shuttingDown() {
+			r.Fail(route.Errorf(503, nil, "gateway is shutting down"))
 			return
 		}
 
 		var in struct {
-			Kind   string `json:"kind"`
-			Target string `json:"target"`
+			Kind      string `json:"kind"`
+			Target    string `json:"target"`
+			Lease     int    `json:"lease"`
+			Operation string `json:"operation"`
+			Size      int64  `json:"size"`
 		}
 		if !r.Payload(&in) {
 			return
@@ -59,7 +86,16 @@ func (s *Server) Router(helo string) *route.Router {
 			return
 		}
 
-		if in.Kind != "upload" && in.Kind != "download" && in.Kind != "expunge" {
+		op := in.Kind
+		switch in.Kind {
+		case "upload", "download", "expunge", "hold", "release":
+		case "sign":
+			op = in.Operation
+			if op != "upload" && op != "download" {
+				r.Fail(route.Bad(nil, "invalid operation to sign: '%s'", in.Operation))
+				return
+			}
+		default:
 			r.Fail(route.Bad(nil, "invalid kind: '%s'", in.Kind))
 			return
 		}
@@ -69,14 +105,70 @@ func (s *Server) Router(helo string) *route.Router {
 			r.Fail(route.Bad(err, "invalid target '%s': %s", in.Target, err))
 			return
 		}
+		if !scope.permits(op, target.Bucket, target.Path) {
+			r.Fail(route.Forbidden(nil, "%s of '%s' is not permitted for this token", op, target))
+			return
+		}
+
+		lease, ceiling := s.leases()
+		if in.Kind == "sign" {
+			signing := s.signingLimits()
+			if signing == nil {
+				r.Fail(route.Bad(nil, "pre-signed urls are not enabled"))
+				return
+			}
+			ceiling = time.Duration(signing.MaxLifetime) * time.Second
+			if op == "upload" && target.Path == "" {
+				r.Fail(route.Bad(nil, "pre-signed uploads require a target path"))
+				return
+			}
+			if op == "upload" && (in.Size <= 0 || in.Size > signing.MaxUploadSize) {
+				r.Fail(route.Bad(nil, "invalid size '%d': must be between 1 and %d bytes", in.Size, signing.MaxUploadSize))
+				return
+			}
+		}
+		if in.Lease != 0 {
+			lease = time.Duration(in.Lease) * time.Second
+			if in.Lease < 0 || lease > ceiling {
+				r.Fail(route.Bad(nil, "invalid lease '%d': must be between 1 and %d seconds", in.Lease, int(ceiling.Seconds())))
+				return
+			}
+		}
+
+		by := requestedBy(r, scope)
+		by.limits = s.limitsFor(scope)
+		started := time.Now()
+		if s.throttled(r, target.Bucket, by) {
+			return
+		}
+
+		if ok, why := s.policyWebhook().decide(policyInput{
+			Identity:  by.identity,
+			Kind:      in.Kind,
+			Operation: op,
+			Cluster:   s.Cluster,
+			Bucket:    target.Bucket,
+			Path:      target.Path,
+			Remote:    by.remote,
+		}); !ok {
+			event := in.Kind
+			if in.Kind == "sign" {
+				event = "sign." + op
+			}
+			s.record(by.record(event, target.String(), "", started, fmt.Errorf("denied: %s", why)))
+			r.Fail(route.Forbidden(nil, "%s of '%s' is not permitted: %s", op, target, why))
+			return
+		}
 
 		switch in.Kind {
 		case "upload":
-			stream, path, err := s.startUpload(target)
+			stream, path, err := s.startUpload(target, lease, by)
 			if err != nil {
-				r.Fail(route.Oops(err, "unable to start upload"))
+				s.record(by.record("upload", target.String(), "", started, err))
+				r.Fail(unableToStart("upload", err))
 				return
 			}
+			s.record(by.record("upload", stream.canon, stream.id, started, nil))
 
 			target.Path = path
 			r.OK(struct {
@@ -95,11 +187,13 @@ func (s *Server) Router(helo string) *route.Router {
 			return
 
 		case "download":
-			stream, err := s.startDownload(target)
+			stream, err := s.startDownload(target, lease, by)
 			if err != nil {
-				r.Fail(route.Oops(err, "unable to start download"))
+				s.record(by.record("download", target.String(), "", started, err))
+				r.Fail(unableToStart("download", err))
 				return
 			}
+			s.record(by.record("download", stream.canon, stream.id, started, nil))
 
 			target.Cluster = s.Cluster
 			r.OK(struct {
@@ -117,8 +211,48 @@ func (s *Server) Router(helo string) *route.Router {
 			})
 			return
 
+		case "sign":
+			target.Cluster = s.Cluster
+			p := presigned{
+				Operation: op,
+				Canon:     target.String(),
+				Expires:   time.Now().Add(lease),
+				By:        by.identity,
+			}
+			if op == "upload" {
+				p.Size = in.Size
+			}
+			link, err := s.presign(p)
+			s.record(by.record("sign."+op, p.Canon, "", started, err))
+			if err != nil {
+				r.Fail(route.Oops(err, "unable to sign %s url", op))
+				return
+			}
+
+			r.OK(struct {
+				Kind      string    `json:"kind"`
+				Operation string    `json:"operation"`
+				Canon     string    `json:"canon"`
+				URL       string    `json:"url"`
+				Size      int64     `json:"size,omitempty"`
+				Expires   time.Time `json:"expires"`
+			}{
+				Kind:      "sign",
+				Operation: op,
+				Canon:     p.Canon,
+				URL:       link,
+				Size:      p.Size,
+				Expires:   p.Expires,
+			})
+			return
+
 		case "expunge":
 			err := s.expunge(target)
+			s.record(by.record("expunge", target.String(), "", started, err))
+			if _, ok := err.(protected); ok {
+				r.Fail(route.Forbidden(err, "unable to expunge: %s", err))
+				return
+			}
 			if err != nil {
 				r.Fail(route.Oops(err, "unable to expunge"))
 				return
@@ -132,6 +266,31 @@ func (s *Server) Router(helo string) *route.Router {
 				Canon: target.String(),
 			})
 			return
+
+		case "hold", "release":
+			err := s.hold(target, in.Kind == "hold")
+			s.record(by.record(in.Kind, target.String(), "", started, err))
+			if _, ok := err.(noHolds); ok {
+				r.Fail(route.Bad(err, "%s", err))
+				return
+			}
+			if err != nil {
+				if in.Kind == "hold" {
+					r.Fail(route.Oops(err, "unable to place legal hold"))
+				} else {
+					r.Fail(route.Oops(err, "unable to release legal hold"))
+				}
+				return
+			}
+
+			r.OK(struct {
+				Kind  string `json:"kind"`
+				Canon string `json:"canon"`
+			}{
+				Kind:  in.Kind,
+				Canon: target.String(),
+			})
+			return
 		}
 	})
 
@@ -147,10 +306,90 @@ func (s *Server) Router(helo string) *route.Router {
 			return
 		}
 
-		r.Header().Set("Content-Type", "application/octet-stream")
-		r.Stream(downstream)
-		downstream.Close()
-		s.forget(downstream)
+		s.send(r, downstream)
+	})
+
+	r.Dispatch("GET /signed/blob", func(r *route.Request) {
+		if s.shuttingDown() {
+			r.Fail(route.Errorf(503, nil, "gateway is shutting down"))
+			return
+		}
+
+		p, target, ok := s.presigned(r, "download")
+		if !ok {
+			return
+		}
+
+		by := signedBy(r, p)
+		if s.throttled(r, target.Bucket, by) {
+			return
+		}
+
+		lease, _ := s.leases()
+		downstream, err := s.startDownload(target, lease, by)
+		if err != nil {
+			r.Fail(unableToStart("download", err))
+			return
+		}
+		s.send(r, downstream)
+	})
+
+	r.Dispatch("PUT /signed/blob", func(r *route.Request) {
+		if s.shuttingDown() {
+			r.Fail(route.Errorf(503, nil, "gateway is shutting down"))
+			return
+		}
+
+		p, target, ok := s.presigned(r, "upload")
+		if !ok {
+			return
+		}
+		if r.Req.ContentLength > p.Size {
+			r.Fail(route.Errorf(413, nil, "upload of %d bytes exceeds the %d byte limit of this url", r.Req.ContentLength, p.Size))
+			return
+		}
+
+		by := signedBy(r, p)
+		if s.throttled(r, target.Bucket, by) {
+			return
+		}
+
+		lease, _ := s.leases()
+		upstream, _, err := s.startUpload(target, lease, by)
+		if err != nil {
+			r.Fail(unableToStart("upload", err))
+			return
+		}
+
+		if err := upstream.encode(r.Req.Header.Get("Content-Encoding")); err != nil {
+			s.cancel(upstream, err.Error())
+			r.Fail(route.Bad(err, "%s", err))
+			return
+		}
+
+		r.Req.Body = ioutil.NopCloser(&bounded{r: r.Req.Body, n: p.Size})
+		n, ok := s.receive(r, upstream, false)
+		if !ok {
+			return
+		}
+		if !s.finish(r, upstream) {
+			return
+		}
+
+		done := upstream.progress(time.Now())
+		r.OK(struct {
+			Canon        string `json:"canon"`
+			Segments     int    `json:"segments"`
+			Compressed   int64  `json:"compressed"`
+			Uncompressed int64  `json:"uncompressed"`
+			Sent         int64  `json:"sent"`
+		}{
+			Canon:        upstream.canon,
+			Segments:     done.segments,
+			Compressed:   done.compressed,
+			Uncompressed: done.uncompressed,
+			Sent:         n,
+		})
 	})
 
 	r.Dispatch("POST /blob/:id", func(r *route.Request) {
@@ -166,21 +405,43 @@ func (s *Server) Router(helo string) *route.Router {
 		}
 
 		var in struct {
-			Data string `json:"data"`
-			EOF  bool   `json:"eof"`
+			Data     string `json:"data"`
+			EOF      bool   `json:"eof"`
+			Encoding string `json:"encoding"`
+			Offset   *int64 `json:"offset"`
+			Seq      *int   `json:"seq"`
 		}
 		if !r.Payload(&in) {
 			return
 		}
 
-		n := 0
+		if err := upstream.encode(in.Encoding); err != nil {
+			r.Fail(route.Bad(err, "%s", err))
+			return
+		}
+
+		var b []byte
 		if in.Data != "" {
-			b, err := base64.StdEncoding.DecodeString(in.Data)
+			decoded, err := base64.StdEncoding.DecodeString(in.Data)
 			if err != nil {
 				r.Fail(route.Bad(err, "unable to decode base64 payload"))
 				return
 			}
+			b = decoded
+		}
+
+		retry, err := upstream.resume(in.Offset, in.Seq, len(b))
+		if err != nil {
+			r.Fail(route.Errorf(409, err, "%s", err))
+			return
+		}
 
+		n := 0
+		if retry {
+			log.Debugf(LOG+"segment is a retry of the last segment written to stream %v; skipping it", upstream.id)
+			n = len(b)
+
+		} else if len(b) > 0 {
 			log.Debugf(LOG+"uploading %d bytes (eof: %v) to stream %v", len(b), in.EOF, upstream.id)
 			n, err = upstream.Write(b)
 			if err != nil {
@@ -191,94 +452,294 @@ func (s *Server) Router(helo string) *route.Router {
 
 		if in.EOF {
 			log.Debugf(LOG+"EOF signaled by client; closing upload stream %v", upstream.id)
-			defer s.forget(upstream)
+			if !s.finish(r, upstream) {
+				return
+			}
+		}
 
-			if upstream.uncompressed.total() == 0 {
-				upstream.Cancel()
-				r.Fail(route.Bad(nil, "zero-byte file detected"))
+		r.OK(blob(upstream, int64(n)))
+	})
+
+	r.Dispatch("PUT /blob/:id", func(r *route.Request) {
+		token, present := requireBearerToken(r, "blob auth")
+		if !present {
+			return
+		}
+
+		upstream, ok := s.getUpload(r.Args[1], token)
+		if !ok {
+			r.Fail(route.NotFound(nil, "stream not found"))
+			return
+		}
+
+		if err := upstream.encode(r.Req.Header.Get("Content-Encoding")); err != nil {
+			r.Fail(route.Bad(err, "%s", err))
+			return
+		}
+
+		if v := r.Param("offset", ""); v != "" {
+			offset, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				r.Fail(route.Bad(err, "invalid offset '%s'", v))
+				return
+			}
+			if committed := upstream.progress(time.Now()).offset; offset != committed {
+				r.Fail(route.Errorf(409, nil, "out-of-order upload: offset %d does not match committed offset %d", offset, committed))
 				return
+			}
+		}
+
+		partial := r.ParamIs("partial", "1")
+		n, ok := s.receive(r, upstream, partial)
+		if !ok {
+			return
+		}
 
-			} else {
-				upstream.Close()
+		if !partial {
+			log.Debugf(LOG+"end of request body; closing upload stream %v", upstream.id)
+			if !s.finish(r, upstream) {
+				return
 			}
 		}
 
+		r.OK(blob(upstream, n))
+	})
+
+	r.Dispatch("POST /blob/:id/keepalive", func(r *route.Request) {
+		token, present := requireBearerToken(r, "blob auth")
+		if !present {
+			return
+		}
+
+		// getUpload() renews the lease for us.
+		upstream, ok := s.getUpload(r.Args[1], token)
+		if !ok {
+			r.Fail(route.NotFound(nil, "stream not found"))
+			return
+		}
+
+		log.Debugf(LOG+"renewed lease on upload stream %v until %v", upstream.id, upstream.expires)
 		r.OK(struct {
-			Segments     int   `json:"segments"`
-			Compressed   int64 `json:"compressed"`
-			Uncompressed int64 `json:"uncompressed"`
-			Sent         int   `json:"sent,omitempty"`
+			Expires time.Time `json:"expires"`
 		}{
-			Segments:     upstream.segments,
-			Compressed:   upstream.compressed.total(),
-			Uncompressed: upstream.uncompressed.total(),
-			Sent:         n,
+			Expires: upstream.expires,
+		})
+	})
+
+	r.Dispatch("GET /blob/:id/status", func(r *route.Request) {
+		token, present := requireBearerToken(r, "blob auth")
+		if !present {
+			return
+		}
+
+		upstream, ok := s.getUpload(r.Args[1], token)
+		if !ok {
+			r.Fail(route.NotFound(nil, "stream not found"))
+			return
+		}
+
+		p := upstream.progress(time.Now())
+		r.OK(struct {
+			Offset       int64     `json:"offset"`
+			Segments     int       `json:"segments"`
+			Compressed   int64     `json:"compressed"`
+			Uncompressed int64     `json:"uncompressed"`
+			Expires      time.Time `json:"expires"`
+		}{
+			Offset:       p.offset,
+			Segments:     p.segments,
+			Compressed:   p.compressed,
+			Uncompressed: p.uncompressed,
+			Expires:      upstream.expires,
 		})
 	})
 
 	r.Dispatch("GET /streams", func(r *route.Request) {
-		if !authz(r, s.ControlTokens) {
+		scope, ok := s.authz(r, "control")
+		if !ok {
 			return
 		}
 
+		kind := r.Param("kind", "")
+		if kind != "" && kind != "upload" && kind != "download" {
+			r.Fail(route.Bad(nil, "invalid kind: '%s'", kind))
+			return
+		}
+
+		var idle time.Duration
+		if v := r.Param("idle_for", ""); v != "" {
+			d, err := duration(v)
+			if err != nil {
+				r.Fail(route.Bad(err, "invalid idle_for: '%s'", v))
+				return
+			}
+			idle = d
+		}
+
+		bucket := r.Param("bucket", "")
+
 		type info struct {
-			Kind     string    `json:"kind"`
-			ID       string    `json:"id"`
-			Canon    string    `json:"canon"`
-			Expires  time.Time `json:"expires"`
-			Received int64     `json:"received"`
+			Kind         string    `json:"kind"`
+			ID           string    `json:"id"`
+			Canon        string    `json:"canon"`
+			Bucket       string    `json:"bucket"`
+			Created      time.Time `json:"created"`
+			Active       time.Time `json:"active"`
+			Expires      time.Time `json:"expires"`
+			Received     int64     `json:"received"`
+			Sent         int64     `json:"sent"`
+			Compressed   int64     `json:"compressed"`
+			Uncompressed int64     `json:"uncompressed"`
+			Segments     int       `json:"segments"`
+			Throughput   float64   `json:"throughput"`
+			Remote       string    `json:"remote"`
+			Identity     string    `json:"identity"`
 		}
 
+		now := time.Now()
 		s.lock.Lock()
 		defer s.lock.Unlock()
 		l := make([]info, 0)
-		for _, v := range s.uploads {
-			l = append(l, info{
-				Kind:     "upload",
-				ID:       v.id,
-				Canon:    v.canon,
-				Expires:  v.expires,
-				Received: v.uncompressed.total(),
-			})
-		}
-		for id, v := range s.downloads {
-			l = append(l, info{
-				Kind:     "download",
-				ID:       id,
-				Canon:    v.canon,
-				Expires:  v.expires,
-				Received: v.uncompressed.total(),
-			})
+		for _, streams := range []map[string]*stream{s.uploads, s.downloads} {
+			for _, v := range streams {
+				if kind != "" && v.kind() != kind {
+					continue
+				}
+				if bucket != "" && v.bucket.key != bucket {
+					continue
+				}
+				if !scope.sees(v.bucket.key) {
+					continue
+				}
+				p := v.progress(now)
+				if p.idle(now) < idle {
+					continue
+				}
+
+				i := info{
+					Kind:         v.kind(),
+					ID:           v.id,
+					Canon:        v.canon,
+					Bucket:       v.bucket.key,
+					Created:      v.created,
+					Active:       p.active,
+					Expires:      v.expires,
+					Compressed:   p.compressed,
+					Uncompressed: p.uncompressed,
+					Segments:     p.segments,
+					Throughput:   p.throughput,
+					Remote:       v.requester.remote,
+					Identity:     v.requester.identity,
+				}
+				if v.writer != nil {
+					i.Received = p.uncompressed
+				} else {
+					i.Sent = p.uncompressed
+				}
+				l = append(l, i)
+			}
 		}
+		sort.Slice(l, func(i, j int) bool {
+			return l[i].Created.Before(l[j].Created)
+		})
 		r.OK(l)
 	})
 
 	r.Dispatch("DELETE /streams/:id", func(r *route.Request) {
-		if !authz(r, s.ControlTokens) {
+		scope, ok := s.authz(r, "control")
+		if !ok {
+			return
+		}
+
+		x, kind := s.lookup(r.Args[1])
+		if x == nil || !scope.sees(x.bucket.key) {
+			r.Fail(route.NotFound(nil, "stream not found"))
 			return
 		}
+		// only tokens that could have started the stream
+		// get to cancel it
+		if !scope.permits(kind, x.bucket.key, x.path) {
+			r.Fail(route.Forbidden(nil, "canceling %s of '%s' is not permitted for this token", kind, x.canon))
+			return
+		}
+
+		if err := s.cancel(x, "canceled by "+requestedBy(r, scope).String()); err != nil {
+			r.Fail(route.Oops(err, "unable to cancel %s stream", kind))
+			return
+		}
+
+		out := struct {
+			Kind     string `json:"kind"`
+			ID       string `json:"id"`
+			Canon    string `json:"canon"`
+			Received int64  `json:"received"`
+			Sent     int64  `json:"sent"`
+		}{
+			Kind:  kind,
+			ID:    x.id,
+			Canon: x.canon,
+		}
+		if p := x.progress(time.Now()); x.writer != nil {
+			out.Received = p.uncompressed
+		} else {
+			out.Sent = p.uncompressed
+		}
+		r.OK(out)
 	})
 
+	r.Dispatch("POST /reload", func(r *route.Request) {
+		scope, ok := s.authz(r, "control")
+		if !ok {
+			return
+		}
+		if !scope.unrestricted() {
+			r.Fail(route.Forbidden(nil, "reloading configuration is not permitted for this token"))
+			return
+		}
+
+		if err := s.Reload(); err != nil {
+			r.Fail(route.Bad(err, "unable to reload configuration: %s", err))
+			return
+		}
+		r.Success("configuration reloaded")
+	})
+
+	s.tus(r)
+
 	r.Dispatch("GET /metrics", func(r *route.Request) {
-		if !authz(r, s.MonitorTokens) {
+		if _, ok := s.authz(r, "monitor"); !ok {
 			return
 		}
 
 		m := make(map[string]*metrics)
-		for _, b := range s.buckets {
+		for _, b := range s.allBuckets() {
 			b.metrics.Recalculate()
 			m[b.key] = b.metrics
 		}
 		r.OK(m)
 	})
 
+	r.Dispatch("GET /metrics/prometheus", func(r *route.Request) {
+		if _, ok := s.authz(r, "monitor"); !ok {
+			return
+		}
+
+		var b bytes.Buffer
+		if err := s.prometheus(&b, strings.TrimPrefix(helo, "ssg ")); err != nil {
+			r.Fail(route.Oops(err, "unable to render metrics"))
+			return
+		}
+		r.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Stream(&b)
+	})
+
 	r.Dispatch("DELETE /metrics", func(r *route.Request) {
-		if !authz(r, s.MonitorTokens) {
+		if _, ok := s.authz(r, "monitor"); !ok {
 			return
 		}
 
 		m := make(map[string]*metrics)
-		for _, b := range s.buckets {
+		for _, b := range s.allBuckets() {
 			b.metrics.Reset()
 			m[b.key] = b.metrics
 		}
@@ -288,6 +749,168 @@ func (s *Server) Router(helo string) *route.Router {
 	return r
 }
 
+// receive copies the request body to the upload stream, in
+// segments of up to SegmentSize bytes.  Only a clean end of
+// body (io.EOF) counts as success.  If the data cannot be
+// written, the stream is canceled.  If the body cannot be
+// read in full (i.e. the client disconnected partway), a
+// resumable upload keeps what has been committed so far,
+// so that the client can pick up from there; any other
+// upload is canceled, so that a truncated blob is never
+// committed.
+func (s *Server) receive(r *route.Request, upstream *stream, resumable bool) (n int64, ok bool) {
+	defer func(start time.Time) {
+		upstream.bucket.metrics.Request("upload", time.Since(start), ok)
+	}(time.Now())
+
+	buf := make([]byte, SegmentSize)
+	for {
+		nread := 0
+		var err error
+		for nread < len(buf) && err == nil {
+			var got int
+			got, err = r.Req.Body.Read(buf[nread:])
+			nread += got
+		}
+
+		if nread > 0 {
+			if !s.touch(upstream) {
+				r.Fail(route.NotFound(nil, "stream not found"))
+				return n, false
+			}
+
+			log.Debugf(LOG+"uploading %d bytes to stream %v", nread, upstream.id)
+			if _, werr := upstream.Write(buf[:nread]); werr == errCanceled {
+				r.Fail(route.NotFound(nil, "stream not found"))
+				return n, false
+			} else if werr != nil {
+				s.cancel(upstream, fmt.Sprintf("unable to upload data: %s", werr))
+				r.Fail(route.Oops(werr, "unable to upload data to stream"))
+				return n, false
+			}
+			n += int64(nread)
+		}
+
+		if err == io.EOF {
+			return n, true
+		}
+		if err == errTooLarge {
+			s.cancel(upstream, "request body exceeds the size limit")
+			r.Fail(route.Errorf(413, nil, "request body exceeds the size limit"))
+			return n, false
+		}
+		if err != nil {
+			if !resumable {
+				s.cancel(upstream, fmt.Sprintf("unable to read request body: %s", err))
+			}
+			r.Fail(route.Bad(err, "unable to read data from request body"))
+			return n, false
+		}
+	}
+}
+
+// sending renews the lease on a download stream each time
+// more of it is read, the same way receive() does for each
+// segment of an upload, so that a transfer that outlives
+// the lease is not swept up partway through.
+//
+// It also remembers why the transfer stopped, if it did
+// not reach the end of the blob.
+type sending struct {
+	server *Server
+	stream *stream
+	err    error
+}
+
+func (o *sending) Read(b []byte) (int, error) {
+	if !o.server.touch(o.stream) {
+		o.err = errCanceled
+		return 0, o.err
+	}
+	n, err := o.stream.Read(b)
+	if err != nil && err != io.EOF {
+		o.err = err
+	}
+	return n, err
+}
+
+func (s *Server) send(r *route.Request, downstream *stream) {
+	r.Header().Set("Content-Type", "application/octet-stream")
+	r.Header().Set("Vary", "Accept-Encoding")
+	if acceptsEncoding(r, "deflate") && downstream.deflate() {
+		log.Debugf(LOG+"client accepts deflate; sending stream %v without decompressing it", downstream.id)
+		r.Header().Set("Content-Encoding", "deflate")
+	}
+	out := &sending{server: s, stream: downstream}
+	start := time.Now()
+	r.Stream(out)
+	downstream.bucket.metrics.Request("download", time.Since(start), out.err == nil)
+	s.forget(downstream)
+	err := downstream.Close()
+	if out.err != nil {
+		err = out.err
+	}
+	if err == errCanceled {
+		// whoever canceled the stream has already audited it.
+	} else if err != nil {
+		s.record(downstream.record("complete", "error", err.Error()))
+	} else {
+		s.record(downstream.record("complete", "ok", ""))
+	}
+
+	if out.err != nil {
+		// abort the response, instead of ending it cleanly,
+		// so that the client can tell that it did not get
+		// all of the blob.
+		log.Errorf(LOG+"download stream %v stopped partway: %s", downstream.id, out.err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (s *Server) finish(r *route.Request, x *stream) bool {
+	defer s.forget(x)
+
+	if err := x.flush(); err != nil {
+		x.Cancel()
+		s.record(x.record("complete", "error", err.Error()))
+		r.Fail(route.Oops(err, "unable to finish upload"))
+		return false
+	}
+
+	if x.progress(time.Now()).uncompressed == 0 {
+		x.Cancel()
+		s.record(x.record("cancel", "canceled", "zero-byte file detected"))
+		r.Fail(route.Bad(nil, "zero-byte file detected"))
+		return false
+	}
+
+	if err := x.Close(); err == errCanceled {
+		r.Fail(route.NotFound(nil, "stream not found"))
+		return false
+	} else if err != nil {
+		s.record(x.record("complete", "error", err.Error()))
+		r.Fail(route.Oops(err, "unable to finish upload"))
+		return false
+	}
+	s.record(x.record("complete", "ok", ""))
+	return true
+}
+
+func blob(upstream *stream, sent int64) interface{} {
+	p := upstream.progress(time.Now())
+	return struct {
+		Segments     int   `json:"segments"`
+		Compressed   int64 `json:"compressed"`
+		Uncompressed int64 `json:"uncompressed"`
+		Sent         int64 `json:"sent,omitempty"`
+	}{
+		Segments:     p.segments,
+		Compressed:   p.compressed,
+		Uncompressed: p.uncompressed,
+		Sent:         sent,
+	}
+}
+
 func getBearerToken(r *route.Request) (string, bool) {
 	if token := r.Req.Header.Get("Authorization"); token == "" {
 		return "", false
@@ -297,6 +920,42 @@ func getBearerToken(r *route.Request) (string, bool) {
 	return "", true
 }
 
+func requestedBy(r *route.Request, scope *Token) requester {
+	by := requester{remote: r.RemoteIP()}
+	if host, _, err := net.SplitHostPort(by.remote); err == nil {
+		by.remote = host
+	}
+	if scope != nil && scope.Name != "" {
+		by.identity = scope.Name
+	} else if token, present := getBearerToken(r); present {
+		by.identity = fingerprint(token)
+	} else if cert := peer(r.Req); cert != nil {
+		by.identity = cert.Subject.String()
+	}
+	return by
+}
+
+func signedBy(r *route.Request, p presigned) requester {
+	by := requestedBy(r, nil)
+	by.identity = "signed:" + p.Key
+	if p.By != "" {
+		by.identity = p.By + " (signed:" + p.Key + ")"
+	}
+	return by
+}
+
+func fingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+func duration(s string) (time.Duration, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	return time.ParseDuration(s)
+}
+
 func requireBearerToken(r *route.Request, typ string) (string, bool) {
 	token, present := getBearerToken(r)
 	if !present {
@@ -309,18 +968,73 @@ func requireBearerToken(r *route.Request, typ string) (string, bool) {
 	return token, true
 }
 
-func authz(r *route.Request, allowed []string) bool {
+func acceptsEncoding(r *route.Request, want string) bool {
+	for _, h := range r.Req.Header.Values("Accept-Encoding") {
+		for _, enc := range strings.Split(h, ",") {
+			l := strings.Split(enc, ";")
+			if strings.ToLower(strings.TrimSpace(l[0])) != want {
+				continue
+			}
+			for _, param := range l[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+						return false
+					}
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) authz(r *route.Request, role string) (*Token, bool) {
+	s.lock.Lock()
+	roles := s.roles
+	control := s.ControlTokens
+	controls := s.controls
+	monitors := s.monitors
+	verifier := s.jwt
+	s.lock.Unlock()
+
+	if _, present := getBearerToken(r); !present {
+		if cert := peer(r.Req); cert != nil {
+			if granted := subjectRole(roles, cert); granted != nil && granted.Role == role {
+				return certScope(granted, cert), true
+			}
+		}
+	}
+
 	token, present := requireBearerToken(r, "control auth")
 	if !present {
-		return false
+		return nil, false
 	}
 
-	for i := range allowed {
-		if allowed[i] == token {
-			return true
+	if verifier != nil && jwt.Looks(token) {
+		scope, granted, err := verifier.authenticate(token)
+		if err == nil && granted == role {
+			return scope, true
+		}
+		if err != nil {
+			log.Debugf(LOG+"rejecting jwt from %s: %s", r.RemoteIP(), err)
+		} else {
+			log.Debugf(LOG+"rejecting jwt from %s: grants the %s role, not %s", r.RemoteIP(), granted, role)
+		}
+		r.Fail(route.Forbidden(nil, "control auth forbidden"))
+		return nil, false
+	}
+
+	if role == "monitor" {
+		if _, ok := monitors.Lookup(token); ok {
+			return nil, true
+		}
+	} else {
+		if i, ok := controls.Lookup(token); ok {
+			return &control[i], true
 		}
 	}
 
 	r.Fail(route.Forbidden(nil, "control auth forbidden"))
-	return false
+	return nil, false
 }
@@ -0,0 +1,144 @@
+package ssg
+
+import (
+	"time"
+
+	"github.com/jhunt/ssg/pkg/ssg/provider"
+	"github.com/jhunt/ssg/pkg/ssg/vault"
+)
+
+type instrumentedProvider struct {
+	inner   provider.Provider
+	metrics *metrics
+}
+
+func instrument(p provider.Provider, m *metrics) provider.Provider {
+	return instrumentedProvider{inner: p, metrics: m}
+}
+
+func (p instrumentedProvider) Upload(path string) (provider.Uploader, error) {
+	start := time.Now()
+	up, err := p.inner.Upload(path)
+	p.metrics.Call("upload_open", time.Since(start), err)
+	if err != nil {
+		return nil, err
+	}
+	return instrumentedUploader{Uploader: up, metrics: p.metrics}, nil
+}
+
+func (p instrumentedProvider) Download(path string) (provider.Downloader, error) {
+	start := time.Now()
+	down, err := p.inner.Download(path)
+	p.metrics.Call("download_open", time.Since(start), err)
+	return down, err
+}
+
+func (p instrumentedProvider) Expunge(path string) error {
+	start := time.Now()
+	err := p.inner.Expunge(path)
+	p.metrics.Call("expunge", time.Since(start), err)
+	return err
+}
+
+type instrumentedLister struct {
+	inner   provider.Lister
+	metrics *metrics
+}
+
+func (l instrumentedLister) List(prefix string) ([]provider.Blob, error) {
+	start := time.Now()
+	blobs, err := l.inner.List(prefix)
+	l.metrics.Call("list", time.Since(start), err)
+	return blobs, err
+}
+
+type instrumentedStater struct {
+	inner   provider.Stater
+	metrics *metrics
+}
+
+func (p instrumentedStater) Stat(path string) (*provider.Blob, error) {
+	start := time.Now()
+	blob, err := p.inner.Stat(path)
+	p.metrics.Call("stat", time.Since(start), err)
+	return blob, err
+}
+
+type instrumentedHolder struct {
+	inner   provider.Holder
+	metrics *metrics
+}
+
+func (p instrumentedHolder) Hold(path string, on bool) error {
+	start := time.Now()
+	err := p.inner.Hold(path, on)
+	p.metrics.Call("hold", time.Since(start), err)
+	return err
+}
+
+func (p instrumentedHolder) Held(path string) (bool, error) {
+	start := time.Now()
+	held, err := p.inner.Held(path)
+	p.metrics.Call("held", time.Since(start), err)
+	return held, err
+}
+
+type instrumentedUploader struct {
+	provider.Uploader
+	metrics *metrics
+}
+
+func (u instrumentedUploader) Write(b []byte) (int, error) {
+	start := time.Now()
+	n, err := u.Uploader.Write(b)
+	u.metrics.Call("upload_write", time.Since(start), err)
+	return n, err
+}
+
+func (u instrumentedUploader) Close() error {
+	start := time.Now()
+	err := u.Uploader.Close()
+	u.metrics.Call("upload_close", time.Since(start), err)
+	return err
+}
+
+func (u instrumentedUploader) Cancel() error {
+	start := time.Now()
+	err := u.Uploader.Cancel()
+	u.metrics.Call("upload_cancel", time.Since(start), err)
+	return err
+}
+
+type instrumentedVault struct {
+	inner   vault.VaultProvider
+	metrics *metrics
+}
+
+func instrumentVault(v vault.VaultProvider, m *metrics) vault.VaultProvider {
+	return instrumentedVault{inner: v, metrics: m}
+}
+
+func (v instrumentedVault) FixedKeyResolver() vault.FixedKeyResolver {
+	return v.inner.FixedKeyResolver()
+}
+
+func (v instrumentedVault) SetCipher(id string, c vault.Cipher) error {
+	start := time.Now()
+	err := v.inner.SetCipher(id, c)
+	v.metrics.Call("set_cipher", time.Since(start), err)
+	return err
+}
+
+func (v instrumentedVault) GetCipher(id string) (vault.Cipher, error) {
+	start := time.Now()
+	c, err := v.inner.GetCipher(id)
+	v.metrics.Call("get_cipher", time.Since(start), err)
+	return c, err
+}
+
+func (v instrumentedVault) Delete(id string) error {
+	start := time.Now()
+	err := v.inner.Delete(id)
+	v.metrics.Call("delete_cipher", time.Since(start), err)
+	return err
+}
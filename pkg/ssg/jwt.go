@@ -0,0 +1,164 @@
+package ssg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jhunt/go-log"
+
+	"github.com/jhunt/ssg/pkg/jwt"
+	"github.com/jhunt/ssg/pkg/ssg/config"
+)
+
+const JWKSRetryInterval = time.Minute
+
+type verifier struct {
+	lock sync.Mutex
+
+	config    config.JWT
+	client    *http.Client
+	keys      *jwt.KeySet
+	fetched   time.Time
+	attempted time.Time
+}
+
+func configureJWT(c *config.JWT) (*verifier, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	v := &verifier{
+		config: *c,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	log.Infof(LOG+"accepting jwts issued by %s for audience %s (keys from %s)", c.Issuer, c.Audience, c.JWKS)
+	v.attempted = time.Now()
+	keys, err := v.load()
+	if err != nil {
+		if !v.remote() {
+			return nil, fmt.Errorf("unable to load jwks from %s: %s", c.JWKS, err)
+		}
+		log.Errorf(LOG+"unable to fetch jwks from %s (will retry): %s", c.JWKS, err)
+		return v, nil
+	}
+	v.keys = keys
+	v.fetched = v.attempted
+	return v, nil
+}
+
+func (v *verifier) remote() bool {
+	return strings.HasPrefix(v.config.JWKS, "http://") || strings.HasPrefix(v.config.JWKS, "https://")
+}
+
+func (v *verifier) load() (*jwt.KeySet, error) {
+	if !v.remote() {
+		b, err := ioutil.ReadFile(v.config.JWKS)
+		if err != nil {
+			return nil, err
+		}
+		return jwt.ParseKeySet(b)
+	}
+
+	res, err := v.client.Get(v.config.JWKS)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("%s", res.Status)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.ParseKeySet(b)
+}
+
+func (v *verifier) keySet(kid string) *jwt.KeySet {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	stale := v.keys == nil || len(v.keys.Find(kid)) == 0
+	if v.remote() && time.Since(v.fetched) > time.Duration(v.config.Refresh)*time.Second {
+		stale = true
+	}
+	if !stale || time.Since(v.attempted) < JWKSRetryInterval {
+		return v.keys
+	}
+
+	log.Infof(LOG+"refreshing jwks from %s", v.config.JWKS)
+	v.attempted = time.Now()
+	keys, err := v.load()
+	if err != nil {
+		log.Errorf(LOG+"unable to refresh jwks from %s (keeping the old keys): %s", v.config.JWKS, err)
+		return v.keys
+	}
+	v.keys = keys
+	v.fetched = v.attempted
+	return v.keys
+}
+
+func (v *verifier) authenticate(token string) (*Token, string, error) {
+	h, _, err := jwt.Parse(token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	keys := v.keySet(h.KeyID)
+	if keys == nil {
+		return nil, "", fmt.Errorf("no jwks loaded")
+	}
+
+	claims, err := jwt.Verify(token, keys)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := claims.Validate(v.config.Issuer, v.config.Audience, time.Now(), time.Duration(v.config.Leeway)*time.Second); err != nil {
+		return nil, "", err
+	}
+
+	for _, r := range v.config.Roles {
+		if !matchClaims(r.Claims, claims) {
+			continue
+		}
+
+		scope := &Token{
+			Operations: r.Operations,
+			Buckets:    r.Buckets,
+			Prefixes:   r.Prefixes,
+			Limits:     r.Limits,
+			auth:       "jwt",
+		}
+		if l := claims.Strings(v.config.Identity); len(l) > 0 {
+			scope.Name = l[0]
+		}
+		if scope.Name == "" && scope.Limits != nil {
+			// limits are per identity; without one, there's
+			// nothing to hold the caller to.
+			return nil, "", fmt.Errorf("no '%s' claim to identify the (rate-limited) caller", v.config.Identity)
+		}
+		return scope, r.Role, nil
+	}
+	return nil, "", fmt.Errorf("no role matches the token claims")
+}
+
+func matchClaims(patterns map[string]string, claims jwt.Claims) bool {
+	for name, pattern := range patterns {
+		ok := false
+		for _, v := range claims.Strings(name) {
+			if ok, _ = path.Match(pattern, v); ok {
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
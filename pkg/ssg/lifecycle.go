@@ -0,0 +1,130 @@
+package ssg
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jhunt/go-log"
+
+	"github.com/jhunt/ssg/pkg/ssg/audit"
+	"github.com/jhunt/ssg/pkg/ssg/config"
+	"github.com/jhunt/ssg/pkg/url"
+)
+
+func (s *Server) Lifecycle() {
+	t := time.NewTicker(s.LifecycleInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+		}
+
+		s.prune(time.Now())
+	}
+}
+
+// prune evaluates every lifecycle rule of every bucket,
+// expunging the blobs that are older than the rule allows.
+// Blobs that are still being uploaded, under legal hold, or
+// within their bucket's minimum retention are left alone.
+func (s *Server) prune(now time.Time) {
+	var uploading map[string]bool
+	for _, b := range s.allBuckets() {
+		if len(b.lifecycle) == 0 {
+			continue
+		}
+		if uploading == nil {
+			uploading = s.uploading()
+		}
+		for i, rule := range b.lifecycle {
+			s.applyLifecycle(b, i+1, rule, now, uploading)
+		}
+	}
+}
+
+func (s *Server) uploading() map[string]bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	m := make(map[string]bool)
+	for _, x := range s.uploads {
+		m[blobKey(x.bucket.key, x.path)] = true
+	}
+	return m
+}
+
+// blobKey identifies a blob by its bucket and path alone,
+// so that uploads and listed blobs compare equal no matter
+// which cluster name the upload was started with.
+func blobKey(bucket, file string) string {
+	return bucket + "/" + strings.TrimPrefix(path.Clean("/"+file), "/")
+}
+
+func (s *Server) applyLifecycle(b *bucket, n int, rule config.Lifecycle, now time.Time, uploading map[string]bool) {
+	cutoff := now.Add(-time.Duration(rule.ExpungeAfter) * 24 * time.Hour)
+	log.Debugf(LOG+"evaluating lifecycle rule #%d for bucket %v (prefix '%s', last modified before %s)", n, b.key, rule.Prefix, cutoff.Format(time.RFC3339))
+
+	blobs, err := b.lister.List(rule.Prefix)
+	if err != nil {
+		log.Errorf(LOG+"unable to list blobs in bucket %v for lifecycle rule #%d: %s", b.key, n, err)
+		return
+	}
+
+	expunged, failed := 0, 0
+	for _, blob := range blobs {
+		if !blob.Modified.Before(cutoff) {
+			continue
+		}
+		canon := url.URL{Cluster: s.Cluster, Bucket: b.key, Path: blob.Path}.String()
+		if uploading[blobKey(b.key, blob.Path)] {
+			log.Debugf(LOG+"skipping %v for lifecycle rule #%d; it is still being uploaded", canon, n)
+			continue
+		}
+
+		if rule.DryRun {
+			if err := b.protected(blob.Path, now); err != nil {
+				log.Infof(LOG+"lifecycle rule #%d for bucket %v would skip %v: %s [dry run]", n, b.key, canon, err)
+				continue
+			}
+			log.Infof(LOG+"lifecycle rule #%d for bucket %v would expunge %v (%d bytes, last modified %s) [dry run]",
+				n, b.key, canon, blob.Size, blob.Modified.Format(time.RFC3339))
+			continue
+		}
+
+		start := time.Now()
+		err := b.Expunge(blob.Path)
+		if _, ok := err.(protected); ok {
+			log.Debugf(LOG+"skipping %v for lifecycle rule #%d: %s", canon, n, err)
+			continue
+		}
+		b.metrics.LifecycleExpunge(blob.Size, err)
+
+		rec := audit.Record{
+			Time:     start,
+			Event:    "lifecycle.expunge",
+			Identity: "lifecycle",
+			Canon:    canon,
+			Outcome:  "ok",
+			Duration: time.Since(start).Seconds(),
+		}
+		if err != nil {
+			log.Errorf(LOG+"lifecycle rule #%d for bucket %v was unable to expunge %v: %s", n, b.key, canon, err)
+			rec.Outcome = "error"
+			rec.Reason = err.Error()
+			failed++
+		} else {
+			log.Infof(LOG+"lifecycle rule #%d for bucket %v expunged %v (%d bytes, last modified %s)",
+				n, b.key, canon, blob.Size, blob.Modified.Format(time.RFC3339))
+			expunged++
+		}
+		s.record(rec)
+	}
+
+	if expunged > 0 || failed > 0 {
+		log.Infof(LOG+"lifecycle rule #%d for bucket %v expunged %d blobs (%d failed)", n, b.key, expunged, failed)
+	}
+}
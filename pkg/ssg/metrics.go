@@ -3,6 +3,7 @@ package ssg
 import (
 	"math"
 	"sync"
+	"time"
 
 	"github.com/jhunt/go-sample"
 )
@@ -25,9 +26,59 @@ func (d *delta) total() int64 {
 	return d.n
 }
 
+var BackendOperations = []string{
+	"upload_open",
+	"upload_write",
+	"upload_close",
+	"upload_cancel",
+	"download_open",
+	"expunge",
+	"list",
+	"stat",
+	"hold",
+	"held",
+	"set_cipher",
+	"get_cipher",
+	"delete_cipher",
+}
+
+// RequestKinds are the kinds of client requests whose
+// bodies the gateway relays, and times: upload bodies in,
+// and download bodies out.
+var RequestKinds = []string{
+	"upload",
+	"download",
+}
+
+type call struct {
+	Calls   int64 `json:"calls"`
+	Errors  int64 `json:"errors"`
+	Seconds struct {
+		Total   float64 `json:"total"`
+		Maximum float64 `json:"maximum"`
+	} `json:"seconds"`
+}
+
+type latency struct {
+	durations *histogram
+	errors    int64
+}
+
 type metrics struct {
 	lock sync.Mutex
 
+	// totals are never reset, for the benefit of
+	// pull-based scrapers that calculate rates.
+	totals struct {
+		upload, download, expunge int64
+		canceled                  struct{ upload, download int64 }
+		front, back               struct{ in, out int64 }
+		lifecycle                 struct{ expunged, bytes, errors int64 }
+	}
+	sizes    *histogram
+	calls    map[string]*latency
+	requests map[string]*latency
+
 	Operations struct {
 		Upload   int `json:"upload"`
 		Download int `json:"download"`
@@ -39,6 +90,12 @@ type metrics struct {
 		Download int `json:"download"`
 	} `json:"canceled"`
 
+	Lifecycle struct {
+		Expunged int   `json:"expunged"`
+		Bytes    int64 `json:"bytes"`
+		Errors   int   `json:"errors"`
+	} `json:"lifecycle"`
+
 	segments sample.Reservoir
 	Segments struct {
 		Total int `json:"total"`
@@ -61,11 +118,27 @@ type metrics struct {
 			Out int64 `json:"out"`
 		} `json:"back"`
 	} `json:"transfer"`
+
+	Backend  map[string]*call `json:"backend"`
+	Requests map[string]*call `json:"requests"`
 }
 
 func newMetric(max int) *metrics {
 	m := &metrics{}
 	m.segments = sample.NewReservoir(max)
+	m.sizes = newHistogram(SegmentSizeBuckets)
+	m.calls = make(map[string]*latency)
+	m.Backend = make(map[string]*call)
+	for _, op := range BackendOperations {
+		m.calls[op] = &latency{durations: newHistogram(LatencyBuckets)}
+		m.Backend[op] = &call{}
+	}
+	m.requests = make(map[string]*latency)
+	m.Requests = make(map[string]*call)
+	for _, kind := range RequestKinds {
+		m.requests[kind] = &latency{durations: newHistogram(LatencyBuckets)}
+		m.Requests[kind] = &call{}
+	}
 	return m
 }
 
@@ -111,70 +184,145 @@ func (m *metrics) Reset() {
 	m.Canceled.Upload = 0
 	m.Canceled.Download = 0
 
+	m.Lifecycle.Expunged = 0
+	m.Lifecycle.Bytes = 0
+	m.Lifecycle.Errors = 0
+
 	m.segments.Reset()
 
 	m.Transfer.Front.In = 0
 	m.Transfer.Front.Out = 0
 	m.Transfer.Back.In = 0
 	m.Transfer.Back.Out = 0
+
+	for op := range m.Backend {
+		m.Backend[op] = &call{}
+	}
+	for kind := range m.Requests {
+		m.Requests[kind] = &call{}
+	}
 }
 
 func (m *metrics) StartUpload() {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	m.Operations.Upload++
+	m.totals.upload++
 }
 
 func (m *metrics) CancelUpload() {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	m.Canceled.Upload++
+	m.totals.canceled.upload++
 }
 
 func (m *metrics) StartDownload() {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	m.Operations.Download++
+	m.totals.download++
 }
 
 func (m *metrics) CancelDownload() {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	m.Canceled.Download++
+	m.totals.canceled.download++
 }
 
 func (m *metrics) Expunge() {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	m.Operations.Expunge++
+	m.totals.expunge++
+}
+
+func (m *metrics) LifecycleExpunge(bytes int64, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if err != nil {
+		m.Lifecycle.Errors++
+		m.totals.lifecycle.errors++
+		return
+	}
+	m.Lifecycle.Expunged++
+	m.Lifecycle.Bytes += bytes
+	m.totals.lifecycle.expunged++
+	m.totals.lifecycle.bytes += bytes
 }
 
 func (m *metrics) Segment(size int) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	m.segments.Sample(float64(size))
+	m.sizes.observe(float64(size))
 }
 
 func (m *metrics) InFront(bytes int64) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	m.Transfer.Front.In += bytes
+	m.totals.front.in += bytes
 }
 
 func (m *metrics) OutFront(bytes int64) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	m.Transfer.Front.Out += bytes
+	m.totals.front.out += bytes
 }
 
 func (m *metrics) InBack(bytes int64) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	m.Transfer.Back.In += bytes
+	m.totals.back.in += bytes
 }
 
 func (m *metrics) OutBack(bytes int64) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	m.Transfer.Back.Out += bytes
+	m.totals.back.out += bytes
+}
+
+func (m *metrics) Call(op string, took time.Duration, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	observe(m.Backend, m.calls, op, took, err != nil)
+}
+
+// Request records how long the gateway took to relay the
+// body of a client request, so that it can be compared
+// with the time spent in the backend calls it made.
+func (m *metrics) Request(kind string, took time.Duration, ok bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	observe(m.Requests, m.requests, kind, took, !ok)
+}
+
+// observe must be called with the metrics lock held
+func observe(calls map[string]*call, latencies map[string]*latency, name string, took time.Duration, failed bool) {
+	c, ok := calls[name]
+	if !ok {
+		c = &call{}
+		calls[name] = c
+	}
+	l, ok := latencies[name]
+	if !ok {
+		l = &latency{durations: newHistogram(LatencyBuckets)}
+		latencies[name] = l
+	}
+
+	c.Calls++
+	c.Seconds.Total += took.Seconds()
+	if took.Seconds() > c.Seconds.Maximum {
+		c.Seconds.Maximum = took.Seconds()
+	}
+	l.durations.observe(took.Seconds())
+	if failed {
+		c.Errors++
+		l.errors++
+	}
 }
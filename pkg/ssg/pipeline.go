@@ -0,0 +1,138 @@
+package ssg
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/jhunt/ssg/pkg/ssg/provider"
+)
+
+// A pipeline decouples accepting segments from the client
+// from compressing, encrypting and writing them to the
+// provider, so that clients don't have to wait on the
+// backend before sending their next segment.  Up to depth
+// segments can be queued; past that, enqueue blocks.
+//
+// A failed write is reported by the next call to enqueue,
+// or by wait, whichever comes first.
+type pipeline struct {
+	segments chan []byte
+	done     chan struct{}
+
+	// sending guards segments against being closed out
+	// from under an in-progress enqueue.
+	sending sync.Mutex
+	started bool
+	closed  bool
+
+	lock         sync.Mutex
+	err          error
+	canceled     bool
+	compressed   int64
+	uncompressed int64
+}
+
+func newPipeline(depth int) *pipeline {
+	if depth <= 0 {
+		return nil
+	}
+	return &pipeline{
+		segments: make(chan []byte, depth),
+		done:     make(chan struct{}),
+	}
+}
+
+func (p *pipeline) run(to provider.Uploader) {
+	defer close(p.done)
+
+	for b := range p.segments {
+		if p.failed() != nil {
+			continue
+		}
+
+		if _, err := to.Write(b); err != nil {
+			p.fail(err)
+			continue
+		}
+
+		p.lock.Lock()
+		p.compressed = to.WroteCompressed()
+		p.uncompressed = to.WroteUncompressed()
+		p.lock.Unlock()
+	}
+}
+
+func (p *pipeline) failed() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.err == nil && p.canceled {
+		return fmt.Errorf("stream was canceled")
+	}
+	return p.err
+}
+
+func (p *pipeline) fail(err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// wrote returns how much has been written to the
+// provider so far, compressed and uncompressed.
+func (p *pipeline) wrote() (int64, int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.compressed, p.uncompressed
+}
+
+// enqueue a copy of b for writing, starting the background
+// writer on the first segment.
+func (p *pipeline) enqueue(to provider.Uploader, b []byte) error {
+	if err := p.failed(); err != nil {
+		return err
+	}
+
+	p.sending.Lock()
+	defer p.sending.Unlock()
+	if p.closed {
+		return fmt.Errorf("stream is no longer accepting data")
+	}
+	if !p.started {
+		p.started = true
+		go p.run(to)
+	}
+
+	segment := make([]byte, len(b))
+	copy(segment, b)
+	p.segments <- segment
+	return nil
+}
+
+// wait for every queued segment to be written, and return
+// the first error encountered, if any.
+func (p *pipeline) wait() error {
+	p.sending.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.segments)
+	}
+	started := p.started
+	p.sending.Unlock()
+
+	if started {
+		<-p.done
+	}
+	return p.failed()
+}
+
+// cancel drops any segments that have not been written yet,
+// and waits for the background writer to stop.
+func (p *pipeline) cancel() {
+	p.lock.Lock()
+	p.canceled = true
+	p.lock.Unlock()
+
+	p.wait()
+}
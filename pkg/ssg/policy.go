@@ -0,0 +1,145 @@
+package ssg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jhunt/go-log"
+
+	"github.com/jhunt/ssg/pkg/ssg/config"
+)
+
+type policyInput struct {
+	Identity  string `json:"identity"`
+	Kind      string `json:"kind"`
+	Operation string `json:"operation"`
+	Cluster   string `json:"cluster"`
+	Bucket    string `json:"bucket"`
+	Path      string `json:"path"`
+	Remote    string `json:"remote"`
+}
+
+type decision struct {
+	allow   bool
+	reason  string
+	expires time.Time
+}
+
+type policy struct {
+	lock sync.Mutex
+
+	config config.Webhook
+	client *http.Client
+	cache  map[policyInput]decision
+}
+
+func configurePolicy(c *config.Authz) *policy {
+	if c == nil || c.Webhook == nil {
+		return nil
+	}
+
+	if c.Webhook.FailOpen {
+		log.Infof(LOG+"consulting policy webhook %s for control requests (failing open)", c.Webhook.URL)
+	} else {
+		log.Infof(LOG+"consulting policy webhook %s for control requests (failing closed)", c.Webhook.URL)
+	}
+	return &policy{
+		config: *c.Webhook,
+		client: &http.Client{Timeout: time.Duration(c.Webhook.Timeout) * time.Second},
+		cache:  make(map[policyInput]decision),
+	}
+}
+
+func (p *policy) decide(in policyInput) (bool, string) {
+	if p == nil {
+		return true, ""
+	}
+
+	now := time.Now()
+	p.lock.Lock()
+	d, ok := p.cache[in]
+	p.lock.Unlock()
+	if ok && now.Before(d.expires) {
+		return d.allow, d.reason
+	}
+
+	d, err := p.ask(in)
+	if err != nil {
+		if p.config.FailOpen {
+			log.Warnf(LOG+"policy webhook %s failed (allowing %s of %s/%s by %s): %s", p.config.URL, in.Operation, in.Bucket, in.Path, in.Identity, err)
+			return true, ""
+		}
+		log.Errorf(LOG+"policy webhook %s failed (denying %s of %s/%s by %s): %s", p.config.URL, in.Operation, in.Bucket, in.Path, in.Identity, err)
+		return false, "policy service unavailable"
+	}
+
+	if p.config.CacheTTL > 0 {
+		d.expires = now.Add(time.Duration(p.config.CacheTTL) * time.Second)
+		p.lock.Lock()
+		for k, v := range p.cache {
+			if now.After(v.expires) {
+				delete(p.cache, k)
+			}
+		}
+		p.cache[in] = d
+		p.lock.Unlock()
+	}
+	return d.allow, d.reason
+}
+
+func (p *policy) ask(in policyInput) (decision, error) {
+	var d decision
+
+	b, err := json.Marshal(struct {
+		Input policyInput `json:"input"`
+	}{Input: in})
+	if err != nil {
+		return d, err
+	}
+
+	res, err := p.client.Post(p.config.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return d, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return d, fmt.Errorf("%s", res.Status)
+	}
+
+	b, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		return d, err
+	}
+
+	var out struct {
+		Result json.RawMessage `json:"result"`
+		Allow  bool            `json:"allow"`
+		Reason string          `json:"reason"`
+	}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return d, fmt.Errorf("malformed response: %s", err)
+	}
+
+	d.allow, d.reason = out.Allow, out.Reason
+	if len(out.Result) > 0 {
+		var result struct {
+			Allow  bool   `json:"allow"`
+			Reason string `json:"reason"`
+		}
+		if err := json.Unmarshal(out.Result, &d.allow); err != nil {
+			if err := json.Unmarshal(out.Result, &result); err != nil {
+				return d, fmt.Errorf("malformed result: %s", err)
+			}
+			d.allow, d.reason = result.Allow, result.Reason
+		}
+	}
+	if !d.allow && d.reason == "" {
+		d.reason = "denied by policy"
+	}
+	return d, nil
+}
@@ -0,0 +1,243 @@
+package ssg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var SegmentSizeBuckets = []float64{
+	1024,             // 1KiB
+	4 * 1024,         // 4KiB
+	16 * 1024,        // 16KiB
+	64 * 1024,        // 64KiB
+	256 * 1024,       // 256KiB
+	1024 * 1024,      // 1MiB
+	4 * 1024 * 1024,  // 4MiB
+	16 * 1024 * 1024, // 16MiB
+	64 * 1024 * 1024, // 64MiB
+}
+
+var LatencyBuckets = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30,
+}
+
+type histogram struct {
+	bounds []float64
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i := range h.bounds {
+		if v <= h.bounds[i] {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) copy() *histogram {
+	c := newHistogram(h.bounds)
+	copy(c.counts, h.counts)
+	c.sum = h.sum
+	c.count = h.count
+	return c
+}
+
+type exposition struct {
+	buf bytes.Buffer
+}
+
+func (e *exposition) family(name, typ, help string) {
+	fmt.Fprintf(&e.buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(&e.buf, "# TYPE %s %s\n", name, typ)
+}
+
+func (e *exposition) sample(name string, v float64, labels ...string) {
+	e.buf.WriteString(name)
+	if len(labels) > 0 {
+		e.buf.WriteString("{")
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				e.buf.WriteString(",")
+			}
+			fmt.Fprintf(&e.buf, `%s="%s"`, labels[i], escapeLabel(labels[i+1]))
+		}
+		e.buf.WriteString("}")
+	}
+	e.buf.WriteString(" ")
+	e.buf.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	e.buf.WriteString("\n")
+}
+
+func (e *exposition) histogram(name string, h *histogram, labels ...string) {
+	var n int64
+	for i := range h.bounds {
+		n += h.counts[i]
+		e.sample(name+"_bucket", float64(n), append(labels, "le", strconv.FormatFloat(h.bounds[i], 'f', -1, 64))...)
+	}
+	e.sample(name+"_bucket", float64(h.count), append(labels, "le", "+Inf")...)
+	e.sample(name+"_sum", h.sum, labels...)
+	e.sample(name+"_count", float64(h.count), labels...)
+}
+
+func escapeLabel(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return s
+}
+
+func (s *Server) prometheus(out io.Writer, version string) error {
+	type snapshot struct {
+		key      string
+		upload   int
+		download int
+		sizes    *histogram
+		calls    map[string]*latency
+		requests map[string]*latency
+		totals   struct {
+			upload, download, expunge int64
+			canceled                  struct{ upload, download int64 }
+			front, back               struct{ in, out int64 }
+			lifecycle                 struct{ expunged, bytes, errors int64 }
+		}
+	}
+
+	buckets := s.allBuckets()
+	l := make([]*snapshot, len(buckets))
+	byKey := make(map[string]*snapshot)
+	for i, b := range buckets {
+		b.metrics.lock.Lock()
+		l[i] = &snapshot{
+			key:      b.key,
+			sizes:    b.metrics.sizes.copy(),
+			calls:    make(map[string]*latency),
+			requests: make(map[string]*latency),
+			totals:   b.metrics.totals,
+		}
+		for op, c := range b.metrics.calls {
+			l[i].calls[op] = &latency{
+				durations: c.durations.copy(),
+				errors:    c.errors,
+			}
+		}
+		for kind, c := range b.metrics.requests {
+			l[i].requests[kind] = &latency{
+				durations: c.durations.copy(),
+				errors:    c.errors,
+			}
+		}
+		b.metrics.lock.Unlock()
+		byKey[b.key] = l[i]
+	}
+
+	s.lock.Lock()
+	for _, x := range s.uploads {
+		if b, ok := byKey[x.bucket.key]; ok {
+			b.upload++
+		}
+	}
+	for _, x := range s.downloads {
+		if b, ok := byKey[x.bucket.key]; ok {
+			b.download++
+		}
+	}
+	s.lock.Unlock()
+
+	var e exposition
+	e.family("ssg_build_info", "gauge", "Build information about the running SSG gateway.")
+	e.sample("ssg_build_info", 1, "version", version, "goversion", runtime.Version())
+
+	e.family("ssg_operations_total", "counter", "Total number of control operations started, by bucket and operation.")
+	for _, b := range l {
+		e.sample("ssg_operations_total", float64(b.totals.upload), "bucket", b.key, "operation", "upload")
+		e.sample("ssg_operations_total", float64(b.totals.download), "bucket", b.key, "operation", "download")
+		e.sample("ssg_operations_total", float64(b.totals.expunge), "bucket", b.key, "operation", "expunge")
+	}
+
+	e.family("ssg_canceled_total", "counter", "Total number of streams canceled before completion, by bucket and kind.")
+	for _, b := range l {
+		e.sample("ssg_canceled_total", float64(b.totals.canceled.upload), "bucket", b.key, "kind", "upload")
+		e.sample("ssg_canceled_total", float64(b.totals.canceled.download), "bucket", b.key, "kind", "download")
+	}
+
+	e.family("ssg_lifecycle_expunged_total", "counter", "Total number of blobs expunged by bucket lifecycle rules.")
+	for _, b := range l {
+		e.sample("ssg_lifecycle_expunged_total", float64(b.totals.lifecycle.expunged), "bucket", b.key)
+	}
+
+	e.family("ssg_lifecycle_expunged_bytes_total", "counter", "Total size of the blobs expunged by bucket lifecycle rules, in bytes.")
+	for _, b := range l {
+		e.sample("ssg_lifecycle_expunged_bytes_total", float64(b.totals.lifecycle.bytes), "bucket", b.key)
+	}
+
+	e.family("ssg_lifecycle_errors_total", "counter", "Total number of blobs that bucket lifecycle rules failed to expunge.")
+	for _, b := range l {
+		e.sample("ssg_lifecycle_errors_total", float64(b.totals.lifecycle.errors), "bucket", b.key)
+	}
+
+	e.family("ssg_transfer_bytes_total", "counter", "Total bytes transferred, by bucket, side (front is clients, back is storage) and direction.")
+	for _, b := range l {
+		e.sample("ssg_transfer_bytes_total", float64(b.totals.front.in), "bucket", b.key, "side", "front", "direction", "in")
+		e.sample("ssg_transfer_bytes_total", float64(b.totals.front.out), "bucket", b.key, "side", "front", "direction", "out")
+		e.sample("ssg_transfer_bytes_total", float64(b.totals.back.in), "bucket", b.key, "side", "back", "direction", "in")
+		e.sample("ssg_transfer_bytes_total", float64(b.totals.back.out), "bucket", b.key, "side", "back", "direction", "out")
+	}
+
+	e.family("ssg_segment_bytes", "histogram", "Size of uploaded segments, in bytes.")
+	for _, b := range l {
+		e.histogram("ssg_segment_bytes", b.sizes, "bucket", b.key)
+	}
+
+	e.family("ssg_active_streams", "gauge", "Number of upload and download streams currently in flight.")
+	for _, b := range l {
+		e.sample("ssg_active_streams", float64(b.upload), "bucket", b.key, "kind", "upload")
+		e.sample("ssg_active_streams", float64(b.download), "bucket", b.key, "kind", "download")
+	}
+
+	e.family("ssg_request_duration_seconds", "histogram", "Time spent relaying the bodies of client requests, by bucket and stream kind.")
+	for _, b := range l {
+		for _, kind := range RequestKinds {
+			e.histogram("ssg_request_duration_seconds", b.requests[kind].durations, "bucket", b.key, "kind", kind)
+		}
+	}
+
+	e.family("ssg_request_errors_total", "counter", "Total number of client requests whose bodies could not be relayed, by bucket and stream kind.")
+	for _, b := range l {
+		for _, kind := range RequestKinds {
+			e.sample("ssg_request_errors_total", float64(b.requests[kind].errors), "bucket", b.key, "kind", kind)
+		}
+	}
+
+	e.family("ssg_backend_call_duration_seconds", "histogram", "Time spent in storage provider and vault calls, by bucket and operation.")
+	for _, b := range l {
+		for _, op := range BackendOperations {
+			e.histogram("ssg_backend_call_duration_seconds", b.calls[op].durations, "bucket", b.key, "operation", op)
+		}
+	}
+
+	e.family("ssg_backend_call_errors_total", "counter", "Total number of failed storage provider and vault calls, by bucket and operation.")
+	for _, b := range l {
+		for _, op := range BackendOperations {
+			e.sample("ssg_backend_call_errors_total", float64(b.calls[op].errors), "bucket", b.key, "operation", op)
+		}
+	}
+
+	_, err := io.Copy(out, &e.buf)
+	return err
+}
@@ -3,6 +3,8 @@ package provider
 import (
 	"compress/zlib"
 	"io"
+
+	"github.com/jhunt/ssg/pkg/meter"
 )
 
 type ZlibUploader struct {
@@ -44,6 +46,16 @@ func (z *ZlibUploader) Cancel() error {
 	return z.inner.Cancel()
 }
 
+// Raw returns the underlying Uploader, for callers that
+// already have zlib-compressed data; only valid before the
+// first call to Write().
+func (z *ZlibUploader) Raw() (Uploader, bool) {
+	if z.n > 0 {
+		return nil, false
+	}
+	return z.inner, true
+}
+
 type ZlibDownloader struct {
 	r     io.ReadCloser
 	inner Downloader
@@ -51,6 +63,17 @@ type ZlibDownloader struct {
 }
 
 func (z *ZlibDownloader) Read(b []byte) (int, error) {
+	// we defer reading the zlib header until the first
+	// Read(), so that Raw() can still hand off the
+	// unadulterated compressed stream.
+	if z.r == nil {
+		zr, err := zlib.NewReader(z.inner)
+		if err != nil {
+			return 0, err
+		}
+		z.r = meter.NewReader(zr)
+	}
+
 	n, err := z.r.Read(b)
 	if err == nil || err == io.EOF {
 		z.n += int64(n)
@@ -59,7 +82,13 @@ func (z *ZlibDownloader) Read(b []byte) (int, error) {
 }
 
 func (z *ZlibDownloader) Close() error {
-	return z.r.Close()
+	if z.r != nil {
+		if err := z.r.Close(); err != nil {
+			return err
+		}
+	}
+	// zlib.Reader's Close() does NOT close the underlying io.Reader...
+	return z.inner.Close()
 }
 
 func (z *ZlibDownloader) ReadCompressed() int64 {
@@ -69,3 +98,13 @@ func (z *ZlibDownloader) ReadCompressed() int64 {
 func (z *ZlibDownloader) ReadUncompressed() int64 {
 	return z.n
 }
+
+// Raw returns the underlying Downloader, for callers that
+// can inflate the zlib data themselves; only valid before
+// the first call to Read().
+func (z *ZlibDownloader) Raw() (Downloader, bool) {
+	if z.r != nil {
+		return nil, false
+	}
+	return z.inner, true
+}
@@ -4,8 +4,6 @@ import (
 	"fmt"
 
 	"compress/zlib"
-
-	"github.com/jhunt/ssg/pkg/meter"
 )
 
 func Compress(ul Uploader, alg string) (Uploader, error) {
@@ -27,12 +25,7 @@ func Decompress(dl Downloader, alg string) (Downloader, error) {
 	case "none", "":
 		return dl, nil
 	case "zlib":
-		zr, err := zlib.NewReader(dl)
-		if err != nil {
-			return nil, err
-		}
 		return &ZlibDownloader{
-			r:     meter.NewReader(zr),
 			inner: dl,
 		}, nil
 	default:
@@ -2,6 +2,7 @@ package provider
 
 import (
 	"io"
+	"time"
 )
 
 type Provider interface {
@@ -19,6 +20,40 @@ type Uploader interface {
 	Cancel() error
 }
 
+// A Buffered Provider holds fixed-size buffers in memory
+// for every stream that is in progress: UploadBuffer for
+// each upload, and DownloadBuffer for each download.
+type Buffered interface {
+	UploadBuffer() int64
+	DownloadBuffer() int64
+}
+
+// A Lister Provider can enumerate the blobs it holds
+// under a given path prefix.
+type Lister interface {
+	List(prefix string) ([]Blob, error)
+}
+
+// A Stater Provider can look up a single blob, returning
+// nil (and no error) if it does not exist.
+type Stater interface {
+	Stat(path string) (*Blob, error)
+}
+
+// A Holder Provider can place legal holds on blobs.  For
+// providers that support it natively (i.e. S3 Object Lock),
+// the backend itself refuses to delete held blobs.
+type Holder interface {
+	Hold(path string, on bool) error
+	Held(path string) (bool, error)
+}
+
+type Blob struct {
+	Path     string
+	Size     int64
+	Modified time.Time
+}
+
 type Downloader interface {
 	io.Reader
 	io.Closer
@@ -0,0 +1,188 @@
+package provider
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// DefaultChunkSize is the size (in MiB) of each ranged
+// request, if not otherwise specified.
+const DefaultChunkSize = 8
+
+// Ranges configures downloading large blobs as several
+// concurrent ranged requests, instead of one long one.
+type Ranges struct {
+	Workers   int
+	ChunkSize int64
+}
+
+// NewRanges builds a Ranges for the given number of
+// workers and chunk size (in MiB).  With fewer than two
+// workers, blobs are downloaded in one request.
+func NewRanges(workers, chunk int) Ranges {
+	if chunk == 0 {
+		chunk = DefaultChunkSize
+	}
+	return Ranges{
+		Workers:   workers,
+		ChunkSize: int64(chunk) * 1024 * 1024,
+	}
+}
+
+// Buffer is the most memory that a single download can
+// hold on to (see RangedDownload), or 0 if blobs are not
+// downloaded in ranges.
+func (r Ranges) Buffer() int64 {
+	if r.Workers <= 1 || r.ChunkSize <= 0 {
+		return 0
+	}
+	return int64(r.Workers+1) * r.ChunkSize
+}
+
+// Use reports whether a blob of the given size should
+// be downloaded in ranges.
+func (r Ranges) Use(size int64) bool {
+	return r.Workers > 1 && r.ChunkSize > 0 && size > r.ChunkSize
+}
+
+// A RangeFetcher opens a reader for exactly length bytes
+// of a blob, starting at offset.  Fetchers should pin every
+// range to the version of the blob that was sized up (i.e.
+// via If-Match), and fail if that version is gone, so that
+// a blob overwritten mid-download is never spliced together
+// from two different versions.
+type RangeFetcher func(offset, length int64) (io.ReadCloser, error)
+
+type chunk struct {
+	offset int64
+	length int64
+	data   []byte
+	err    error
+	done   chan struct{}
+}
+
+type rangedReader struct {
+	pending chan *chunk
+	stop    chan struct{}
+	once    sync.Once
+
+	size    int64
+	read    int64
+	current *chunk
+	err     error
+}
+
+// RangedDownload fetches a blob of a known size in
+// chunks, with up to r.Workers chunks in flight at
+// once, and reassembles them in order.
+//
+// A chunk is only fetched once it has been queued, and
+// the queue holds at most r.Workers chunks, so at most
+// r.Workers + 1 chunks (the queue, plus the one being
+// read) of r.ChunkSize bytes are held in memory.
+func RangedDownload(size int64, r Ranges, fetch RangeFetcher) (MeteredDownloader, error) {
+	rd := &rangedReader{
+		pending: make(chan *chunk, r.Workers),
+		stop:    make(chan struct{}),
+		size:    size,
+	}
+	go rd.dispatch(size, r, fetch)
+	return MeteredDownload(rd)
+}
+
+func (rd *rangedReader) dispatch(size int64, r Ranges, fetch RangeFetcher) {
+	defer close(rd.pending)
+
+	workers := make(chan struct{}, r.Workers)
+	for offset := int64(0); offset < size; offset += r.ChunkSize {
+		c := &chunk{
+			offset: offset,
+			length: r.ChunkSize,
+			done:   make(chan struct{}),
+		}
+		if c.offset+c.length > size {
+			c.length = size - c.offset
+		}
+
+		select {
+		case workers <- struct{}{}:
+		case <-rd.stop:
+			return
+		}
+		select {
+		case rd.pending <- c:
+		case <-rd.stop:
+			return
+		}
+
+		go func() {
+			defer close(c.done)
+			defer func() { <-workers }()
+
+			body, err := fetch(c.offset, c.length)
+			if err != nil {
+				c.err = err
+				return
+			}
+			defer body.Close()
+
+			// read into a buffer of exactly the right size,
+			// to keep memory use within the bound above.
+			c.data = make([]byte, c.length)
+			if n, err := io.ReadFull(body, c.data); err != nil {
+				c.err = fmt.Errorf("short read: expected %d bytes at offset %d, but got %d (%s)", c.length, c.offset, n, err)
+			}
+		}()
+	}
+}
+
+func (rd *rangedReader) Read(b []byte) (int, error) {
+	for rd.current == nil || len(rd.current.data) == 0 {
+		if rd.err != nil {
+			return 0, rd.err
+		}
+		if rd.read == rd.size {
+			return 0, io.EOF
+		}
+
+		// a download that is closed before its last chunk
+		// has been read must not look like one that ended
+		// cleanly, lest a truncated blob pass for a whole one.
+		select {
+		case <-rd.stop:
+			rd.err = rd.stopped()
+			return 0, rd.err
+		default:
+		}
+
+		c, ok := <-rd.pending
+		if !ok {
+			rd.err = rd.stopped()
+			return 0, rd.err
+		}
+		<-c.done
+		if c.err != nil {
+			rd.err = c.err
+			rd.Close()
+			return 0, rd.err
+		}
+		rd.current = c
+	}
+
+	n := copy(b, rd.current.data)
+	rd.current.data = rd.current.data[n:]
+	rd.read += int64(n)
+	return n, nil
+}
+
+func (rd *rangedReader) stopped() error {
+	return fmt.Errorf("ranged download stopped after %d of %d bytes", rd.read, rd.size)
+}
+
+func (rd *rangedReader) Close() error {
+	rd.once.Do(func() {
+		close(rd.stop)
+	})
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -259,4 +260,123 @@ var _ = Describe("FS Provider", func() {
 			Ω(hex.EncodeToString(ck.Sum(nil))).Should(Equal("872e2c6727b8e809cbe5baf15f05997753cd7818"))
 		})
 	})
+
+	Context("listing files", func() {
+		var provider fs.Provider
+		var root string
+
+		BeforeEach(func() {
+			var err error
+			root, err = ioutil.TempDir("", "ssg-fs-test-")
+			Ω(err).ShouldNot(HaveOccurred())
+			os.MkdirAll(filepath.Join(root, "a/b"), 0777)
+			os.MkdirAll(filepath.Join(root, "c"), 0777)
+
+			provider, err = fs.Configure(root)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			os.RemoveAll(root)
+		})
+
+		It("should list regular files under the prefix, with their size and modification time", func() {
+			Ω(ioutil.WriteFile(filepath.Join(root, "a/b/one"), []byte("one\n"), 0666)).Should(Succeed())
+			Ω(ioutil.WriteFile(filepath.Join(root, "a/two"), []byte("two!\n"), 0666)).Should(Succeed())
+			Ω(ioutil.WriteFile(filepath.Join(root, "c/three"), []byte("3\n"), 0666)).Should(Succeed())
+
+			then := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+			Ω(os.Chtimes(filepath.Join(root, "a/two"), then, then)).Should(Succeed())
+
+			l, err := provider.List("a/")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(l).Should(HaveLen(2))
+			Ω(l[0].Path).Should(Equal("a/b/one"))
+			Ω(l[0].Size).Should(Equal(int64(4)))
+			Ω(l[1].Path).Should(Equal("a/two"))
+			Ω(l[1].Size).Should(Equal(int64(5)))
+			Ω(l[1].Modified.Equal(then)).Should(BeTrue())
+
+			l, err = provider.List("")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(l).Should(HaveLen(3))
+		})
+
+		It("should list nothing if no files match the prefix", func() {
+			l, err := provider.List("nowhere/")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(l).Should(BeEmpty())
+		})
+	})
+
+	Context("legal holds", func() {
+		var provider fs.Provider
+		var root string
+
+		BeforeEach(func() {
+			var err error
+			root, err = ioutil.TempDir("", "ssg-fs-test-")
+			Ω(err).ShouldNot(HaveOccurred())
+			os.MkdirAll(filepath.Join(root, "a"), 0777)
+			Ω(ioutil.WriteFile(filepath.Join(root, "a/file"), []byte("data\n"), 0666)).Should(Succeed())
+
+			provider, err = fs.Configure(root)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			os.RemoveAll(root)
+		})
+
+		It("should look up a file's size and modification time", func() {
+			blob, err := provider.Stat("a/file")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(blob).ShouldNot(BeNil())
+			Ω(blob.Path).Should(Equal("a/file"))
+			Ω(blob.Size).Should(Equal(int64(5)))
+
+			blob, err = provider.Stat("a/nothing")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(blob).Should(BeNil())
+		})
+
+		It("should place and release holds", func() {
+			held, err := provider.Held("a/file")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(held).Should(BeFalse())
+
+			Ω(provider.Hold("a/file", true)).Should(Succeed())
+			held, err = provider.Held("a/file")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(held).Should(BeTrue())
+
+			Ω(provider.Hold("a/file", false)).Should(Succeed())
+			held, err = provider.Held("a/file")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(held).Should(BeFalse())
+
+			Ω(provider.Hold("a/file", false)).Should(Succeed())
+		})
+
+		It("should not hold files that do not exist", func() {
+			Ω(provider.Hold("a/nothing", true)).ShouldNot(Succeed())
+		})
+
+		It("should keep holds out of listings, and out of reach", func() {
+			Ω(provider.Hold("a/file", true)).Should(Succeed())
+
+			l, err := provider.List("")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(l).Should(HaveLen(1))
+			Ω(l[0].Path).Should(Equal("a/file"))
+
+			Ω(provider.Expunge(".holds/a/file")).ShouldNot(Succeed())
+			_, err = provider.Download(".holds/a/file")
+			Ω(err).Should(HaveOccurred())
+			_, err = provider.Upload(".holds/a/other")
+			Ω(err).Should(HaveOccurred())
+
+			held, err := provider.Held("a/file")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(held).Should(BeTrue())
+		})
+	})
 })
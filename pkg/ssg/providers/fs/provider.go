@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/jhunt/ssg/pkg/rand"
 	"github.com/jhunt/ssg/pkg/ssg/provider"
@@ -12,6 +13,12 @@ import (
 
 const RandomFile = ""
 
+// HoldDir is where (under the root) legal holds are kept,
+// as empty marker files mirroring the paths they hold.
+// Nothing under it can be uploaded, downloaded, listed or
+// expunged as a blob.
+const HoldDir = ".holds"
+
 type Provider struct {
 	Root string
 }
@@ -41,6 +48,9 @@ func (f Provider) Upload(relpath string) (provider.Uploader, error) {
 		}
 	}
 	relpath = filepath.Clean(relpath)
+	if reserved(relpath) {
+		return nil, fmt.Errorf("%s: reserved for legal holds", relpath)
+	}
 	abspath := filepath.Join(f.Root, relpath)
 
 	if err := os.MkdirAll(path.Dir(abspath), 0777); err != nil {
@@ -65,6 +75,9 @@ func (f Provider) Download(relpath string) (provider.Downloader, error) {
 	}
 
 	relpath = filepath.Clean(relpath)
+	if reserved(relpath) {
+		return nil, fmt.Errorf("%s: reserved for legal holds", relpath)
+	}
 	file, err := os.OpenFile(filepath.Join(f.Root, relpath), os.O_RDONLY, 0)
 	if err != nil {
 		return nil, err
@@ -80,6 +93,109 @@ func (f Provider) Download(relpath string) (provider.Downloader, error) {
 	return provider.MeteredDownload(file)
 }
 
+func (f Provider) List(prefix string) ([]provider.Blob, error) {
+	l := make([]provider.Blob, 0)
+	err := filepath.Walk(f.Root, func(abspath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() && abspath == filepath.Join(f.Root, HoldDir) {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		relpath, err := filepath.Rel(f.Root, abspath)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(relpath, prefix) {
+			l = append(l, provider.Blob{
+				Path:     relpath,
+				Size:     info.Size(),
+				Modified: info.ModTime(),
+			})
+		}
+		return nil
+	})
+	return l, err
+}
+
+func (f Provider) Stat(relpath string) (*provider.Blob, error) {
+	relpath = filepath.Clean(relpath)
+	if reserved(relpath) {
+		return nil, fmt.Errorf("%s: reserved for legal holds", relpath)
+	}
+
+	st, err := os.Stat(filepath.Join(f.Root, relpath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &provider.Blob{
+		Path:     relpath,
+		Size:     st.Size(),
+		Modified: st.ModTime(),
+	}, nil
+}
+
+func (f Provider) Hold(relpath string, on bool) error {
+	relpath = filepath.Clean(relpath)
+	if reserved(relpath) {
+		return fmt.Errorf("%s: reserved for legal holds", relpath)
+	}
+	marker := filepath.Join(f.Root, HoldDir, relpath)
+
+	if !on {
+		if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	if _, err := os.Stat(filepath.Join(f.Root, relpath)); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(marker), 0777); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(marker, os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func (f Provider) Held(relpath string) (bool, error) {
+	relpath = filepath.Clean(relpath)
+	if reserved(relpath) {
+		return false, fmt.Errorf("%s: reserved for legal holds", relpath)
+	}
+
+	_, err := os.Stat(filepath.Join(f.Root, HoldDir, relpath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 func (f Provider) Expunge(relpath string) error {
-	return os.Remove(filepath.Join(f.Root, filepath.Clean(relpath)))
+	relpath = filepath.Clean(relpath)
+	if reserved(relpath) {
+		return fmt.Errorf("%s: reserved for legal holds", relpath)
+	}
+	return os.Remove(filepath.Join(f.Root, relpath))
+}
+
+func reserved(relpath string) bool {
+	return relpath == HoldDir || strings.HasPrefix(relpath, HoldDir+string(filepath.Separator))
 }
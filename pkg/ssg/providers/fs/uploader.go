@@ -37,5 +37,6 @@ func (out *Uploader) Path() string {
 }
 
 func (out *Uploader) Cancel() error {
+	out.file.Close()
 	return os.Remove(out.abspath)
 }
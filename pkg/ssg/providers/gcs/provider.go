@@ -3,9 +3,14 @@ package gcs
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/storage/v1"
 
 	"github.com/jhunt/ssg/pkg/rand"
@@ -15,15 +20,17 @@ import (
 const RandomKey = ""
 
 type Endpoint struct {
-	Key    interface{}
-	Bucket string
-	Prefix string
+	Key       interface{}
+	Bucket    string
+	Prefix    string
+	Downloads provider.Ranges
 }
 
 type Provider struct {
 	svc    *storage.Service
 	bucket string
 	prefix string
+	ranges provider.Ranges
 }
 
 func Configure(e Endpoint) (Provider, error) {
@@ -61,6 +68,7 @@ func Configure(e Endpoint) (Provider, error) {
 		svc:    svc,
 		bucket: e.Bucket,
 		prefix: e.Prefix,
+		ranges: e.Downloads,
 	}, nil
 }
 
@@ -77,7 +85,29 @@ func (p Provider) Upload(hint string) (provider.Uploader, error) {
 	}, nil
 }
 
+// UploadBuffer accounts for the chunk of each upload
+// that the storage API client buffers before sending.
+func (p Provider) UploadBuffer() int64 {
+	return googleapi.DefaultUploadChunkSize
+}
+
+func (p Provider) DownloadBuffer() int64 {
+	return p.ranges.Buffer()
+}
+
 func (p Provider) Download(path string) (provider.Downloader, error) {
+	if p.ranges.Workers > 1 {
+		obj, err := p.svc.Objects.Get(p.bucket, path).Do()
+		if err != nil {
+			return nil, err
+		}
+		if size := int64(obj.Size); p.ranges.Use(size) {
+			return provider.RangedDownload(size, p.ranges, func(offset, length int64) (io.ReadCloser, error) {
+				return p.get(path, obj.Generation, offset, length)
+			})
+		}
+	}
+
 	res, err := p.svc.Objects.Get(p.bucket, path).Download()
 	if err != nil {
 		return nil, err
@@ -86,6 +116,101 @@ func (p Provider) Download(path string) (provider.Downloader, error) {
 	return provider.MeteredDownload(res.Body)
 }
 
+// get fetches a range of one generation of an object, so
+// that an object overwritten mid-download is never spliced
+// together from two generations.
+func (p Provider) get(path string, generation, offset, length int64) (io.ReadCloser, error) {
+	call := p.svc.Objects.Get(p.bucket, path).Generation(generation)
+	call.Header().Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	res, err := call.Download()
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			return nil, fmt.Errorf("gcs object %s changed while it was being downloaded", path)
+		}
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusPartialContent {
+		res.Body.Close()
+		return nil, fmt.Errorf("gcs ignored the requested range of %s (%s)", path, res.Status)
+	}
+	return res.Body, nil
+}
+
+// List returns the blobs under prefix, which (like the
+// paths handed out by Upload) includes the provider's
+// own object prefix.
+func (p Provider) List(prefix string) ([]provider.Blob, error) {
+	switch {
+	case strings.HasPrefix(prefix, p.prefix):
+	case strings.HasPrefix(p.prefix, prefix):
+		prefix = p.prefix
+	default:
+		return []provider.Blob{}, nil
+	}
+
+	l := make([]provider.Blob, 0)
+	err := p.svc.Objects.List(p.bucket).Prefix(prefix).Pages(context.Background(), func(objs *storage.Objects) error {
+		for _, obj := range objs.Items {
+			modified, err := time.Parse(time.RFC3339, obj.Updated)
+			if err != nil {
+				return fmt.Errorf("gcs returned a malformed modification time for %s: %s", obj.Name, err)
+			}
+			l = append(l, provider.Blob{
+				Path:     obj.Name,
+				Size:     int64(obj.Size),
+				Modified: modified,
+			})
+		}
+		return nil
+	})
+	return l, err
+}
+
+// Stat returns the size and modification time of the
+// blob at path, or nil if there is no such blob.
+func (p Provider) Stat(path string) (*provider.Blob, error) {
+	obj, err := p.svc.Objects.Get(p.bucket, path).Do()
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	modified, err := time.Parse(time.RFC3339, obj.Updated)
+	if err != nil {
+		return nil, fmt.Errorf("gcs returned a malformed modification time for %s: %s", obj.Name, err)
+	}
+	return &provider.Blob{
+		Path:     obj.Name,
+		Size:     int64(obj.Size),
+		Modified: modified,
+	}, nil
+}
+
+// Hold places (or releases) a temporary hold on the blob
+// at path; GCS refuses to delete or overwrite held objects.
+func (p Provider) Hold(path string, on bool) error {
+	_, err := p.svc.Objects.Patch(p.bucket, path, &storage.Object{
+		TemporaryHold:   on,
+		ForceSendFields: []string{"TemporaryHold"},
+	}).Do()
+	return err
+}
+
+func (p Provider) Held(path string) (bool, error) {
+	obj, err := p.svc.Objects.Get(p.bucket, path).Do()
+	if err != nil {
+		// objects that don't exist (yet) can't be held
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return obj.TemporaryHold || obj.EventBasedHold, nil
+}
+
 func (p Provider) Expunge(path string) error {
 	return p.svc.Objects.Delete(p.bucket, path).Do()
 }
@@ -0,0 +1,423 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jhunt/ssg/pkg/ssg/provider"
+)
+
+// api speaks just enough of the S3 API for the provider.
+// It replaces github.com/jhunt/go-s3, which
+//
+//   - does not escape object keys, so keys with spaces,
+//     '?', '%' and the like name the wrong object (or fail
+//     to sign);
+//   - cannot send HEAD, ranged or conditional (If-Match)
+//     GETs, legal hold requests, or abort an upload;
+//   - races on its list of parts when uploading them in
+//     parallel, and ignores the status of each part; and
+//   - sets no timeouts on its requests.
+//
+// Every request that has a bounded response is given at
+// most timeout to finish; downloads of whole objects get
+// that long to start sending their data.
+type api struct {
+	client  *http.Client
+	timeout time.Duration
+
+	scheme  string
+	domain  string
+	bucket  string
+	usePath bool
+
+	region   string
+	aki      string
+	secret   string
+	metadata *instanceMetadata
+
+	// lock is the Object Lock mode (if any) to apply to
+	// new uploads, retaining them for at least retain.
+	lock   string
+	retain time.Duration
+}
+
+// canceling releases the context of a request once its
+// response body has been closed.
+type canceling struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c canceling) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+type s3error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func (e s3error) Error() string {
+	return fmt.Sprintf("s3 error %s: %s", e.Code, e.Message)
+}
+
+type statusError struct {
+	method string
+	key    string
+	status int
+	text   string
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("s3 %s %s failed: %s", e.method, e.key, e.text)
+}
+
+// changed reports whether err is S3 refusing a conditional
+// (If-Match) request, because the object has changed.
+func changed(err error) bool {
+	switch e := err.(type) {
+	case statusError:
+		return e.status == http.StatusPreconditionFailed
+	case s3error:
+		return e.Code == "PreconditionFailed"
+	}
+	return false
+}
+
+func notFound(err error) bool {
+	switch e := err.(type) {
+	case statusError:
+		return e.status == http.StatusNotFound
+	case s3error:
+		return e.Code == "NoSuchKey"
+	}
+	return false
+}
+
+// checksum sets the Content-MD5 header that S3 requires of
+// requests that touch Object Lock settings.
+func checksum(header http.Header, payload []byte) http.Header {
+	if header == nil {
+		header = http.Header{}
+	}
+	sum := md5.Sum(payload)
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	return header
+}
+
+// url builds the url of key, URI-encoding each of its path
+// segments exactly as Signature Version 4 will, so that
+// keys with spaces, '?', '#', '%' and the like name the
+// object they should, and the request path is the same as
+// the canonical URI that gets signed.
+func (m *api) url(key, query string) string {
+	if key == "" || key[0:1] != "/" {
+		key = "/" + key
+	}
+	key = uriencode(key, false)
+	if m.usePath {
+		return fmt.Sprintf("%s://%s/%s%s?%s", m.scheme, m.domain, m.bucket, key, query)
+	}
+	return fmt.Sprintf("%s://%s.%s%s?%s", m.scheme, m.bucket, m.domain, key, query)
+}
+
+func (m *api) credentials() (credentials, error) {
+	if m.metadata != nil {
+		return m.metadata.credentials()
+	}
+	return credentials{aki: m.aki, secret: m.secret}, nil
+}
+
+func (m *api) do(method, key, query string, header http.Header, payload []byte) ([]byte, *http.Response, error) {
+	res, err := m.open(method, key, query, header, payload, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, res, nil
+}
+
+// open sends a request, leaving the body of a successful
+// response for the caller to read (and close).  Bounded
+// requests must be read in full within m.timeout.
+func (m *api) open(method, key, query string, header http.Header, payload []byte, bounded bool) (*http.Response, error) {
+	creds, err := m.credentials()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if bounded && m.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, m.url(key, query), bytes.NewReader(payload))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.ContentLength = int64(len(payload))
+	sign(req, payload, m.region, creds, time.Now())
+
+	res, err := m.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	res.Body = canceling{ReadCloser: res.Body, cancel: cancel}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+
+		var e s3error
+		if xml.Unmarshal(b, &e) == nil && e.Code != "" {
+			return nil, e
+		}
+		return nil, statusError{method: method, key: key, status: res.StatusCode, text: res.Status}
+	}
+	return res, nil
+}
+
+func (m *api) initiate(key string) (string, error) {
+	var header http.Header
+	if m.lock != "" && m.retain > 0 {
+		header = http.Header{}
+		header.Set("x-amz-object-lock-mode", strings.ToUpper(m.lock))
+		header.Set("x-amz-object-lock-retain-until-date", time.Now().Add(m.retain).UTC().Format(time.RFC3339))
+	}
+	b, _, err := m.do("POST", key, "uploads=", header, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var payload struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(b, &payload); err != nil {
+		return "", err
+	}
+	if payload.UploadID == "" {
+		return "", fmt.Errorf("s3 did not return an upload id for %s", key)
+	}
+	return payload.UploadID, nil
+}
+
+func (m *api) part(key, id string, n int, b []byte) (string, error) {
+	var header http.Header
+	if m.lock != "" {
+		header = checksum(nil, b)
+	}
+	_, res, err := m.do("PUT", key, "partNumber="+strconv.Itoa(n)+"&uploadId="+uriencode(id, true), header, b)
+	if err != nil {
+		return "", err
+	}
+	etag := res.Header.Get("ETag")
+	if etag == "" {
+		return "", fmt.Errorf("s3 did not return an etag for part %d of %s", n, key)
+	}
+	return etag, nil
+}
+
+func (m *api) complete(key, id string, etags []string) error {
+	type part struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	var payload struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}
+	for i, etag := range etags {
+		payload.Parts = append(payload.Parts, part{PartNumber: i + 1, ETag: etag})
+	}
+
+	in, err := xml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	b, _, err := m.do("POST", key, "uploadId="+uriencode(id, true), nil, in)
+	if err != nil {
+		return err
+	}
+
+	// S3 can report a failed completion with a 200 OK
+	var e s3error
+	if xml.Unmarshal(b, &e) == nil && e.Code != "" {
+		return e
+	}
+	return nil
+}
+
+func (m *api) abort(key, id string) error {
+	_, _, err := m.do("DELETE", key, "uploadId="+uriencode(id, true), nil, nil)
+	return err
+}
+
+func (m *api) list(prefix string) ([]provider.Blob, error) {
+	l := make([]provider.Blob, 0)
+	query := "list-type=2&prefix=" + uriencode(prefix, true)
+	for {
+		b, _, err := m.do("GET", "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var payload struct {
+			Next     string `xml:"NextContinuationToken"`
+			Contents []struct {
+				Key          string `xml:"Key"`
+				LastModified string `xml:"LastModified"`
+				Size         int64  `xml:"Size"`
+			} `xml:"Contents"`
+		}
+		if err := xml.Unmarshal(b, &payload); err != nil {
+			return nil, err
+		}
+
+		for _, obj := range payload.Contents {
+			modified, err := time.Parse(time.RFC3339, obj.LastModified)
+			if err != nil {
+				return nil, fmt.Errorf("s3 returned a malformed modification time for %s: %s", obj.Key, err)
+			}
+			l = append(l, provider.Blob{
+				Path:     obj.Key,
+				Size:     obj.Size,
+				Modified: modified,
+			})
+		}
+
+		if payload.Next == "" {
+			return l, nil
+		}
+		query = "list-type=2&prefix=" + uriencode(prefix, true) + "&continuation-token=" + uriencode(payload.Next, true)
+	}
+}
+
+// size returns the size and ETag of the current version
+// of key, so that ranged gets can be pinned to it.
+func (m *api) size(key string) (int64, string, error) {
+	_, res, err := m.do("HEAD", key, "", nil, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	if res.ContentLength < 0 {
+		return 0, "", fmt.Errorf("s3 did not return a content length for %s", key)
+	}
+	return res.ContentLength, res.Header.Get("ETag"), nil
+}
+
+func (m *api) get(key, etag string, offset, length int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	if etag != "" {
+		header.Set("If-Match", etag)
+	}
+	res, err := m.open("GET", key, "", header, nil, true)
+	if changed(err) {
+		return nil, fmt.Errorf("s3 object %s changed while it was being downloaded", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusPartialContent {
+		res.Body.Close()
+		return nil, fmt.Errorf("s3 ignored the requested range of %s (%s)", key, res.Status)
+	}
+	return res.Body, nil
+}
+
+// fetch returns the whole of key, in a single request,
+// which can take as long as it needs to.
+func (m *api) fetch(key string) (io.ReadCloser, error) {
+	res, err := m.open("GET", key, "", nil, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (m *api) remove(key string) error {
+	_, _, err := m.do("DELETE", key, "", nil, nil)
+	return err
+}
+
+// stat returns the size and modification time of key, or
+// nil if there is no such object.
+func (m *api) stat(key string) (*provider.Blob, error) {
+	_, res, err := m.do("HEAD", key, "", nil, nil)
+	if err != nil {
+		if notFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	modified, err := http.ParseTime(res.Header.Get("Last-Modified"))
+	if err != nil {
+		return nil, fmt.Errorf("s3 returned a malformed modification time for %s: %s", key, err)
+	}
+	return &provider.Blob{
+		Path:     key,
+		Size:     res.ContentLength,
+		Modified: modified,
+	}, nil
+}
+
+type legalHold struct {
+	XMLName xml.Name `xml:"LegalHold"`
+	Status  string   `xml:"Status"`
+}
+
+func (m *api) hold(key string, on bool) error {
+	payload := legalHold{Status: "OFF"}
+	if on {
+		payload.Status = "ON"
+	}
+	in, err := xml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, _, err = m.do("PUT", key, "legal-hold=", checksum(nil, in), in)
+	return err
+}
+
+func (m *api) held(key string) (bool, error) {
+	b, _, err := m.do("GET", key, "legal-hold=", nil, nil)
+	if err != nil {
+		// objects that don't exist (yet) can't be held
+		if notFound(err) {
+			return false, nil
+		}
+		if e, ok := err.(s3error); ok && e.Code == "NoSuchObjectLockConfiguration" {
+			return false, nil
+		}
+		return false, err
+	}
+
+	var payload legalHold
+	if err := xml.Unmarshal(b, &payload); err != nil {
+		return false, err
+	}
+	return payload.Status == "ON", nil
+}
@@ -0,0 +1,39 @@
+package s3
+
+import (
+	"sync"
+)
+
+// part buffers are pooled by size, so that a steady
+// stream of uploads doesn't have to allocate (and then
+// garbage collect) a fresh multi-megabyte buffer each.
+var buffers = struct {
+	lock  sync.Mutex
+	pools map[int]*sync.Pool
+}{
+	pools: make(map[int]*sync.Pool),
+}
+
+func pool(size int) *sync.Pool {
+	buffers.lock.Lock()
+	defer buffers.lock.Unlock()
+
+	p, ok := buffers.pools[size]
+	if !ok {
+		p = &sync.Pool{
+			New: func() interface{} {
+				return make([]byte, size)
+			},
+		}
+		buffers.pools[size] = p
+	}
+	return p
+}
+
+func getBuffer(size int) []byte {
+	return pool(size).Get().([]byte)
+}
+
+func putBuffer(b []byte) {
+	pool(len(b)).Put(b)
+}
@@ -0,0 +1,130 @@
+package s3
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMetadataURL is where the EC2 instance metadata
+// API can be found, from inside of an EC2 instance.
+const DefaultMetadataURL = "http://169.254.169.254"
+
+// credentials sign requests to the S3 API.  Temporary
+// credentials, like those handed out by the instance
+// metadata API, come with a session token, and expire.
+type credentials struct {
+	aki     string
+	secret  string
+	token   string
+	expires time.Time
+}
+
+// instanceMetadata fetches temporary credentials for the
+// IAM role of the EC2 instance we are running on, and
+// holds on to them until they are about to expire.
+type instanceMetadata struct {
+	url    string
+	client *http.Client
+
+	lock  sync.Mutex
+	creds credentials
+}
+
+func (im *instanceMetadata) credentials() (credentials, error) {
+	im.lock.Lock()
+	defer im.lock.Unlock()
+
+	// refresh a few minutes early, so that requests
+	// signed just before expiry still get through.
+	if im.creds.aki != "" && time.Now().Add(5*time.Minute).Before(im.creds.expires) {
+		return im.creds, nil
+	}
+
+	session := im.session()
+	roles, err := im.get("/latest/meta-data/iam/security-credentials/", session)
+	if err != nil {
+		return credentials{}, fmt.Errorf("unable to look up the iam role of this instance: %s", err)
+	}
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if role == "" {
+		return credentials{}, fmt.Errorf("no iam role is attached to this instance")
+	}
+
+	b, err := im.get("/latest/meta-data/iam/security-credentials/"+role, session)
+	if err != nil {
+		return credentials{}, fmt.Errorf("unable to retrieve credentials for iam role '%s': %s", role, err)
+	}
+	var payload struct {
+		Code            string    `json:"Code"`
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal(b, &payload); err != nil {
+		return credentials{}, fmt.Errorf("malformed credentials for iam role '%s': %s", role, err)
+	}
+	if payload.Code != "Success" || payload.AccessKeyID == "" {
+		return credentials{}, fmt.Errorf("unable to retrieve credentials for iam role '%s' (%s)", role, payload.Code)
+	}
+
+	im.creds = credentials{
+		aki:     payload.AccessKeyID,
+		secret:  payload.SecretAccessKey,
+		token:   payload.Token,
+		expires: payload.Expiration,
+	}
+	return im.creds, nil
+}
+
+// session asks for an IMDSv2 session token, returning the
+// empty string if the metadata API only speaks IMDSv1.
+func (im *instanceMetadata) session() string {
+	req, err := http.NewRequest("PUT", im.url+"/latest/api/token", nil)
+	if err != nil {
+		return ""
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+
+	res, err := im.client.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil || res.StatusCode != 200 {
+		return ""
+	}
+	return string(b)
+}
+
+func (im *instanceMetadata) get(path, session string) ([]byte, error) {
+	req, err := http.NewRequest("GET", im.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if session != "" {
+		req.Header.Set("X-aws-ec2-metadata-token", session)
+	}
+
+	res, err := im.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("%s", res.Status)
+	}
+	return b, nil
+}
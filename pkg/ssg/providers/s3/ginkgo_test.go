@@ -1,17 +1,32 @@
 package s3_test
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
 	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"testing"
 
+	"github.com/jhunt/ssg/pkg/ssg/provider"
 	"github.com/jhunt/ssg/pkg/ssg/providers/s3"
 )
 
@@ -20,6 +35,366 @@ func TestSuite(t *testing.T) {
 	RunSpecs(t, "S3 Provider Test Suite")
 }
 
+func hmac256(key []byte, msg string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(msg))
+	return h.Sum(nil)
+}
+
+// canonicalPath matches request paths that are already
+// URI-encoded the way Signature Version 4 wants them.
+var canonicalPath = regexp.MustCompile(`^[A-Za-z0-9._~/%-]*$`)
+
+// verify an AWS Signature Version 4 request, for a path-style
+// bucket.  Like S3, it signs the path exactly as it was sent,
+// so object keys must be escaped in the request.
+func verify(r *http.Request, body []byte, aki, secret, token string) error {
+	if !canonicalPath.MatchString(r.URL.EscapedPath()) {
+		return fmt.Errorf("request path '%s' is not uri-encoded", r.URL.EscapedPath())
+	}
+
+	auth := r.Header.Get("Authorization")
+	var credential, signed, signature string
+	for _, kv := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ",") {
+		l := strings.SplitN(kv, "=", 2)
+		if len(l) != 2 {
+			return fmt.Errorf("malformed authorization header '%s'", auth)
+		}
+		switch l[0] {
+		case "Credential":
+			credential = l[1]
+		case "SignedHeaders":
+			signed = l[1]
+		case "Signature":
+			signature = l[1]
+		}
+	}
+	scope := strings.SplitN(credential, "/", 2)
+	if len(scope) != 2 || scope[0] != aki {
+		return fmt.Errorf("wrong credential '%s'", credential)
+	}
+
+	if r.Header.Get("x-amz-security-token") != token {
+		return fmt.Errorf("wrong security token '%s'", r.Header.Get("x-amz-security-token"))
+	}
+	if token != "" && !strings.Contains(";"+signed+";", ";x-amz-security-token;") {
+		return fmt.Errorf("security token is not signed")
+	}
+
+	sum := sha256.Sum256(body)
+	if r.Header.Get("x-amz-content-sha256") != hex.EncodeToString(sum[:]) {
+		return fmt.Errorf("payload hash mismatch")
+	}
+
+	headers := make([]string, 0)
+	for _, name := range strings.Split(signed, ";") {
+		v := r.Header.Get(name)
+		if name == "host" {
+			v = r.Host
+		}
+		headers = append(headers, name+":"+v)
+	}
+
+	query := make([]string, 0)
+	if r.URL.RawQuery != "" {
+		query = strings.Split(r.URL.RawQuery, "&")
+		for i := range query {
+			if !strings.Contains(query[i], "=") {
+				query[i] += "="
+			}
+		}
+		sort.Strings(query)
+	}
+
+	canon := sha256.Sum256([]byte(strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.Join(query, "&"),
+		strings.Join(headers, "\n") + "\n",
+		signed,
+		hex.EncodeToString(sum[:]),
+	}, "\n")))
+
+	parts := strings.Split(scope[1], "/")
+	key := hmac256([]byte("AWS4"+secret), parts[0])
+	key = hmac256(key, parts[1])
+	key = hmac256(key, parts[2])
+	key = hmac256(key, parts[3])
+	want := hex.EncodeToString(hmac256(key, "AWS4-HMAC-SHA256\n"+r.Header.Get("x-amz-date")+"\n"+scope[1]+"\n"+hex.EncodeToString(canon[:])))
+	if signature != want {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// fakeS3 is just enough of an S3 multipart upload API to
+// exercise the provider, without talking to Amazon.
+type fakeS3 struct {
+	lock sync.Mutex
+
+	objects  map[string][]byte
+	parts    map[int][]byte
+	inflight int
+	peak     int
+	aborted  bool
+	failPart int
+
+	ranges    int
+	failRange int64
+
+	holds  map[string]bool
+	mode   string
+	until  string
+	hashed int
+
+	// credentials to expect, if not AKI / sekrit
+	aki    string
+	secret string
+	token  string
+
+	failComplete bool
+	stall        chan struct{}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.stall != nil {
+		<-f.stall
+	}
+
+	aki, secret := f.aki, f.secret
+	if aki == "" {
+		aki, secret = "AKI", "sekrit"
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := verify(r, body, aki, secret, f.token); err != nil {
+		w.WriteHeader(403)
+		fmt.Fprintf(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>%s</Message></Error>", err)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	q := r.URL.Query()
+	switch {
+	case r.Method == "POST" && q.Get("uploads") == "" && strings.Contains(r.URL.RawQuery, "uploads"):
+		f.lock.Lock()
+		f.parts = make(map[int][]byte)
+		f.mode = r.Header.Get("x-amz-object-lock-mode")
+		f.until = r.Header.Get("x-amz-object-lock-retain-until-date")
+		f.lock.Unlock()
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Key>%s</Key><UploadId>up+1/x</UploadId></InitiateMultipartUploadResult>", escape(key))
+
+	case r.Method == "PUT" && q.Get("partNumber") != "":
+		if q.Get("uploadId") != "up+1/x" {
+			w.WriteHeader(404)
+			return
+		}
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+
+		f.lock.Lock()
+		f.inflight++
+		if f.inflight > f.peak {
+			f.peak = f.inflight
+		}
+		fail := n == f.failPart
+		f.lock.Unlock()
+
+		// make the earlier parts finish last
+		time.Sleep(time.Duration(100/n) * time.Millisecond)
+
+		f.lock.Lock()
+		f.inflight--
+		f.parts[n] = body
+		if r.Header.Get("Content-MD5") != "" {
+			f.hashed++
+		}
+		f.lock.Unlock()
+
+		if fail {
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "<Error><Code>InternalError</Code><Message>part %d failed</Message></Error>", n)
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, n))
+
+	case r.Method == "POST" && q.Get("uploadId") != "":
+		var payload struct {
+			Parts []struct {
+				PartNumber int    `xml:"PartNumber"`
+				ETag       string `xml:"ETag"`
+			} `xml:"Part"`
+		}
+		xml.Unmarshal(body, &payload)
+
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		if f.failComplete {
+			fmt.Fprintf(w, "<Error><Code>InternalError</Code><Message>try again</Message></Error>")
+			return
+		}
+		var b bytes.Buffer
+		for i, p := range payload.Parts {
+			if p.PartNumber != i+1 || p.ETag != fmt.Sprintf(`"etag-%d"`, i+1) {
+				fmt.Fprintf(w, "<Error><Code>InvalidPartOrder</Code><Message>part #%d is %d</Message></Error>", i+1, p.PartNumber)
+				return
+			}
+			b.Write(f.parts[p.PartNumber])
+		}
+		f.objects[key] = b.Bytes()
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key></CompleteMultipartUploadResult>", escape(key))
+
+	case r.Method == "DELETE" && q.Get("uploadId") != "":
+		f.lock.Lock()
+		f.aborted = true
+		f.lock.Unlock()
+		w.WriteHeader(204)
+
+	case r.Method == "DELETE":
+		f.lock.Lock()
+		delete(f.objects, key)
+		f.lock.Unlock()
+		w.WriteHeader(204)
+
+	case r.Method == "GET" && q.Get("list-type") == "2":
+		f.lock.Lock()
+		keys := make([]string, 0)
+		for k := range f.objects {
+			if strings.HasPrefix(k, q.Get("prefix")) && k > q.Get("continuation-token") {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		// two keys to a page, to exercise continuation
+		next := ""
+		if len(keys) > 2 {
+			keys = keys[:2]
+			next = keys[1]
+		}
+		fmt.Fprintf(w, "<ListBucketResult>")
+		for _, k := range keys {
+			fmt.Fprintf(w, "<Contents><Key>%s</Key><LastModified>2020-06-01T12:34:56.000Z</LastModified><Size>%d</Size></Contents>", escape(k), len(f.objects[k]))
+		}
+		if next != "" {
+			fmt.Fprintf(w, "<IsTruncated>true</IsTruncated><NextContinuationToken>%s</NextContinuationToken>", next)
+		}
+		fmt.Fprintf(w, "</ListBucketResult>")
+		f.lock.Unlock()
+
+	case r.Method == "PUT" && strings.Contains(r.URL.RawQuery, "legal-hold"):
+		sum := md5.Sum(body)
+		if r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(sum[:]) {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "<Error><Code>InvalidRequest</Code><Message>Content-MD5 is required</Message></Error>")
+			return
+		}
+		var payload struct {
+			Status string `xml:"Status"`
+		}
+		xml.Unmarshal(body, &payload)
+
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		if _, ok := f.objects[key]; !ok {
+			w.WriteHeader(404)
+			fmt.Fprintf(w, "<Error><Code>NoSuchKey</Code><Message>no such key</Message></Error>")
+			return
+		}
+		if f.holds == nil {
+			f.holds = make(map[string]bool)
+		}
+		f.holds[key] = payload.Status == "ON"
+
+	case r.Method == "GET" && strings.Contains(r.URL.RawQuery, "legal-hold"):
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		if _, ok := f.objects[key]; !ok {
+			w.WriteHeader(404)
+			fmt.Fprintf(w, "<Error><Code>NoSuchKey</Code><Message>no such key</Message></Error>")
+			return
+		}
+		on, ok := f.holds[key]
+		if !ok {
+			w.WriteHeader(404)
+			fmt.Fprintf(w, "<Error><Code>NoSuchObjectLockConfiguration</Code><Message>no legal hold</Message></Error>")
+			return
+		}
+		status := "OFF"
+		if on {
+			status = "ON"
+		}
+		fmt.Fprintf(w, "<LegalHold><Status>%s</Status></LegalHold>", status)
+
+	case r.Method == "HEAD":
+		f.lock.Lock()
+		b, ok := f.objects[key]
+		f.lock.Unlock()
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		w.Header().Set("Last-Modified", "Mon, 01 Jun 2020 12:34:56 GMT")
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(b)))
+
+	case r.Method == "GET":
+		f.lock.Lock()
+		b, ok := f.objects[key]
+		f.lock.Unlock()
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+
+		if etag := r.Header.Get("If-Match"); etag != "" && etag != fmt.Sprintf(`"%x"`, md5.Sum(b)) {
+			w.WriteHeader(412)
+			fmt.Fprintf(w, "<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>")
+			return
+		}
+
+		var start, end int64
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+			w.Write(b)
+			return
+		}
+
+		f.lock.Lock()
+		f.ranges++
+		f.inflight++
+		if f.inflight > f.peak {
+			f.peak = f.inflight
+		}
+		f.lock.Unlock()
+
+		// make the earlier ranges finish last
+		time.Sleep(time.Duration(50/(start/1000+1)) * time.Millisecond)
+
+		f.lock.Lock()
+		f.inflight--
+		f.lock.Unlock()
+
+		if f.failRange != 0 && start == f.failRange {
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "<Error><Code>InternalError</Code><Message>range at %d failed</Message></Error>", start)
+			return
+		}
+		if end >= int64(len(b)) {
+			end = int64(len(b)) - 1
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(b)))
+		w.WriteHeader(206)
+		w.Write(b[start : end+1])
+
+	default:
+		w.WriteHeader(400)
+	}
+}
+
 var _ = Describe("S3 Provider", func() {
 	Context("full stack", func() {
 		var provider s3.Provider
@@ -94,4 +469,558 @@ var _ = Describe("S3 Provider", func() {
 			Ω(hex.EncodeToString(ck.Sum(nil))).Should(Equal("872e2c6727b8e809cbe5baf15f05997753cd7818"))
 		})
 	})
+
+	Context("multipart uploads", func() {
+		var fake *fakeS3
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			fake = &fakeS3{objects: make(map[string][]byte)}
+			server = httptest.NewServer(fake)
+		})
+		AfterEach(func() {
+			server.Close()
+		})
+
+		configure := func(parallel int) s3.Provider {
+			p, err := s3.Configure(s3.Endpoint{
+				URL:             server.URL,
+				Region:          "us-east-1",
+				Bucket:          "bucket",
+				UsePath:         true,
+				PartSize:        1,
+				ParallelParts:   parallel,
+				AccessKeyID:     "AKI",
+				SecretAccessKey: "sekrit",
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			return p
+		}
+
+		data := func(n int) []byte {
+			b := make([]byte, n)
+			for i := range b {
+				b[i] = byte(i * 7 % 251)
+			}
+			return b
+		}
+
+		It("should send several parts at once, and assemble them in order", func() {
+			provider := configure(4)
+			Ω(provider.UploadBuffer()).Should(Equal(int64(4 * 1024 * 1024)))
+
+			uploader, err := provider.Upload("a/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			in := data(5*1024*1024 + 12345)
+			for b := in; len(b) > 0; {
+				n := 300000
+				if n > len(b) {
+					n = len(b)
+				}
+				_, err := uploader.Write(b[:n])
+				Ω(err).ShouldNot(HaveOccurred())
+				b = b[n:]
+			}
+			Ω(uploader.Close()).Should(Succeed())
+			Ω(uploader.WroteCompressed()).Should(Equal(int64(len(in))))
+
+			Ω(fake.peak).Should(BeNumerically(">", 1))
+			Ω(fake.peak).Should(BeNumerically("<=", 4))
+			Ω(fake.objects["a/blob"]).Should(Equal(in))
+
+			downloader, err := provider.Download("a/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+			out, err := ioutil.ReadAll(downloader)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(out).Should(Equal(in))
+		})
+
+		It("should send one part at a time by default", func() {
+			provider := configure(0)
+			uploader, err := provider.Upload("b/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			in := data(3*1024*1024 + 1)
+			_, err = uploader.Write(in)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(uploader.Close()).Should(Succeed())
+
+			Ω(fake.peak).Should(Equal(1))
+			Ω(fake.objects["b/blob"]).Should(Equal(in))
+		})
+
+		It("should escape object keys", func() {
+			provider := configure(1)
+			for _, key := range []string{"odd keys/a b+c=d&e?f#g%h!.txt", "odd keys/%2F..%2f", "odd keys/ünïcödé"} {
+				uploader, err := provider.Upload(key)
+				Ω(err).ShouldNot(HaveOccurred(), "%s", key)
+				_, err = uploader.Write([]byte(key))
+				Ω(err).ShouldNot(HaveOccurred(), "%s", key)
+				Ω(uploader.Close()).Should(Succeed(), "%s", key)
+				Ω(fake.objects).Should(HaveKeyWithValue(key, []byte(key)))
+
+				blob, err := provider.Stat(key)
+				Ω(err).ShouldNot(HaveOccurred(), "%s", key)
+				Ω(blob).ShouldNot(BeNil(), "%s", key)
+
+				downloader, err := provider.Download(key)
+				Ω(err).ShouldNot(HaveOccurred(), "%s", key)
+				out, err := ioutil.ReadAll(downloader)
+				Ω(err).ShouldNot(HaveOccurred(), "%s", key)
+				Ω(string(out)).Should(Equal(key))
+
+				Ω(provider.Expunge(key)).Should(Succeed(), "%s", key)
+				Ω(fake.objects).ShouldNot(HaveKey(key))
+			}
+		})
+
+		It("should upload empty blobs", func() {
+			provider := configure(2)
+			uploader, err := provider.Upload("c/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(uploader.Close()).Should(Succeed())
+			Ω(fake.objects).Should(HaveKeyWithValue("c/blob", []byte{}))
+		})
+
+		It("should abort the upload if any part fails", func() {
+			fake.failPart = 2
+			provider := configure(2)
+			uploader, err := provider.Upload("d/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			uploader.Write(data(4 * 1024 * 1024))
+			Ω(uploader.Close()).ShouldNot(Succeed())
+			Ω(fake.aborted).Should(BeTrue())
+			Ω(fake.objects).ShouldNot(HaveKey("d/blob"))
+		})
+
+		It("should abort the upload when canceled", func() {
+			provider := configure(2)
+			uploader, err := provider.Upload("e/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, err = uploader.Write(data(1024*1024 + 1))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(uploader.Cancel()).Should(Succeed())
+			Ω(fake.aborted).Should(BeTrue())
+			Ω(uploader.Close()).ShouldNot(Succeed())
+		})
+
+		It("should abort the upload if it cannot be completed", func() {
+			fake.failComplete = true
+			provider := configure(2)
+			uploader, err := provider.Upload("f/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, err = uploader.Write(data(2*1024*1024 + 1))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(uploader.Close()).Should(MatchError(ContainSubstring("try again")))
+			Ω(fake.aborted).Should(BeTrue())
+			Ω(fake.objects).ShouldNot(HaveKey("f/blob"))
+		})
+	})
+
+	Context("instance metadata", func() {
+		var fake *fakeS3
+		var server, imds *httptest.Server
+		var fetched int
+
+		BeforeEach(func() {
+			fake = &fakeS3{
+				objects: map[string][]byte{"a/blob": []byte("hello")},
+				aki:     "ASIATEMP",
+				secret:  "temporary",
+				token:   "session/token+1",
+			}
+			server = httptest.NewServer(fake)
+
+			fetched = 0
+			imds = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "PUT" && r.URL.Path == "/latest/api/token" {
+					fmt.Fprintf(w, "imds-session")
+					return
+				}
+				if r.Method != "GET" || r.Header.Get("X-aws-ec2-metadata-token") != "imds-session" {
+					w.WriteHeader(401)
+					return
+				}
+				switch r.URL.Path {
+				case "/latest/meta-data/iam/security-credentials/":
+					fmt.Fprintf(w, "ssg-role")
+				case "/latest/meta-data/iam/security-credentials/ssg-role":
+					fetched++
+					fmt.Fprintf(w, `{"Code":"Success","AccessKeyId":"%s","SecretAccessKey":"%s","Token":"%s","Expiration":"%s"}`,
+						fake.aki, fake.secret, fake.token, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+				default:
+					w.WriteHeader(404)
+				}
+			}))
+		})
+		AfterEach(func() {
+			server.Close()
+			imds.Close()
+		})
+
+		configure := func() s3.Provider {
+			p, err := s3.Configure(s3.Endpoint{
+				URL:              server.URL,
+				Region:           "us-east-1",
+				Bucket:           "bucket",
+				UsePath:          true,
+				InstanceMetadata: true,
+				MetadataURL:      imds.URL,
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			return p
+		}
+
+		It("should sign requests with the instance role's temporary credentials", func() {
+			provider := configure()
+			downloader, err := provider.Download("a/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+			out, err := ioutil.ReadAll(downloader)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(out)).Should(Equal("hello"))
+
+			uploader, err := provider.Upload("b/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = uploader.Write([]byte("world"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(uploader.Close()).Should(Succeed())
+			Ω(fake.objects).Should(HaveKeyWithValue("b/blob", []byte("world")))
+
+			Ω(fetched).Should(Equal(1))
+		})
+
+		It("should fail requests if the instance has no credentials to give", func() {
+			imds.Close()
+			provider := configure()
+			_, err := provider.Download("a/blob")
+			Ω(err).Should(MatchError(ContainSubstring("iam role")))
+		})
+	})
+
+	Context("timeouts", func() {
+		var fake *fakeS3
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			fake = &fakeS3{
+				objects: map[string][]byte{"a/blob": []byte("hello")},
+				stall:   make(chan struct{}),
+			}
+			server = httptest.NewServer(fake)
+		})
+		AfterEach(func() {
+			close(fake.stall)
+			server.Close()
+		})
+
+		It("should give up on requests that take too long", func() {
+			provider, err := s3.Configure(s3.Endpoint{
+				URL:             server.URL,
+				Region:          "us-east-1",
+				Bucket:          "bucket",
+				UsePath:         true,
+				AccessKeyID:     "AKI",
+				SecretAccessKey: "sekrit",
+				Timeout:         200 * time.Millisecond,
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			start := time.Now()
+			_, err = provider.Stat("a/blob")
+			Ω(err).Should(HaveOccurred())
+			_, err = provider.Upload("b/blob")
+			Ω(err).Should(HaveOccurred())
+			Ω(time.Since(start)).Should(BeNumerically("<", 2*time.Second))
+		})
+	})
+
+	Context("ranged downloads", func() {
+		var fake *fakeS3
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			fake = &fakeS3{objects: make(map[string][]byte)}
+			server = httptest.NewServer(fake)
+		})
+		AfterEach(func() {
+			server.Close()
+		})
+
+		configure := func(workers int) s3.Provider {
+			p, err := s3.Configure(s3.Endpoint{
+				URL:             server.URL,
+				Region:          "us-east-1",
+				Bucket:          "bucket",
+				UsePath:         true,
+				Downloads:       provider.Ranges{Workers: workers, ChunkSize: 1000},
+				AccessKeyID:     "AKI",
+				SecretAccessKey: "sekrit",
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			return p
+		}
+
+		data := func(n int) []byte {
+			b := make([]byte, n)
+			for i := range b {
+				b[i] = byte(i * 13 % 251)
+			}
+			return b
+		}
+
+		It("should fetch several ranges at once, and reassemble them in order", func() {
+			fake.objects["a/blob"] = data(9*1000 + 123)
+
+			downloader, err := configure(4).Download("a/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+			out, err := ioutil.ReadAll(downloader)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(downloader.Close()).Should(Succeed())
+
+			Ω(out).Should(Equal(fake.objects["a/blob"]))
+			Ω(downloader.ReadCompressed()).Should(Equal(int64(len(out))))
+			Ω(fake.ranges).Should(Equal(10))
+			Ω(fake.peak).Should(BeNumerically(">", 1))
+			Ω(fake.peak).Should(BeNumerically("<=", 4))
+		})
+
+		It("should fetch blobs in one request by default", func() {
+			fake.objects["b/blob"] = data(5000)
+
+			downloader, err := configure(0).Download("b/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+			out, err := ioutil.ReadAll(downloader)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(out).Should(Equal(fake.objects["b/blob"]))
+			Ω(fake.ranges).Should(Equal(0))
+		})
+
+		It("should fetch blobs no larger than a chunk in one request", func() {
+			fake.objects["c/blob"] = data(1000)
+
+			downloader, err := configure(4).Download("c/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+			out, err := ioutil.ReadAll(downloader)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(out).Should(Equal(fake.objects["c/blob"]))
+			Ω(fake.ranges).Should(Equal(0))
+		})
+
+		It("should fail to download missing blobs", func() {
+			_, err := configure(4).Download("d/blob")
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail the download if any range fails", func() {
+			fake.objects["e/blob"] = data(6000)
+			fake.failRange = 3000
+
+			downloader, err := configure(2).Download("e/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+			out, err := ioutil.ReadAll(downloader)
+			Ω(err).Should(HaveOccurred())
+			Ω(out).Should(Equal(fake.objects["e/blob"][:3000]))
+		})
+
+		It("should fail the download if the object changes mid-download", func() {
+			fake.objects["g/blob"] = data(20 * 1000)
+
+			downloader, err := configure(2).Download("g/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+			b := make([]byte, 10)
+			_, err = io.ReadFull(downloader, b)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			fake.lock.Lock()
+			fake.objects["g/blob"] = bytes.Repeat([]byte("x"), 20*1000)
+			fake.lock.Unlock()
+
+			_, err = ioutil.ReadAll(downloader)
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(ContainSubstring("changed while it was being downloaded"))
+		})
+
+		It("should stop fetching ranges when closed early", func() {
+			fake.objects["f/blob"] = data(50 * 1000)
+
+			downloader, err := configure(2).Download("f/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+			b := make([]byte, 10)
+			_, err = io.ReadFull(downloader, b)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(downloader.Close()).Should(Succeed())
+
+			time.Sleep(200 * time.Millisecond)
+			fake.lock.Lock()
+			defer fake.lock.Unlock()
+			Ω(fake.ranges).Should(BeNumerically("<", 10))
+		})
+	})
+
+	Context("listing", func() {
+		var fake *fakeS3
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			fake = &fakeS3{objects: map[string][]byte{
+				"backups/a/1":  []byte("one"),
+				"backups/a/2":  []byte("two!"),
+				"backups/a/3":  []byte("three"),
+				"backups/b/1":  []byte("b"),
+				"elsewhere/1":  []byte("nope"),
+				"backups-nope": []byte("nope"),
+			}}
+			server = httptest.NewServer(fake)
+		})
+		AfterEach(func() {
+			server.Close()
+		})
+
+		configure := func(prefix string) s3.Provider {
+			p, err := s3.Configure(s3.Endpoint{
+				URL:             server.URL,
+				Region:          "us-east-1",
+				Bucket:          "bucket",
+				Prefix:          prefix,
+				UsePath:         true,
+				AccessKeyID:     "AKI",
+				SecretAccessKey: "sekrit",
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			return p
+		}
+
+		It("should list every page of objects under the prefix", func() {
+			l, err := configure("backups/").List("backups/a/")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(l).Should(HaveLen(3))
+			Ω(l[0].Path).Should(Equal("backups/a/1"))
+			Ω(l[2].Path).Should(Equal("backups/a/3"))
+			Ω(l[2].Size).Should(Equal(int64(5)))
+			Ω(l[2].Modified).Should(Equal(time.Date(2020, 6, 1, 12, 34, 56, 0, time.UTC)))
+		})
+
+		It("should confine listings to the provider prefix", func() {
+			l, err := configure("backups/").List("")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(l).Should(HaveLen(4))
+
+			l, err = configure("backups/").List("elsewhere/")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(l).Should(BeEmpty())
+		})
+	})
+
+	Context("object lock", func() {
+		var fake *fakeS3
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			fake = &fakeS3{objects: map[string][]byte{
+				"backups/a": []byte("aaaa"),
+			}}
+			server = httptest.NewServer(fake)
+		})
+		AfterEach(func() {
+			server.Close()
+		})
+
+		configure := func(lock string, retention time.Duration) s3.Provider {
+			p, err := s3.Configure(s3.Endpoint{
+				URL:             server.URL,
+				Region:          "us-east-1",
+				Bucket:          "bucket",
+				UsePath:         true,
+				ObjectLock:      lock,
+				Retention:       retention,
+				AccessKeyID:     "AKI",
+				SecretAccessKey: "sekrit",
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			return p
+		}
+
+		It("should look up an object's size and modification time", func() {
+			blob, err := configure("", 0).Stat("backups/a")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(blob).ShouldNot(BeNil())
+			Ω(blob.Size).Should(Equal(int64(4)))
+			Ω(blob.Modified).Should(Equal(time.Date(2020, 6, 1, 12, 34, 56, 0, time.UTC)))
+
+			blob, err = configure("", 0).Stat("backups/nothing")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(blob).Should(BeNil())
+		})
+
+		It("should retain new uploads until the retention period is up", func() {
+			up, err := configure("compliance", 30*24*time.Hour).Upload("backups/b")
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = up.Write([]byte("bbbb"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(up.Close()).Should(Succeed())
+
+			Ω(fake.mode).Should(Equal("COMPLIANCE"))
+			until, err := time.Parse(time.RFC3339, fake.until)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(until).Should(BeTemporally("~", time.Now().Add(30*24*time.Hour), time.Minute))
+			Ω(fake.hashed).Should(Equal(1))
+		})
+
+		It("should not set a retention without object lock", func() {
+			up, err := configure("", 0).Upload("backups/b")
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = up.Write([]byte("bbbb"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(up.Close()).Should(Succeed())
+
+			Ω(fake.mode).Should(Equal(""))
+			Ω(fake.until).Should(Equal(""))
+		})
+
+		It("should place and release legal holds", func() {
+			p := configure("governance", 0)
+			held, err := p.Held("backups/a")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(held).Should(BeFalse())
+
+			Ω(p.Hold("backups/a", true)).Should(Succeed())
+			held, err = p.Held("backups/a")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(held).Should(BeTrue())
+
+			Ω(p.Hold("backups/a", false)).Should(Succeed())
+			held, err = p.Held("backups/a")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(held).Should(BeFalse())
+
+			Ω(p.Hold("backups/nothing", true)).ShouldNot(Succeed())
+		})
+
+		It("should not consider objects that do not exist yet to be held", func() {
+			p := configure("governance", 0)
+			held, err := p.Held("backups/new")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(held).Should(BeFalse())
+
+			up, err := p.Upload("backups/new")
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = up.Write([]byte("new"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(up.Close()).Should(Succeed())
+			Ω(fake.objects["backups/new"]).Should(Equal([]byte("new")))
+		})
+
+		It("should refuse legal holds without object lock", func() {
+			p := configure("", 0)
+			Ω(p.Hold("backups/a", true)).ShouldNot(Succeed())
+
+			held, err := p.Held("backups/a")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(held).Should(BeFalse())
+		})
+	})
 })
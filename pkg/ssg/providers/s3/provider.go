@@ -2,18 +2,22 @@ package s3
 
 import (
 	"fmt"
-	"io/ioutil"
+	"io"
+	"net"
+	"net/http"
 	"net/url"
-
-	"github.com/jhunt/go-s3"
+	"strings"
+	"time"
 
 	"github.com/jhunt/ssg/pkg/rand"
 	"github.com/jhunt/ssg/pkg/ssg/provider"
 )
 
 const (
-	RandomKey       = ""
-	DefaultPartSize = 5
+	RandomKey            = ""
+	DefaultPartSize      = 5
+	DefaultParallelParts = 1
+	DefaultTimeout       = 5 * time.Minute
 )
 
 type Endpoint struct {
@@ -23,14 +27,31 @@ type Endpoint struct {
 	Prefix          string
 	UsePath         bool
 	PartSize        int
+	ParallelParts   int
+	Downloads       provider.Ranges
+	ObjectLock      string
+	Retention       time.Duration
 	AccessKeyID     string
 	SecretAccessKey string
+
+	// InstanceMetadata signs requests with the credentials
+	// of the EC2 instance's IAM role, which are looked up
+	// at MetadataURL (DefaultMetadataURL, if unset).
+	InstanceMetadata bool
+	MetadataURL      string
+
+	// Timeout bounds how long any one request can take,
+	// save for the download of a whole object, which only
+	// has to start within that time.
+	Timeout time.Duration
 }
 
 type Provider struct {
 	prefix   string
-	client   *s3.Client
+	api      *api
 	partsize int
+	parallel int
+	ranges   provider.Ranges
 }
 
 func Configure(e Endpoint) (Provider, error) {
@@ -48,27 +69,68 @@ func Configure(e Endpoint) (Provider, error) {
 		host = u.Host
 	}
 
-	client, err := s3.NewClient(&s3.Client{
-		Domain:          host,
-		Protocol:        scheme,
-		Bucket:          e.Bucket,
-		Region:          e.Region,
-		UsePathBuckets:  e.UsePath,
-		AccessKeyID:     e.AccessKeyID,
-		SecretAccessKey: e.SecretAccessKey,
-	})
-	if err != nil {
-		return Provider{}, err
-	}
-
 	if e.PartSize == 0 {
 		e.PartSize = DefaultPartSize
 	}
+	if e.ParallelParts == 0 {
+		e.ParallelParts = DefaultParallelParts
+	}
+
+	if e.Timeout == 0 {
+		e.Timeout = DefaultTimeout
+	}
+	if e.MetadataURL == "" {
+		e.MetadataURL = DefaultMetadataURL
+	}
+
+	if scheme == "" {
+		scheme = "https"
+	}
+	if host == "" {
+		host = "s3.amazonaws.com"
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: e.Timeout,
+			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConnsPerHost:   e.ParallelParts + e.Downloads.Workers,
+		},
+	}
+
+	var metadata *instanceMetadata
+	if e.InstanceMetadata {
+		metadata = &instanceMetadata{
+			url:    strings.TrimSuffix(e.MetadataURL, "/"),
+			client: &http.Client{Timeout: 10 * time.Second},
+		}
+	}
 
 	return Provider{
-		prefix:   e.Prefix,
-		client:   client,
+		prefix: e.Prefix,
+		api: &api{
+			client:   client,
+			timeout:  e.Timeout,
+			metadata: metadata,
+			scheme:   scheme,
+			domain:   host,
+			bucket:   e.Bucket,
+			usePath:  e.UsePath,
+			region:   e.Region,
+			aki:      e.AccessKeyID,
+			secret:   e.SecretAccessKey,
+			lock:     e.ObjectLock,
+			retain:   e.Retention,
+		},
 		partsize: e.PartSize,
+		parallel: e.ParallelParts,
+		ranges:   e.Downloads,
 	}, nil
 }
 
@@ -79,26 +141,93 @@ func (p Provider) Upload(hint string) (provider.Uploader, error) {
 	}
 	key = p.prefix + key
 
-	up, err := p.client.NewUpload(key, nil)
+	id, err := p.api.initiate(key)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Uploader{
-		up:  up,
-		key: key,
-		buf: make([]byte, p.partsize*1024*1024),
+		api:   p.api,
+		key:   key,
+		id:    id,
+		size:  p.partsize * 1024 * 1024,
+		slots: make(chan struct{}, p.parallel),
 	}, nil
 }
 
+// UploadBuffer accounts for every part of an upload
+// that can be in flight (or being filled) at once.
+func (p Provider) UploadBuffer() int64 {
+	return int64(p.parallel) * int64(p.partsize) * 1024 * 1024
+}
+
+func (p Provider) DownloadBuffer() int64 {
+	return p.ranges.Buffer()
+}
+
 func (p Provider) Download(path string) (provider.Downloader, error) {
-	get, err := p.client.Get(path)
+	if p.ranges.Workers > 1 {
+		size, etag, err := p.api.size(path)
+		if err != nil {
+			return nil, err
+		}
+		if p.ranges.Use(size) {
+			return provider.RangedDownload(size, p.ranges, func(offset, length int64) (io.ReadCloser, error) {
+				return p.api.get(path, etag, offset, length)
+			})
+		}
+	}
+
+	get, err := p.api.fetch(path)
 	if err != nil {
 		return nil, err
 	}
-	return provider.MeteredDownload(ioutil.NopCloser(get))
+	return provider.MeteredDownload(get)
 }
 
+// List returns the blobs under prefix, which (like the
+// paths handed out by Upload) includes the provider's
+// own key prefix.
+func (p Provider) List(prefix string) ([]provider.Blob, error) {
+	switch {
+	case strings.HasPrefix(prefix, p.prefix):
+	case strings.HasPrefix(p.prefix, prefix):
+		prefix = p.prefix
+	default:
+		return []provider.Blob{}, nil
+	}
+	return p.api.list(prefix)
+}
+
+// Expunge deletes the blob at path.
+//
+// On a versioned (or Object Lock) S3 bucket, this only
+// adds a delete marker; earlier versions of the object
+// are kept until the bucket's own lifecycle rules (or
+// an operator) remove them.
 func (p Provider) Expunge(path string) error {
-	return p.client.Delete(path)
+	return p.api.remove(path)
+}
+
+// Stat returns the size and modification time of the
+// blob at path, or nil if there is no such blob.
+func (p Provider) Stat(path string) (*provider.Blob, error) {
+	return p.api.stat(path)
+}
+
+// Hold places (or releases) an Object Lock legal hold on
+// the blob at path, which requires the S3 bucket to have
+// Object Lock enabled.
+func (p Provider) Hold(path string, on bool) error {
+	if p.api.lock == "" {
+		return fmt.Errorf("legal holds require object lock, which is not configured")
+	}
+	return p.api.hold(path, on)
+}
+
+func (p Provider) Held(path string) (bool, error) {
+	if p.api.lock == "" {
+		return false, nil
+	}
+	return p.api.held(path)
 }
@@ -0,0 +1,111 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sign an S3 request with AWS Signature Version 4.
+func sign(req *http.Request, payload []byte, region string, creds credentials, now time.Time) {
+	now = now.UTC()
+	yyyymmdd := now.Format("20060102")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", yyyymmdd, region)
+
+	sum := sha256.Sum256(payload)
+	hashed := hex.EncodeToString(sum[:])
+
+	req.Header.Set("x-amz-date", now.Format("20060102T150405Z"))
+	req.Header.Set("x-amz-content-sha256", hashed)
+	req.Header.Set("host", req.URL.Host)
+	if creds.token != "" {
+		req.Header.Set("x-amz-security-token", creds.token)
+	}
+
+	names := make([]string, 0)
+	for header := range req.Header {
+		lc := strings.ToLower(header)
+		if lc == "host" || strings.HasPrefix(lc, "x-amz-") {
+			names = append(names, lc)
+		}
+	}
+	sort.Strings(names)
+
+	headers := make([]string, len(names))
+	for i, name := range names {
+		headers[i] = name + ":" + strings.Trim(req.Header.Get(name), " \t\r\n\f")
+	}
+	signed := strings.Join(names, ";")
+
+	canon := sha256.Sum256([]byte(strings.Join([]string{
+		req.Method,
+		uriencode(req.URL.Path, false), // as api.url() sent it
+		canonicalQuery(req.URL.RawQuery),
+		strings.Join(headers, "\n") + "\n",
+		signed,
+		hashed,
+	}, "\n")))
+
+	cleartext := "AWS4-HMAC-SHA256" +
+		"\n" + now.Format("20060102T150405Z") +
+		"\n" + scope +
+		"\n" + hex.EncodeToString(canon[:])
+
+	key := mac256([]byte("AWS4"+creds.secret), []byte(yyyymmdd))
+	key = mac256(key, []byte(region))
+	key = mac256(key, []byte("s3"))
+	key = mac256(key, []byte("aws4_request"))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256"+
+		" Credential="+creds.aki+"/"+scope+
+		",SignedHeaders="+signed+
+		",Signature="+hex.EncodeToString(mac256(key, []byte(cleartext))))
+}
+
+func mac256(key, msg []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+func canonicalQuery(raw string) string {
+	if raw == "" {
+		return ""
+	}
+
+	qq := strings.Split(raw, "&")
+	sort.Strings(qq)
+	for i := range qq {
+		kv := strings.SplitN(qq[i], "=", 2)
+		k, _ := url.QueryUnescape(kv[0])
+		v := ""
+		if len(kv) == 2 {
+			v, _ = url.QueryUnescape(kv[1])
+		}
+		qq[i] = uriencode(k, true) + "=" + uriencode(v, true)
+	}
+	return strings.Join(qq, "&")
+}
+
+func uriencode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			b.WriteByte(c)
+		case c == '-' || c == '.' || c == '_' || c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
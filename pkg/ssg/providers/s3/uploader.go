@@ -1,66 +1,162 @@
 package s3
 
 import (
-	"github.com/jhunt/go-s3"
+	"fmt"
+	"sync"
 )
 
 type Uploader struct {
+	api *api
 	key string
-	up  *s3.Upload
+	id  string
 	n   int64
 
-	bufn int
-	buf  []byte
-}
+	size  int
+	parts int
+	bufn  int
+	buf   []byte
 
-func (out *Uploader) Write(b []byte) (int, error) {
-	// calculate the amount of space left in our send buffer.
-	left := len(out.buf) - out.bufn
+	// each in-flight part (and the part being filled)
+	// holds one slot; Write blocks when none are free.
+	slots chan struct{}
+	wg    sync.WaitGroup
 
-	nwrit := 0
-	for len(b) >= left {
-		// fill up our send buffer, so that we get a complete
-		// multi-part of the correct segment size.
-		copy(out.buf[out.bufn:], b[:left])
+	lock   sync.Mutex
+	etags  []string
+	err    error
+	closed bool
+}
 
-		// write our full multi-part to the backend s3 store.
-		if err := out.up.Write(out.buf); err != nil {
-			return nwrit, err
-		}
+func (out *Uploader) failed() error {
+	out.lock.Lock()
+	defer out.lock.Unlock()
+	return out.err
+}
 
-		// track the new data we wrote directly.
-		nwrit += left
+func (out *Uploader) fail(err error) {
+	out.lock.Lock()
+	defer out.lock.Unlock()
+	if out.err == nil {
+		out.err = err
+	}
+}
 
-		// slide our input buffer back to account for the
-		// direct write.
-		b = b[left:]
+func (out *Uploader) acquire() error {
+	out.slots <- struct{}{}
+	if err := out.failed(); err != nil {
+		<-out.slots
+		return err
+	}
+	out.buf = getBuffer(out.size)
+	out.bufn = 0
+	return nil
+}
 
-		// our send buffer is now empty, ready to be re-filled.
-		left = len(out.buf)
-		out.bufn = 0
+// send the current buffer as the next part, in the
+// background.  Parts are numbered in the order they are
+// sent, no matter what order they finish in.
+func (out *Uploader) send() error {
+	out.lock.Lock()
+	if out.err != nil {
+		out.lock.Unlock()
+		return out.err
 	}
+	out.parts++
+	n, buf := out.parts, out.buf[:out.bufn]
+	out.etags = append(out.etags, "")
+	out.wg.Add(1)
+	out.lock.Unlock()
+
+	out.buf = nil
+	out.bufn = 0
+
+	go func() {
+		defer out.wg.Done()
+		etag, err := out.api.part(out.key, out.id, n, buf)
+		putBuffer(buf[:cap(buf)])
+		<-out.slots
+
+		if err != nil {
+			out.fail(fmt.Errorf("unable to upload part %d of %s: %s", n, out.key, err))
+			return
+		}
+		out.lock.Lock()
+		out.etags[n-1] = etag
+		out.lock.Unlock()
+	}()
+	return nil
+}
 
-	// place the leftover input data into our send buffer
-	// for a future call to Write() or Close().
-	copy(out.buf[out.bufn:], b)
-	out.bufn += len(b)
+func (out *Uploader) Write(b []byte) (int, error) {
+	nwrit := 0
+	for len(b) > 0 {
+		if out.buf == nil {
+			if err := out.acquire(); err != nil {
+				return nwrit, err
+			}
+		}
+
+		// fill up our send buffer, so that we get a complete
+		// multi-part of the correct segment size.
+		n := copy(out.buf[out.bufn:], b)
+		out.bufn += n
+		nwrit += n
+		b = b[n:]
+
+		if out.bufn == len(out.buf) {
+			if err := out.send(); err != nil {
+				return nwrit, err
+			}
+		}
+	}
 
-	// record the send-buffered remainder of the input buffer
-	// as having been written (return its byte counts) since
-	// the send buffer cache is "invisible" to callers.
-	nwrit += len(b)
 	out.n += int64(nwrit)
 	return nwrit, nil
 }
 
 func (out *Uploader) Close() error {
-	if out.bufn > 0 {
-		if err := out.up.Write(out.buf[:out.bufn]); err != nil {
-			return err
+	out.lock.Lock()
+	if out.closed {
+		out.lock.Unlock()
+		return fmt.Errorf("upload to %s has already been closed", out.key)
+	}
+	out.closed = true
+	out.lock.Unlock()
+
+	// S3 needs at least one (possibly empty) part
+	if out.buf == nil && out.parts == 0 {
+		if err := out.acquire(); err != nil {
+			return out.abort(err)
+		}
+	}
+	if out.buf != nil {
+		if out.bufn > 0 || out.parts == 0 {
+			if err := out.send(); err != nil {
+				return out.abort(err)
+			}
+		} else {
+			putBuffer(out.buf)
+			out.buf = nil
+			<-out.slots
 		}
-		out.n += int64(out.bufn)
 	}
-	return out.up.Done()
+
+	out.wg.Wait()
+	if err := out.failed(); err != nil {
+		return out.abort(err)
+	}
+	if err := out.api.complete(out.key, out.id, out.etags); err != nil {
+		return out.abort(err)
+	}
+	return nil
+}
+
+func (out *Uploader) abort(err error) error {
+	out.wg.Wait()
+	if aerr := out.api.abort(out.key, out.id); aerr != nil {
+		return fmt.Errorf("%s (and unable to abort the multipart upload: %s)", err, aerr)
+	}
+	return err
 }
 
 func (out *Uploader) WroteCompressed() int64 {
@@ -76,5 +172,17 @@ func (out *Uploader) Path() string {
 }
 
 func (out *Uploader) Cancel() error {
-	return nil
+	out.lock.Lock()
+	if out.closed {
+		out.lock.Unlock()
+		return nil
+	}
+	out.closed = true
+	if out.err == nil {
+		out.err = fmt.Errorf("upload to %s was canceled", out.key)
+	}
+	out.lock.Unlock()
+
+	out.wg.Wait()
+	return out.api.abort(out.key, out.id)
 }
@@ -1,17 +1,23 @@
 package webdav_test
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"sync"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"testing"
 
+	"github.com/jhunt/ssg/pkg/ssg/provider"
 	"github.com/jhunt/ssg/pkg/ssg/providers/webdav"
 )
 
@@ -89,4 +95,150 @@ var _ = Describe("WEBDAV Provider", func() {
 			Ω(hex.EncodeToString(ck.Sum(nil))).Should(Equal("872e2c6727b8e809cbe5baf15f05997753cd7818"))
 		})
 	})
+
+	Context("ranged downloads", func() {
+		var (
+			server  *httptest.Server
+			blob    []byte
+			version int
+			lock    sync.Mutex
+			ranges  int
+			ignored bool
+		)
+
+		BeforeEach(func() {
+			blob = make([]byte, 7*1000+1)
+			for i := range blob {
+				blob[i] = byte(i * 11 % 251)
+			}
+			version = 1
+			ranges = 0
+			ignored = false
+
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/a/blob" {
+					w.WriteHeader(404)
+					return
+				}
+				lock.Lock()
+				data, etag := blob, fmt.Sprintf(`"v%d"`, version)
+				if r.Header.Get("Range") != "" {
+					ranges++
+					if ignored {
+						r.Header.Del("Range")
+					}
+				}
+				lock.Unlock()
+
+				w.Header().Set("ETag", etag)
+				http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(data))
+			}))
+		})
+		AfterEach(func() {
+			server.Close()
+		})
+
+		configure := func(workers int) webdav.Provider {
+			p, err := webdav.Configure(webdav.Endpoint{
+				URL:       server.URL,
+				Downloads: provider.Ranges{Workers: workers, ChunkSize: 1000},
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			return p
+		}
+
+		It("should fetch large files as several ranges, in order", func() {
+			downloader, err := configure(3).Download("a/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+			b, err := ioutil.ReadAll(downloader)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(b).Should(Equal(blob))
+			Ω(ranges).Should(Equal(8))
+		})
+
+		It("should fetch files in one request by default", func() {
+			downloader, err := configure(0).Download("a/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+			b, err := ioutil.ReadAll(downloader)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(b).Should(Equal(blob))
+			Ω(ranges).Should(Equal(0))
+		})
+
+		It("should fail to download missing files", func() {
+			_, err := configure(3).Download("b/blob")
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if the file changes mid-download", func() {
+			downloader, err := configure(2).Download("a/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+			b := make([]byte, 10)
+			_, err = io.ReadFull(downloader, b)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			lock.Lock()
+			version++
+			blob = bytes.Repeat([]byte("x"), len(blob))
+			lock.Unlock()
+
+			_, err = ioutil.ReadAll(downloader)
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(ContainSubstring("changed"))
+		})
+
+		It("should fail reads, not end them, once closed partway", func() {
+			downloader, err := configure(2).Download("a/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+			b := make([]byte, 10)
+			_, err = io.ReadFull(downloader, b)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(downloader.Close()).Should(Succeed())
+			_, err = ioutil.ReadAll(downloader)
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(ContainSubstring("stopped"))
+		})
+
+		It("should fail if the server does not honor ranges", func() {
+			ignored = true
+			downloader, err := configure(3).Download("a/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = ioutil.ReadAll(downloader)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("looking up blobs", func() {
+		var server *httptest.Server
+		modified := time.Date(2020, 6, 1, 12, 34, 56, 0, time.UTC)
+
+		BeforeEach(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/a/blob" {
+					w.WriteHeader(404)
+					return
+				}
+				http.ServeContent(w, r, "blob", modified, bytes.NewReader([]byte("some data")))
+			}))
+		})
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should return the size and modification time of a blob", func() {
+			p, err := webdav.Configure(webdav.Endpoint{URL: server.URL})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			blob, err := p.Stat("a/blob")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(blob).ShouldNot(BeNil())
+			Ω(blob.Size).Should(Equal(int64(9)))
+			Ω(blob.Modified.Equal(modified)).Should(BeTrue())
+
+			blob, err = p.Stat("a/nothing")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(blob).Should(BeNil())
+		})
+	})
 })
@@ -18,11 +18,12 @@ import (
 const RandomFile = ""
 
 type Endpoint struct {
-	URL      string
-	Username string
-	Password string
-	CA       config.CA
-	Timeout  int
+	URL       string
+	Username  string
+	Password  string
+	CA        config.CA
+	Timeout   int
+	Downloads provider.Ranges
 }
 
 type Provider struct {
@@ -30,6 +31,7 @@ type Provider struct {
 	username string
 	password string
 	client   *http.Client
+	ranges   provider.Ranges
 }
 
 func Configure(e Endpoint) (Provider, error) {
@@ -53,6 +55,7 @@ func Configure(e Endpoint) (Provider, error) {
 				TLSClientConfig: tlsConfig,
 			},
 		},
+		ranges: e.Downloads,
 	}, nil
 }
 
@@ -97,7 +100,29 @@ func (p Provider) Upload(hint string) (provider.Uploader, error) {
 	}, nil
 }
 
+// UploadBuffer is zero; uploads are piped straight
+// through to the WebDAV server.
+func (p Provider) UploadBuffer() int64 {
+	return 0
+}
+
+func (p Provider) DownloadBuffer() int64 {
+	return p.ranges.Buffer()
+}
+
 func (p Provider) Download(path string) (provider.Downloader, error) {
+	if p.ranges.Workers > 1 {
+		size, pin, err := p.size(path)
+		if err != nil {
+			return nil, err
+		}
+		if p.ranges.Use(size) {
+			return provider.RangedDownload(size, p.ranges, func(offset, length int64) (io.ReadCloser, error) {
+				return p.get(path, pin, offset, length)
+			})
+		}
+	}
+
 	req, err := http.NewRequest("GET", p.url(path), nil)
 	if err != nil {
 		return nil, err
@@ -112,6 +137,90 @@ func (p Provider) Download(path string) (provider.Downloader, error) {
 	return provider.MeteredDownload(res.Body)
 }
 
+// size returns the size of a file, and the conditional
+// headers that pin ranged gets to its current version: its
+// ETag if the server gives one, or else its modification
+// time.
+func (p Provider) size(path string) (int64, http.Header, error) {
+	req, err := http.NewRequest("HEAD", p.url(path), nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, nil, fmt.Errorf("%s: HTTP %s", req.URL, res.Status)
+	}
+	if res.ContentLength < 0 {
+		return 0, nil, fmt.Errorf("%s: no content length", req.URL)
+	}
+
+	pin := http.Header{}
+	if etag := res.Header.Get("ETag"); etag != "" {
+		pin.Set("If-Match", etag)
+	} else if mtime := res.Header.Get("Last-Modified"); mtime != "" {
+		pin.Set("If-Unmodified-Since", mtime)
+	}
+	return res.ContentLength, pin, nil
+}
+
+func (p Provider) get(path string, pin http.Header, offset, length int64) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", p.url(path), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range pin {
+		req.Header[k] = v
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusPreconditionFailed {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s: changed while it was being downloaded", req.URL)
+	}
+	if res.StatusCode != http.StatusPartialContent {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s: HTTP %s (expected a partial response)", req.URL, res.Status)
+	}
+	return res.Body, nil
+}
+
+// Stat returns the size and modification time of the
+// blob at path, or nil if there is no such blob.
+func (p Provider) Stat(path string) (*provider.Blob, error) {
+	req, err := http.NewRequest("HEAD", p.url(path), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: HTTP %s", req.URL, res.Status)
+	}
+
+	modified, err := http.ParseTime(res.Header.Get("Last-Modified"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: no (or malformed) last-modified time", req.URL)
+	}
+	return &provider.Blob{
+		Path:     path,
+		Size:     res.ContentLength,
+		Modified: modified,
+	}, nil
+}
+
 func (p Provider) Expunge(path string) error {
 	req, err := http.NewRequest("DELETE", p.url(path), nil)
 	if err != nil {
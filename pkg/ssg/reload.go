@@ -0,0 +1,139 @@
+package ssg
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/jhunt/go-log"
+
+	"github.com/jhunt/ssg/pkg/secret"
+	"github.com/jhunt/ssg/pkg/ssg/config"
+)
+
+func (s *Server) Reload() error {
+	if s.path == "" {
+		return fmt.Errorf("server was not configured from a file")
+	}
+
+	log.Infof(LOG+"reloading configuration from %s", s.path)
+	c, err := config.ReadFile(s.path)
+	if err == nil {
+		err = s.Reconfigure(c)
+	}
+	if err != nil {
+		log.Errorf(LOG+"unable to reload configuration from %s (keeping the current configuration): %s", s.path, err)
+		return err
+	}
+	return nil
+}
+
+func (s *Server) Reconfigure(c config.Config) error {
+	// in-flight streams hold on to the *bucket they started with
+	buckets, err := configureBuckets(c, s.ReservoirSize, s.allBuckets())
+	if err != nil {
+		return err
+	}
+
+	// keep the verifier (and the keys it has fetched) as
+	// long as the jwt configuration stays the same
+	s.lock.Lock()
+	verifier := s.jwt
+	s.lock.Unlock()
+	if !sameJWT(verifier, c.JWT) {
+		verifier, err = configureJWT(c.JWT)
+		if err != nil {
+			return err
+		}
+	}
+
+	var roles []config.TLSRole
+	if c.TLS != nil {
+		roles = c.TLS.Roles
+	}
+
+	control := configureTokens(c.ControlTokens, c.Tokens)
+	controls, err := indexTokens(control)
+	if err != nil {
+		return fmt.Errorf("invalid control tokens: %s", err)
+	}
+	monitors, err := secret.NewSet(c.MonitorTokens)
+	if err != nil {
+		return fmt.Errorf("invalid monitor tokens: %s", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if c.Cluster != s.Cluster {
+		log.Warnf(LOG+"ignoring change of cluster identity from %v to %v; a restart is required", s.Cluster, c.Cluster)
+	}
+	if c.Bind != s.Bind {
+		log.Warnf(LOG+"ignoring change of bind address from %v to %v; a restart is required", s.Bind, c.Bind)
+	}
+
+	s.buckets = buckets
+	log.Infof(LOG+"configured %d buckets", len(s.buckets))
+
+	// identities share rate limits by name, which only
+	// carry over if every source of names and limits does
+	if !reflect.DeepEqual(control, s.ControlTokens) || !reflect.DeepEqual(roles, s.roles) || verifier != s.jwt {
+		s.identities = make(map[string]*limits)
+	}
+	s.ControlTokens = control
+	s.controls = controls
+	log.Infof(LOG+"authorized %d control tokens (%d named)", len(s.ControlTokens), len(c.Tokens))
+
+	s.MonitorTokens = make([]string, len(c.MonitorTokens))
+	copy(s.MonitorTokens, c.MonitorTokens)
+	s.monitors = monitors
+	log.Infof(LOG+"authorized %d monitor tokens", len(s.MonitorTokens))
+
+	s.MaxLease = time.Duration(c.MaxLease) * time.Second
+	log.Infof(LOG+"set maximum stream lease to %d seconds", c.MaxLease)
+
+	s.LeaseCeiling = time.Duration(c.LeaseCeiling) * time.Second
+	log.Infof(LOG+"set stream lease ceiling to %d seconds", c.LeaseCeiling)
+
+	s.WriteQueue = c.WriteQueue
+	log.Infof(LOG+"set upload write queue depth to %d segments", c.WriteQueue)
+
+	s.roles = roles
+	s.jwt = verifier
+	s.signing = c.Signing
+	s.origins = c.Tus.AllowedOrigins
+	s.concurrency = c.Concurrency
+	s.policy = configurePolicy(c.Authz)
+	return nil
+}
+
+func sameJWT(v *verifier, c *config.JWT) bool {
+	if v == nil || c == nil {
+		return v == nil && c == nil
+	}
+	return reflect.DeepEqual(v.config, *c)
+}
+
+func (s *Server) leases() (time.Duration, time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.MaxLease, s.LeaseCeiling
+}
+
+func (s *Server) policyWebhook() *policy {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.policy
+}
+
+func (s *Server) allowedOrigins() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.origins
+}
+
+func (s *Server) signingLimits() *config.Signing {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.signing
+}
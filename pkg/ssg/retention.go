@@ -0,0 +1,79 @@
+package ssg
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jhunt/go-log"
+
+	"github.com/jhunt/ssg/pkg/url"
+)
+
+// protected is returned when a blob cannot be expunged
+// (or overwritten) because it is under legal hold, or
+// has not yet been kept for its bucket's minimum
+// retention period.
+type protected string
+
+func (e protected) Error() string {
+	return string(e)
+}
+
+// protected checks whether the blob at path is under
+// legal hold or still within its minimum retention period,
+// returning a protected error if so.  Blobs that do not
+// exist yet are never protected.
+func (b *bucket) protected(path string, now time.Time) error {
+	if b.holder != nil {
+		held, err := b.holder.Held(path)
+		if err != nil {
+			return fmt.Errorf("unable to check %s for a legal hold: %s", path, err)
+		}
+		if held {
+			return protected(fmt.Sprintf("%s is under legal hold", path))
+		}
+	}
+
+	if b.retention > 0 {
+		blob, err := b.stater.Stat(path)
+		if err != nil {
+			return fmt.Errorf("unable to check retention of %s: %s", path, err)
+		}
+		if blob != nil {
+			if until := blob.Modified.Add(b.retention); now.Before(until) {
+				return protected(fmt.Sprintf("%s must be retained until %s", path, until.UTC().Format(time.RFC3339)))
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) hold(where *url.URL, on bool) error {
+	log.Debugf(LOG+"looking for bucket '%s' (from url '%s')", where.Bucket, where)
+	bucket := s.bucket(where.Bucket)
+	if bucket == nil {
+		return fmt.Errorf("bucket '%s' not found", where.Bucket)
+	}
+	if bucket.holder == nil {
+		return noHolds(fmt.Sprintf("bucket '%s' does not support legal holds", where.Bucket))
+	}
+	if where.Path == "" {
+		return noHolds("legal holds require a target path")
+	}
+
+	if on {
+		log.Infof(LOG+"placing legal hold on %v", where)
+	} else {
+		log.Infof(LOG+"releasing legal hold on %v", where)
+	}
+	return bucket.holder.Hold(where.Path, on)
+}
+
+// noHolds is returned when a legal hold is requested for
+// something that cannot be held.
+type noHolds string
+
+func (e noHolds) Error() string {
+	return string(e)
+}
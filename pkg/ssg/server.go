@@ -1,6 +1,7 @@
 package ssg
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
@@ -9,6 +10,8 @@ import (
 	"github.com/jhunt/go-log"
 
 	"github.com/jhunt/ssg/pkg/rand"
+	"github.com/jhunt/ssg/pkg/secret"
+	"github.com/jhunt/ssg/pkg/ssg/audit"
 	"github.com/jhunt/ssg/pkg/ssg/config"
 	"github.com/jhunt/ssg/pkg/url"
 
@@ -24,16 +27,22 @@ import (
 	"github.com/jhunt/ssg/pkg/ssg/vaults/static"
 )
 
-func (s *Server) startUpload(to *url.URL) (*stream, string, error) {
+func (s *Server) startUpload(to *url.URL, life time.Duration, by requester) (*stream, string, error) {
 	log.Debugf(LOG+"looking for bucket '%s' (from url '%s')", to.Bucket, to)
 	bucket := s.bucket(to.Bucket)
 	if bucket == nil {
 		return nil, "", fmt.Errorf("bucket '%s' not found", to.Bucket)
 	}
 
+	reserved, queue, err := s.reserve("upload", bucket)
+	if err != nil {
+		return nil, "", err
+	}
+
 	log.Debugf(LOG+"generating random path in bucket '%s'", to.Bucket)
 	uploader, err := bucket.Upload(to.Path)
 	if err != nil {
+		s.unreserve("upload", bucket, reserved)
 		return nil, "", err
 	}
 	to.Path = uploader.Path()
@@ -42,15 +51,22 @@ func (s *Server) startUpload(to *url.URL) (*stream, string, error) {
 	upstream := &stream{
 		id:     rand.String(96),
 		canon:  to.String(),
+		path:   to.Path,
 		reader: nil,
 		writer: uploader,
 		bucket: bucket,
+		length: -1,
+
+		reserved: reserved,
+
+		requester: by,
 	}
-	upstream.lease(s.MaxLease)
+	upstream.lease(life)
 	log.Debugf(LOG+"stream %v -> %v will be valid until %v", upstream.id, upstream.canon, upstream.expires)
 
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	upstream.pipe = newPipeline(queue)
 	s.uploads[upstream.id] = upstream
 	bucket.metrics.StartUpload()
 	return upstream, uploader.Path(), nil
@@ -70,27 +86,39 @@ func (s *Server) getUpload(id, token string) (*stream, bool) {
 	return upstream, ok && upstream.authorize(token)
 }
 
-func (s *Server) startDownload(from *url.URL) (*stream, error) {
+func (s *Server) startDownload(from *url.URL, life time.Duration, by requester) (*stream, error) {
 	log.Debugf(LOG+"looking for bucket '%s' (from url '%s')", from.Bucket, from)
 	bucket := s.bucket(from.Bucket)
 	if bucket == nil {
 		return nil, fmt.Errorf("bucket '%s' not found", from.Bucket)
 	}
 
+	reserved, _, err := s.reserve("download", bucket)
+	if err != nil {
+		return nil, err
+	}
+
 	log.Infof(LOG+"starting download from %v", from)
 	downloader, err := bucket.Download(from.Path)
 	if err != nil {
+		s.unreserve("download", bucket, reserved)
 		return nil, err
 	}
 
 	downstream := &stream{
 		id:     rand.String(96),
 		canon:  from.String(),
+		path:   from.Path,
 		reader: downloader,
 		writer: nil,
 		bucket: bucket,
+		length: -1,
+
+		reserved: reserved,
+
+		requester: by,
 	}
-	downstream.lease(s.MaxLease)
+	downstream.lease(life)
 	log.Debugf(LOG+"stream %v <- %v will be valid until %v", downstream.id, downstream.canon, downstream.expires)
 
 	s.lock.Lock()
@@ -114,6 +142,47 @@ func (s *Server) getDownload(id, token string) (*stream, bool) {
 	return downstream, ok && downstream.authorize(token)
 }
 
+func (s *Server) lookup(id string) (*stream, string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if x, ok := s.uploads[id]; ok {
+		return x, "upload"
+	}
+	if x, ok := s.downloads[id]; ok {
+		return x, "download"
+	}
+	return nil, ""
+}
+
+func (s *Server) touch(x *stream) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, up := s.uploads[x.id]
+	_, down := s.downloads[x.id]
+	if !up && !down {
+		log.Debugf(LOG+"%s stream %v is no longer active", x.kind(), x.id)
+		return false
+	}
+	x.renew()
+	return true
+}
+
+func (s *Server) cancel(x *stream, why string) error {
+	s.forget(x)
+
+	if x.writer != nil {
+		log.Infof(LOG+"canceling upload stream %v to %v (%s)", x.id, x.canon, why)
+		x.bucket.metrics.CancelUpload()
+	} else {
+		log.Infof(LOG+"canceling download stream %v from %v (%s)", x.id, x.canon, why)
+		x.bucket.metrics.CancelDownload()
+	}
+	s.record(x.record("cancel", "canceled", why))
+	return x.Cancel()
+}
+
 func (s *Server) forget(x *stream) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -121,11 +190,13 @@ func (s *Server) forget(x *stream) {
 	if _, ok := s.uploads[x.id]; ok {
 		log.Debugf(LOG+"forgeting upload stream %v", x.id)
 		delete(s.uploads, x.id)
+		s.release(x)
 	}
 
 	if _, ok := s.downloads[x.id]; ok {
 		log.Debugf(LOG+"forgeting download stream %v", x.id)
 		delete(s.downloads, x.id)
+		s.release(x)
 	}
 }
 
@@ -143,21 +214,119 @@ func (s *Server) expunge(where *url.URL) error {
 
 func (s *Server) Run(helo string) error {
 	go s.Sweep()
+	go s.Lifecycle()
+
+	srv := &http.Server{
+		Addr:              s.Bind,
+		Handler:           s.Router(helo),
+		TLSConfig:         s.TLS,
+		ReadHeaderTimeout: s.Timeouts.ReadHeader,
+		ReadTimeout:       s.Timeouts.Read,
+		WriteTimeout:      s.Timeouts.Write,
+		IdleTimeout:       s.Timeouts.Idle,
+	}
+	s.lock.Lock()
+	s.http = srv
+	s.lock.Unlock()
 
-	log.Infof(LOG+"http server starting up on %s", s.Bind)
-	if err := http.ListenAndServe(s.Bind, s.Router(helo)); err != nil {
+	var err error
+	if s.TLS != nil {
+		log.Infof(LOG+"https server starting up on %s", s.Bind)
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		log.Infof(LOG+"http server starting up on %s", s.Bind)
+		err = srv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		return err
 	}
 	log.Infof(LOG + "http server shutting down")
 	return nil
 }
 
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	if s.draining {
+		s.lock.Unlock()
+		return fmt.Errorf("server is already shutting down")
+	}
+	s.draining = true
+	close(s.done)
+	s.lock.Unlock()
+
+	log.Infof(LOG+"shutting down; waiting up to %v for %d active streams to finish", s.ShutdownGrace, s.active())
+
+	grace := time.NewTimer(s.ShutdownGrace)
+	defer grace.Stop()
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
+
+wait:
+	for s.active() > 0 {
+		select {
+		case <-grace.C:
+			break wait
+		case <-ctx.Done():
+			break wait
+		case <-t.C:
+		}
+	}
+
+	s.lock.Lock()
+	remaining := make([]*stream, 0, len(s.uploads)+len(s.downloads))
+	for _, x := range s.uploads {
+		remaining = append(remaining, x)
+	}
+	for _, x := range s.downloads {
+		remaining = append(remaining, x)
+	}
+	srv := s.http
+	s.lock.Unlock()
+
+	if len(remaining) > 0 {
+		log.Infof(LOG+"canceling %d streams that did not finish in time", len(remaining))
+		for _, x := range remaining {
+			if err := s.cancel(x, "gateway is shutting down"); err != nil {
+				log.Infof(LOG+"unable to cancel stream %v: %s", x.id, err)
+			}
+		}
+	}
+
+	defer s.auditor.Close()
+	if srv == nil {
+		return nil
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.Close()
+		return err
+	}
+	return nil
+}
+
+func (s *Server) active() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.uploads) + len(s.downloads)
+}
+
+func (s *Server) shuttingDown() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.draining
+}
+
 func NewServerFromFile(path string) (*Server, error) {
 	cfg, err := config.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	return NewServer(cfg)
+
+	s, err := NewServer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.path = path
+	return s, nil
 }
 
 func NewServerFromString(yaml string) (*Server, error) {
@@ -172,6 +341,10 @@ func NewServer(c config.Config) (*Server, error) {
 	var s Server
 	s.uploads = make(map[string]*stream)
 	s.downloads = make(map[string]*stream)
+	s.done = make(chan struct{})
+	s.identities = make(map[string]*limits)
+	s.inUse = make(map[string]*usage)
+	s.auditor = audit.Nil
 
 	s.Cluster = c.Cluster
 	log.Infof(LOG+"set cluster identity to %v", s.Bind)
@@ -179,24 +352,106 @@ func NewServer(c config.Config) (*Server, error) {
 	s.Bind = c.Bind
 	log.Infof(LOG+"set bind address to %v", s.Bind)
 
+	if c.TLS != nil {
+		cfg, err := configureTLS(*c.TLS)
+		if err != nil {
+			return nil, err
+		}
+		s.TLS = cfg
+		s.roles = c.TLS.Roles
+		log.Infof(LOG+"enabled tls (minimum version %s, certificate %s)", c.TLS.MinVersion, c.TLS.Certificate)
+		if c.TLS.ClientCA != nil {
+			log.Infof(LOG+"%s client certificates; mapping %d subject patterns to roles", c.TLS.ClientAuth, len(s.roles))
+		}
+	}
+
+	s.ShutdownGrace = time.Duration(c.ShutdownGrace) * time.Second
+	log.Infof(LOG+"set shutdown grace period to %d seconds", c.ShutdownGrace)
+
+	s.Timeouts.ReadHeader = time.Duration(c.Timeouts.ReadHeader) * time.Second
+	s.Timeouts.Read = time.Duration(c.Timeouts.Read) * time.Second
+	s.Timeouts.Write = time.Duration(c.Timeouts.Write) * time.Second
+	s.Timeouts.Idle = time.Duration(c.Timeouts.Idle) * time.Second
+	log.Infof(LOG+"set http timeouts to %ds (read header), %ds (read), %ds (write), and %ds (idle)",
+		c.Timeouts.ReadHeader, c.Timeouts.Read, c.Timeouts.Write, c.Timeouts.Idle)
+
+	auditor, err := configureAudit(c.Audit)
+	if err != nil {
+		return nil, err
+	}
+	s.auditor = auditor
+
 	s.ReservoirSize = c.Metrics.ReservoirSize
 	log.Infof(LOG+"set metrics sampling reservoir size to %v", s.ReservoirSize)
 
-	s.ControlTokens = make([]string, len(c.ControlTokens))
-	copy(s.ControlTokens, c.ControlTokens)
-	log.Infof(LOG+"authorized %d control tokens", len(s.ControlTokens))
+	s.ControlTokens = configureTokens(c.ControlTokens, c.Tokens)
+	s.controls, err = indexTokens(s.ControlTokens)
+	if err != nil {
+		return nil, fmt.Errorf("invalid control tokens: %s", err)
+	}
+	log.Infof(LOG+"authorized %d control tokens (%d named)", len(s.ControlTokens), len(c.Tokens))
+
+	verifier, err := configureJWT(c.JWT)
+	if err != nil {
+		return nil, err
+	}
+	s.jwt = verifier
+
+	s.origins = c.Tus.AllowedOrigins
+	if len(s.origins) > 0 {
+		log.Infof(LOG+"allowing cross-origin tus uploads from %s", strings.Join(s.origins, ", "))
+	}
+
+	s.signing = c.Signing
+	if s.signing != nil {
+		log.Infof(LOG+"enabled pre-signed urls (%d signing keys, valid for up to %d seconds)", len(s.signing.Keys), s.signing.MaxLifetime)
+	}
+
+	s.policy = configurePolicy(c.Authz)
 
 	s.MonitorTokens = make([]string, len(c.MonitorTokens))
 	copy(s.MonitorTokens, c.MonitorTokens)
+	s.monitors, err = secret.NewSet(s.MonitorTokens)
+	if err != nil {
+		return nil, fmt.Errorf("invalid monitor tokens: %s", err)
+	}
 	log.Infof(LOG+"authorized %d monitor tokens", len(s.MonitorTokens))
 
 	s.MaxLease = time.Duration(c.MaxLease) * time.Second
 	log.Infof(LOG+"set maximum stream lease to %d seconds", c.MaxLease)
 
+	s.LeaseCeiling = time.Duration(c.LeaseCeiling) * time.Second
+	log.Infof(LOG+"set stream lease ceiling to %d seconds", c.LeaseCeiling)
+
+	s.concurrency = c.Concurrency
+	if s.concurrency != nil {
+		log.Infof(LOG+"limiting the gateway to %d uploads, %d downloads and %dMiB of upload buffers (0 is unlimited)",
+			s.concurrency.MaxUploads, s.concurrency.MaxDownloads, s.concurrency.MaxBufferMemory)
+	}
+
+	s.WriteQueue = c.WriteQueue
+	if s.WriteQueue > 0 {
+		log.Infof(LOG+"queueing up to %d segments per upload stream", s.WriteQueue)
+	}
+
 	s.SweepInterval = time.Duration(c.SweepInterval) * time.Second
 	log.Infof(LOG+"set stream sweep interval to %d seconds", c.MaxLease)
 
-	s.buckets = make([]*bucket, len(c.Buckets))
+	s.LifecycleInterval = time.Duration(c.LifecycleInterval) * time.Second
+	log.Infof(LOG+"set bucket lifecycle interval to %d seconds", c.LifecycleInterval)
+
+	buckets, err := configureBuckets(c, s.ReservoirSize, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.buckets = buckets
+	log.Infof(LOG+"configured %d buckets", len(s.buckets))
+
+	return &s, nil
+}
+
+func configureBuckets(c config.Config, reservoir int, prior []*bucket) ([]*bucket, error) {
+	buckets := make([]*bucket, len(c.Buckets))
 	for i, b := range c.Buckets {
 		var p provider.Provider
 		switch b.Provider.Kind {
@@ -219,9 +474,10 @@ func NewServer(c config.Config) (*Server, error) {
 		case "gcs":
 			log.Infof(LOG+"configuring bucket %v backed by gcs (bucket=%v, prefix=%v)", b.Key, b.Provider.GCS.Bucket, b.Provider.GCS.Prefix)
 			candidate, err := gcs.Configure(gcs.Endpoint{
-				Bucket: b.Provider.GCS.Bucket,
-				Prefix: b.Provider.GCS.Prefix,
-				Key:    b.Provider.GCS.Key,
+				Bucket:    b.Provider.GCS.Bucket,
+				Prefix:    b.Provider.GCS.Prefix,
+				Key:       b.Provider.GCS.Key,
+				Downloads: provider.NewRanges(b.Provider.GCS.Downloads.Workers, b.Provider.GCS.Downloads.ChunkSize),
 			})
 			if err != nil {
 				return nil, fmt.Errorf("gcs bucket %v could not be configured: %s", b.Key, err)
@@ -243,16 +499,31 @@ func NewServer(c config.Config) (*Server, error) {
 			if b.Provider.S3.PartSize != 0 {
 				attrs = append(attrs, fmt.Sprintf("part-size=%d", b.Provider.S3.PartSize))
 			}
+			if b.Provider.S3.ParallelParts != 0 {
+				attrs = append(attrs, fmt.Sprintf("parallel-parts=%d", b.Provider.S3.ParallelParts))
+			}
+			if b.Provider.S3.Downloads.Workers > 1 {
+				attrs = append(attrs, fmt.Sprintf("download-workers=%d", b.Provider.S3.Downloads.Workers))
+			}
+			if b.Provider.S3.ObjectLock != "" {
+				attrs = append(attrs, fmt.Sprintf("object-lock=%s", b.Provider.S3.ObjectLock))
+			}
 			log.Infof(LOG+"configuring bucket %v backed by s3 (%s)", b.Key, strings.Join(attrs, ", "))
 			candidate, err := s3.Configure(s3.Endpoint{
-				URL:             b.Provider.S3.URL,
-				Prefix:          b.Provider.S3.Prefix,
-				Region:          b.Provider.S3.Region,
-				Bucket:          b.Provider.S3.Bucket,
-				UsePath:         b.Provider.S3.UsePath,
-				PartSize:        b.Provider.S3.PartSize,
-				AccessKeyID:     b.Provider.S3.AccessKeyID,
-				SecretAccessKey: b.Provider.S3.SecretAccessKey,
+				URL:              b.Provider.S3.URL,
+				Prefix:           b.Provider.S3.Prefix,
+				Region:           b.Provider.S3.Region,
+				Bucket:           b.Provider.S3.Bucket,
+				UsePath:          b.Provider.S3.UsePath,
+				PartSize:         b.Provider.S3.PartSize,
+				ParallelParts:    b.Provider.S3.ParallelParts,
+				Downloads:        provider.NewRanges(b.Provider.S3.Downloads.Workers, b.Provider.S3.Downloads.ChunkSize),
+				ObjectLock:       b.Provider.S3.ObjectLock,
+				Retention:        b.Retention.Period(),
+				AccessKeyID:      b.Provider.S3.AccessKeyID,
+				SecretAccessKey:  b.Provider.S3.SecretAccessKey,
+				InstanceMetadata: b.Provider.S3.InstanceMetadata,
+				Timeout:          time.Duration(b.Provider.S3.Timeout) * time.Second,
 			})
 			if err != nil {
 				return nil, fmt.Errorf("s3 bucket %v could not be configured: %s", b.Key, err)
@@ -262,10 +533,11 @@ func NewServer(c config.Config) (*Server, error) {
 		case "webdav":
 			log.Infof(LOG+"configuring bucket %v backed by webdav (url=%v)", b.Key, b.Provider.WebDAV.URL)
 			candidate, err := webdav.Configure(webdav.Endpoint{
-				URL:      b.Provider.WebDAV.URL,
-				Username: b.Provider.WebDAV.BasicAuth.Username,
-				Password: b.Provider.WebDAV.BasicAuth.Password,
-				CA:       b.Provider.WebDAV.CA,
+				URL:       b.Provider.WebDAV.URL,
+				Username:  b.Provider.WebDAV.BasicAuth.Username,
+				Password:  b.Provider.WebDAV.BasicAuth.Password,
+				CA:        b.Provider.WebDAV.CA,
+				Downloads: provider.NewRanges(b.Provider.WebDAV.Downloads.Workers, b.Provider.WebDAV.Downloads.ChunkSize),
 			})
 			if err != nil {
 				return nil, fmt.Errorf("webdav bucket %v could not be configured: %s", b.Key, err)
@@ -317,18 +589,75 @@ func NewServer(c config.Config) (*Server, error) {
 			}
 		}
 
-		s.buckets[i] = &bucket{
+		buffers := make(map[string]int64)
+		if bp, ok := p.(provider.Buffered); ok {
+			buffers["upload"] = bp.UploadBuffer()
+			buffers["download"] = bp.DownloadBuffer()
+		}
+
+		var lister provider.Lister
+		if len(b.Lifecycle) > 0 {
+			l, ok := p.(provider.Lister)
+			if !ok {
+				return nil, fmt.Errorf("bucket %v has lifecycle rules, but %s buckets cannot list their blobs", b.Key, b.Provider.Kind)
+			}
+			log.Infof(LOG+"applying %d lifecycle rules to bucket %v", len(b.Lifecycle), b.Key)
+			lister = l
+		}
+
+		var stater provider.Stater
+		if b.Retention != nil {
+			st, ok := p.(provider.Stater)
+			if !ok {
+				return nil, fmt.Errorf("bucket %v has a minimum retention, but %s buckets cannot look up their blobs", b.Key, b.Provider.Kind)
+			}
+			log.Infof(LOG+"retaining blobs in bucket %v for at least %s", b.Key, b.Retention.Minimum)
+			stater = st
+		}
+
+		// s3 buckets can only hold blobs via object lock
+		holder, _ := p.(provider.Holder)
+		if b.Provider.Kind == "s3" && b.Provider.S3.ObjectLock == "" {
+			holder = nil
+		}
+
+		// carry counters over (by key) from any prior configuration
+		m := newMetric(reservoir)
+		for _, old := range prior {
+			if old.key == b.Key {
+				m = old.metrics
+				break
+			}
+		}
+
+		if v.Provider != nil {
+			v.Provider = instrumentVault(v.Provider, m)
+		}
+		buckets[i] = &bucket{
 			key:         b.Key,
 			name:        b.Name,
 			description: b.Description,
 			compression: b.Compression,
 			encryption:  b.Encryption,
-			provider:    p,
+			provider:    instrument(p, m),
 			vault:       v,
-			metrics:     newMetric(s.ReservoirSize),
+			metrics:     m,
+			limits:      newLimits(b.Limits),
+			concurrency: b.Concurrency,
+			buffers:     buffers,
+		}
+		if lister != nil {
+			buckets[i].lister = instrumentedLister{inner: lister, metrics: m}
+			buckets[i].lifecycle = b.Lifecycle
+		}
+		if stater != nil {
+			buckets[i].stater = instrumentedStater{inner: stater, metrics: m}
+			buckets[i].retention = b.Retention.Period()
+		}
+		if holder != nil {
+			buckets[i].holder = instrumentedHolder{inner: holder, metrics: m}
 		}
 	}
-	log.Infof(LOG+"configured %d buckets", len(s.buckets))
 
-	return &s, nil
+	return buckets, nil
 }
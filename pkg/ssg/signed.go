@@ -0,0 +1,161 @@
+package ssg
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jhunt/go-route"
+
+	"github.com/jhunt/ssg/pkg/ssg/config"
+	"github.com/jhunt/ssg/pkg/url"
+)
+
+var errTooLarge = errors.New("request body exceeds the size limit")
+
+type presigned struct {
+	Operation string
+	Canon     string
+	Expires   time.Time
+	Size      int64
+	By        string
+	Key       string
+}
+
+func (p presigned) message() []byte {
+	return []byte(strings.Join([]string{
+		p.Operation,
+		p.Canon,
+		strconv.FormatInt(p.Expires.Unix(), 10),
+		strconv.FormatInt(p.Size, 10),
+		p.By,
+	}, "\n"))
+}
+
+func (p presigned) mac(secret string) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(p.message())
+	return h.Sum(nil)
+}
+
+func (s *Server) presign(p presigned) (string, error) {
+	s.lock.Lock()
+	signing := s.signing
+	s.lock.Unlock()
+
+	if signing == nil {
+		return "", fmt.Errorf("pre-signed urls are not enabled")
+	}
+
+	key := signing.Keys[0]
+	p.Key = key.ID
+
+	q := neturl.Values{}
+	q.Set("op", p.Operation)
+	q.Set("canon", p.Canon)
+	q.Set("exp", strconv.FormatInt(p.Expires.Unix(), 10))
+	if p.Operation == "upload" {
+		q.Set("size", strconv.FormatInt(p.Size, 10))
+	}
+	if p.By != "" {
+		q.Set("by", p.By)
+	}
+	q.Set("kid", p.Key)
+	q.Set("sig", base64.RawURLEncoding.EncodeToString(p.mac(key.Secret)))
+	return "/signed/blob?" + q.Encode(), nil
+}
+
+func (s *Server) presigned(r *route.Request, op string) (presigned, *url.URL, bool) {
+	s.lock.Lock()
+	signing := s.signing
+	s.lock.Unlock()
+
+	var p presigned
+	if signing == nil {
+		r.Fail(route.NotFound(nil, "pre-signed urls are not enabled"))
+		return p, nil, false
+	}
+
+	p.Operation = r.Param("op", "")
+	p.Canon = r.Param("canon", "")
+	p.By = r.Param("by", "")
+	p.Key = r.Param("kid", "")
+	if p.Operation != op {
+		r.Fail(route.Bad(nil, "this url is not valid for %s", op))
+		return p, nil, false
+	}
+
+	exp, err := strconv.ParseInt(r.Param("exp", ""), 10, 64)
+	if err != nil {
+		r.Fail(route.Bad(nil, "invalid or missing expiry"))
+		return p, nil, false
+	}
+	p.Expires = time.Unix(exp, 0)
+
+	if op == "upload" {
+		p.Size, err = strconv.ParseInt(r.Param("size", ""), 10, 64)
+		if err != nil || p.Size <= 0 {
+			r.Fail(route.Bad(nil, "invalid or missing size"))
+			return p, nil, false
+		}
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(r.Param("sig", ""))
+	if err != nil {
+		r.Fail(route.Bad(nil, "malformed signature"))
+		return p, nil, false
+	}
+
+	var key *config.SigningKey
+	for i := range signing.Keys {
+		if signing.Keys[i].ID == p.Key {
+			key = &signing.Keys[i]
+			break
+		}
+	}
+	if key == nil || !hmac.Equal(sig, p.mac(key.Secret)) {
+		r.Fail(route.Forbidden(nil, "invalid signature"))
+		return p, nil, false
+	}
+	if time.Now().After(p.Expires) {
+		r.Fail(route.Forbidden(nil, "this url expired at %s", p.Expires.UTC().Format(time.RFC3339)))
+		return p, nil, false
+	}
+
+	target, err := url.Parse(p.Canon)
+	if err != nil {
+		r.Fail(route.Bad(err, "invalid canon '%s': %s", p.Canon, err))
+		return p, nil, false
+	}
+	return p, target, true
+}
+
+type bounded struct {
+	r io.Reader
+	n int64
+}
+
+func (b *bounded) Read(p []byte) (int, error) {
+	if b.n <= 0 {
+		var one [1]byte
+		n, err := b.r.Read(one[:])
+		if n > 0 {
+			return 0, errTooLarge
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > b.n {
+		p = p[:b.n]
+	}
+	n, err := b.r.Read(p)
+	b.n -= int64(n)
+	return n, err
+}
@@ -1,15 +1,26 @@
 package ssg
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"time"
 
 	"github.com/jhunt/ssg/pkg/rand"
+	"github.com/jhunt/ssg/pkg/ssg/provider"
 )
 
+const RateWindow = 5 * time.Second
+
+var errCanceled = errors.New("stream was canceled")
+
 func (s *stream) lease(life time.Duration) {
 	s.secret = rand.String(32)
 	s.leased = time.Now()
+	if s.created.IsZero() {
+		s.created = s.leased
+		s.active = s.leased
+	}
 	s.expires = s.leased.Add(life)
 	s.renewal = life
 }
@@ -34,47 +45,270 @@ func (s *stream) renew() {
 	s.expires = time.Now().Add(s.renewal)
 }
 
+func (s *stream) kind() string {
+	if s.writer != nil {
+		return "upload"
+	}
+	return "download"
+}
+
+// progress is a consistent snapshot of the transfer
+// progress of a stream.
+type progress struct {
+	offset       int64
+	length       int64
+	segments     int
+	active       time.Time
+	compressed   int64
+	uncompressed int64
+	throughput   float64
+}
+
+func (s *stream) progress(now time.Time) progress {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return progress{
+		offset:       s.offset,
+		length:       s.length,
+		segments:     s.segments,
+		active:       s.active,
+		compressed:   s.compressed.total(),
+		uncompressed: s.uncompressed.total(),
+		throughput:   s.throughput(now),
+	}
+}
+
+func (p progress) idle(now time.Time) time.Duration {
+	return now.Sub(p.active)
+}
+
+// throughput must be called with s.lock held.
+func (s *stream) throughput(now time.Time) float64 {
+	if now.Sub(s.active) > RateWindow {
+		return 0
+	}
+	if s.rate.bps == 0 && s.rate.n > 0 {
+		if elapsed := now.Sub(s.rate.since).Seconds(); elapsed > 0 {
+			return float64(s.rate.n) / elapsed
+		}
+	}
+	return s.rate.bps
+}
+
+// transferred must be called with s.lock held.
+func (s *stream) transferred(n int) {
+	now := time.Now()
+	s.active = now
+
+	if s.rate.since.IsZero() {
+		s.rate.since = now
+	}
+	s.rate.n += int64(n)
+	if elapsed := now.Sub(s.rate.since); elapsed >= RateWindow {
+		s.rate.bps = float64(s.rate.n) / elapsed.Seconds()
+		s.rate.since = now
+		s.rate.n = 0
+	}
+}
+
+func (s *stream) deflate() bool {
+	if !s.bucket.Deflated() {
+		return false
+	}
+
+	z, ok := s.reader.(*provider.ZlibDownloader)
+	if !ok {
+		return false
+	}
+
+	raw, ok := z.Raw()
+	if !ok {
+		return false
+	}
+
+	s.reader = raw
+	s.encoding = "deflate"
+	return true
+}
+
+func (s *stream) encode(enc string) error {
+	if enc == "identity" {
+		enc = ""
+	}
+	if enc == s.encoding {
+		return nil
+	}
+
+	s.lock.Lock()
+	started := s.segments > 0
+	s.lock.Unlock()
+	if started {
+		return fmt.Errorf("cannot switch content-encoding mid-stream")
+	}
+
+	if enc != "deflate" {
+		return fmt.Errorf("unsupported content-encoding '%s'", enc)
+	}
+	if !s.bucket.Deflated() {
+		return fmt.Errorf("bucket '%s' does not accept pre-compressed data", s.bucket.key)
+	}
+
+	z, ok := s.writer.(*provider.ZlibUploader)
+	if !ok {
+		return fmt.Errorf("bucket '%s' does not accept pre-compressed data", s.bucket.key)
+	}
+
+	raw, ok := z.Raw()
+	if !ok {
+		return fmt.Errorf("cannot switch content-encoding mid-stream")
+	}
+
+	s.writer = raw
+	s.encoding = enc
+	return nil
+}
+
+func (s *stream) resume(offset *int64, seq *int, n int) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if offset != nil {
+		if *offset == s.offset {
+			return false, nil
+		}
+		if s.segments > 0 && *offset == s.last.offset && n == s.last.n {
+			return true, nil
+		}
+		return false, fmt.Errorf("out-of-order segment: offset %d does not match committed offset %d", *offset, s.offset)
+	}
+
+	if seq != nil {
+		if *seq == s.segments {
+			return false, nil
+		}
+		if s.segments > 0 && *seq == s.segments-1 && n == s.last.n {
+			return true, nil
+		}
+		return false, fmt.Errorf("out-of-order segment: sequence number %d does not match expected sequence number %d", *seq, s.segments)
+	}
+
+	return false, nil
+}
+
 func (s *stream) Read(b []byte) (int, error) {
 	n, err := s.reader.Read(b)
 	if err != nil && err != io.EOF {
 		return n, err
 	}
-
+	s.lock.Lock()
+	s.transferred(n)
 	s.compressed.set(s.reader.ReadCompressed())
 	s.uncompressed.set(s.reader.ReadUncompressed())
-	s.bucket.metrics.OutFront(s.uncompressed.delta())
-	s.bucket.metrics.InBack(s.compressed.delta())
+	out, in := s.uncompressed.delta(), s.compressed.delta()
+	s.lock.Unlock()
+
+	s.throttle(n)
+	s.bucket.metrics.OutFront(out)
+	s.bucket.metrics.InBack(in)
 
 	return n, err
 }
 
+func (s *stream) write(b []byte) (int, error) {
+	if s.pipe == nil {
+		return s.writer.Write(b)
+	}
+	if err := s.pipe.enqueue(s.writer, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (s *stream) wrote() (int64, int64) {
+	if s.pipe == nil {
+		return s.writer.WroteCompressed(), s.writer.WroteUncompressed()
+	}
+	return s.pipe.wrote()
+}
+
+// flush waits for any queued segments to be written to
+// the provider, and accounts for them.
+func (s *stream) flush() error {
+	if s.pipe == nil {
+		return nil
+	}
+
+	err := s.pipe.wait()
+	compressed, uncompressed := s.pipe.wrote()
+	s.lock.Lock()
+	s.compressed.set(compressed)
+	s.uncompressed.set(uncompressed)
+	in, out := s.uncompressed.delta(), s.compressed.delta()
+	s.lock.Unlock()
+
+	s.bucket.metrics.InFront(in)
+	s.bucket.metrics.OutBack(out)
+	return err
+}
+
 func (s *stream) Write(b []byte) (int, error) {
-	n, err := s.writer.Write(b)
+	s.busy.Lock()
+	if s.canceled {
+		s.busy.Unlock()
+		return 0, errCanceled
+	}
+	n, err := s.write(b)
+	s.busy.Unlock()
 	if err != nil {
 		return n, err
 	}
 
+	compressed, uncompressed := s.wrote()
+	s.lock.Lock()
+	s.last.offset = s.offset
+	s.last.n = n
+	s.offset += int64(n)
 	s.segments++
-	s.bucket.metrics.Segment(n)
+	s.transferred(n)
+	s.compressed.set(compressed)
+	s.uncompressed.set(uncompressed)
+	in, out := s.uncompressed.delta(), s.compressed.delta()
+	s.lock.Unlock()
 
-	s.compressed.set(s.writer.WroteCompressed())
-	s.uncompressed.set(s.writer.WroteUncompressed())
-	s.bucket.metrics.InFront(s.uncompressed.delta())
-	s.bucket.metrics.OutBack(s.compressed.delta())
+	s.throttle(n)
+	s.bucket.metrics.Segment(n)
+	s.bucket.metrics.InFront(in)
+	s.bucket.metrics.OutBack(out)
 
 	return n, nil
 }
 
 func (s *stream) Close() error {
 	if s.writer != nil {
+		s.busy.Lock()
+		defer s.busy.Unlock()
+		if s.canceled {
+			return errCanceled
+		}
+
+		if err := s.flush(); err != nil {
+			s.writer.Cancel()
+			return err
+		}
+
 		err := s.writer.Close()
 		if err != nil {
 			return err
 		}
 
+		s.lock.Lock()
 		s.compressed.set(s.writer.WroteCompressed())
 		s.uncompressed.set(s.writer.WroteUncompressed())
-		s.bucket.metrics.OutBack(s.compressed.delta())
+		out := s.compressed.delta()
+		s.lock.Unlock()
+
+		s.bucket.metrics.OutBack(out)
 	}
 
 	if s.reader != nil {
@@ -83,10 +317,14 @@ func (s *stream) Close() error {
 			return err
 		}
 
+		s.lock.Lock()
 		s.compressed.set(s.reader.ReadCompressed())
 		s.uncompressed.set(s.reader.ReadUncompressed())
-		s.bucket.metrics.OutFront(s.compressed.delta())
-		s.bucket.metrics.InBack(s.compressed.delta())
+		out, in := s.uncompressed.delta(), s.compressed.delta()
+		s.lock.Unlock()
+
+		s.bucket.metrics.OutFront(out)
+		s.bucket.metrics.InBack(in)
 	}
 
 	return nil
@@ -94,6 +332,17 @@ func (s *stream) Close() error {
 
 func (s *stream) Cancel() error {
 	if s.writer != nil {
+		// wait for any in-flight Write or Close
+		s.busy.Lock()
+		defer s.busy.Unlock()
+		if s.canceled {
+			return nil
+		}
+		s.canceled = true
+
+		if s.pipe != nil {
+			s.pipe.cancel()
+		}
 		return s.writer.Cancel()
 	}
 
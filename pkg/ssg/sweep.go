@@ -4,16 +4,22 @@ import (
 	"time"
 
 	"github.com/jhunt/go-log"
-
-	"github.com/jhunt/ssg/pkg/ssg/provider"
 )
 
 func (s *Server) Sweep() {
 	t := time.NewTicker(s.SweepInterval)
-	for range t.C {
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+		}
+
 		total := 0
 		logged := false
-		cancel := make(map[string]provider.Uploader)
+		expired := make([]*stream, 0)
 
 		s.lock.Lock()
 		for id, upload := range s.uploads {
@@ -24,9 +30,10 @@ func (s *Server) Sweep() {
 					logged = true
 				}
 				log.Debugf(LOG+"clearing out upload stream %v... it expired on %s", id, upload.expires)
-				cancel[upload.id] = upload.writer
+				expired = append(expired, upload)
 				upload.bucket.metrics.CancelUpload()
 				delete(s.uploads, id)
+				s.release(upload)
 			}
 		}
 		for id, download := range s.downloads {
@@ -38,19 +45,24 @@ func (s *Server) Sweep() {
 				}
 				log.Debugf(LOG+"clearing out download stream %v... it expired on %s", id, download.expires)
 				download.bucket.metrics.CancelDownload()
+				expired = append(expired, download)
 				delete(s.downloads, id)
+				s.release(download)
 			}
 		}
 		s.lock.Unlock()
 
-		if len(cancel) > 0 {
-			log.Debugf(LOG+"swept up: clearing out %d of %d streams", len(cancel), total)
-			for id, wr := range cancel {
-				log.Debugf(LOG+"canceling upload stream %v...", id)
-				wr.Close()
-				wr.Cancel()
-			}
-			log.Debugf(LOG+"canceled all %d expired upload streams", len(cancel))
+		if len(expired) > 0 {
+			log.Debugf(LOG+"swept up: clearing out %d of %d streams", len(expired), total)
+		}
+		for _, x := range expired {
+			s.record(x.record("cancel", "expired", "lease expired at "+x.expires.Format(time.RFC3339)))
+
+			// stream.Cancel stops any write pipeline before
+			// canceling the upload, so nothing is written
+			// after (or during) the cancelation.
+			log.Debugf(LOG+"canceling %s stream %v...", x.kind(), x.id)
+			x.Cancel()
 		}
 	}
 }
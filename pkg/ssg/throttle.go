@@ -0,0 +1,171 @@
+package ssg
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jhunt/go-log"
+	"github.com/jhunt/go-route"
+
+	"github.com/jhunt/ssg/pkg/ssg/config"
+)
+
+type throttle struct {
+	lock sync.Mutex
+
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newThrottle(rate, burst float64) *throttle {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &throttle{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+func (t *throttle) refill(now time.Time) {
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > t.burst {
+		t.tokens = t.burst
+	}
+	t.last = now
+}
+
+// take n tokens, going into debt if need be, and
+// return how long the caller should wait until
+// that debt is paid off.
+func (t *throttle) take(n int) time.Duration {
+	if t == nil || n <= 0 {
+		return 0
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.refill(time.Now())
+	t.tokens -= float64(n)
+	if t.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-t.tokens / t.rate * float64(time.Second))
+}
+
+func (t *throttle) allow() (bool, time.Duration) {
+	if t == nil {
+		return true, 0
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.refill(time.Now())
+	if t.tokens >= 1 {
+		t.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - t.tokens) / t.rate * float64(time.Second))
+}
+
+type limits struct {
+	bytes    *throttle
+	requests *throttle
+}
+
+func newLimits(c *config.Limits) *limits {
+	if c == nil {
+		return nil
+	}
+
+	burst := float64(c.Burst)
+	if burst == 0 {
+		burst = math.Ceil(c.RequestsPerSecond)
+	}
+	return &limits{
+		bytes:    newThrottle(float64(c.BytesPerSecond), float64(c.BytesPerSecond)),
+		requests: newThrottle(c.RequestsPerSecond, burst),
+	}
+}
+
+func (l *limits) bandwidth() *throttle {
+	if l == nil {
+		return nil
+	}
+	return l.bytes
+}
+
+func (l *limits) admit() (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	return l.requests.allow()
+}
+
+// limitsFor returns the limits shared by every request
+// made by the same identity (authenticated the same way).
+func (s *Server) limitsFor(scope *Token) *limits {
+	if scope == nil || scope.Limits == nil {
+		return nil
+	}
+	if scope.Name == "" {
+		// anonymous callers can't share anyone's limits
+		return newLimits(scope.Limits)
+	}
+
+	key := scope.auth + ":" + scope.Name
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if l, ok := s.identities[key]; ok {
+		return l
+	}
+	l := newLimits(scope.Limits)
+	s.identities[key] = l
+	return l
+}
+
+func (s *Server) admit(bucket string, by requester) (bool, time.Duration) {
+	if ok, wait := by.limits.admit(); !ok {
+		return false, wait
+	}
+	if b := s.bucket(bucket); b != nil {
+		return b.limits.admit()
+	}
+	return true, 0
+}
+
+func (s *Server) throttled(r *route.Request, bucket string, by requester) bool {
+	ok, wait := s.admit(bucket, by)
+	if ok {
+		return false
+	}
+
+	retry := int(math.Ceil(wait.Seconds()))
+	log.Debugf(LOG+"rate limiting request from %s against bucket '%s' (retry in %ds)", by, bucket, retry)
+	r.Header().Set("Retry-After", strconv.Itoa(retry))
+	r.Fail(route.Errorf(429, nil, "too many requests; retry in %d seconds", retry))
+	return true
+}
+
+func (s *stream) throttle(n int) {
+	var wait time.Duration
+	for _, t := range []*throttle{s.bucket.limits.bandwidth(), s.requester.limits.bandwidth()} {
+		if d := t.take(n); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,159 @@
+package ssg
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/jhunt/go-log"
+
+	"github.com/jhunt/ssg/pkg/ssg/config"
+)
+
+const CertificateCheckInterval = 5 * time.Second
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+type certificate struct {
+	lock sync.Mutex
+
+	cert, key string
+	loaded    *tls.Certificate
+	modified  time.Time
+	checked   time.Time
+}
+
+func (c *certificate) mtime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{c.cert, c.key} {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (c *certificate) load() error {
+	mtime, err := c.mtime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.cert, c.key)
+	if err != nil {
+		return err
+	}
+
+	c.loaded = &cert
+	c.modified = mtime
+	c.checked = time.Now()
+	return nil
+}
+
+func (c *certificate) get(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if time.Since(c.checked) < CertificateCheckInterval {
+		return c.loaded, nil
+	}
+	c.checked = time.Now()
+
+	mtime, err := c.mtime()
+	if err != nil {
+		log.Infof(LOG+"unable to check tls certificate %s for changes: %s", c.cert, err)
+		return c.loaded, nil
+	}
+	if !mtime.After(c.modified) {
+		return c.loaded, nil
+	}
+
+	log.Infof(LOG+"tls certificate %s has changed; reloading", c.cert)
+	if err := c.load(); err != nil {
+		log.Infof(LOG+"unable to reload tls certificate %s (keeping the old one): %s", c.cert, err)
+	}
+	return c.loaded, nil
+}
+
+func configureTLS(c config.TLS) (*tls.Config, error) {
+	cert := &certificate{
+		cert: c.Certificate,
+		key:  c.Key,
+	}
+	if err := cert.load(); err != nil {
+		return nil, fmt.Errorf("unable to load tls certificate: %s", err)
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     tlsVersions[c.MinVersion],
+		GetCertificate: cert.get,
+	}
+
+	if c.ClientCA != nil {
+		ca := *c.ClientCA
+		ca.IgnoreSystem = true
+		pool, err := ca.TLSConfig()
+		if err != nil {
+			return nil, fmt.Errorf("unable to load tls client ca: %s", err)
+		}
+
+		cfg.ClientCAs = pool.RootCAs
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		if c.ClientAuth == "optional" {
+			cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	return cfg, nil
+}
+
+func peer(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+func subjectRole(roles []config.TLSRole, cert *x509.Certificate) *config.TLSRole {
+	for i, r := range roles {
+		if ok, _ := path.Match(r.Subject, cert.Subject.CommonName); ok {
+			return &roles[i]
+		}
+		if ok, _ := path.Match(r.Subject, cert.Subject.String()); ok {
+			return &roles[i]
+		}
+	}
+	return nil
+}
+
+// certScope returns the scope of a client certificate
+// granted the given role.  Only explicitly unrestricted
+// roles get an unrestricted scope.
+func certScope(r *config.TLSRole, cert *x509.Certificate) *Token {
+	name := cert.Subject.CommonName
+	if name == "" {
+		name = cert.Subject.String()
+	}
+	return &Token{
+		Name:       name,
+		Operations: r.Operations,
+		Buckets:    r.Buckets,
+		Prefixes:   r.Prefixes,
+		Limits:     r.Limits,
+		auth:       "cert",
+	}
+}
@@ -0,0 +1,131 @@
+package ssg
+
+import (
+	"path"
+	"strings"
+
+	"github.com/jhunt/ssg/pkg/secret"
+	"github.com/jhunt/ssg/pkg/ssg/config"
+)
+
+type Token struct {
+	Name       string
+	Secret     string
+	Operations []string
+	Buckets    []string
+	Prefixes   []string
+	Limits     *config.Limits
+
+	// auth is how the scope was granted; one of 'token',
+	// 'jwt' or 'cert'.  Names are only unique within one
+	// kind of authentication.
+	auth string
+}
+
+func configureTokens(plain []string, named []config.Token) []Token {
+	l := make([]Token, 0, len(plain)+len(named))
+	for _, t := range plain {
+		l = append(l, Token{Secret: t, auth: "token"})
+	}
+	for _, t := range named {
+		l = append(l, Token{
+			Name:       t.Name,
+			Secret:     t.Token,
+			Operations: t.Operations,
+			Buckets:    t.Buckets,
+			Prefixes:   t.Prefixes,
+			Limits:     t.Limits,
+			auth:       "token",
+		})
+	}
+	return l
+}
+
+// indexTokens indexes the secrets of the control tokens,
+// so that authenticating a request costs at most a handful
+// of bcrypt comparisons, however many tokens there are.
+func indexTokens(tokens []Token) (*secret.Set, error) {
+	l := make([]string, len(tokens))
+	for i := range tokens {
+		l[i] = tokens[i].Secret
+	}
+	return secret.NewSet(l)
+}
+
+func (t *Token) unrestricted() bool {
+	return t == nil || (len(t.Operations) == 0 && len(t.Buckets) == 0 && len(t.Prefixes) == 0)
+}
+
+func (t *Token) sees(bucket string) bool {
+	if t == nil || len(t.Buckets) == 0 {
+		return true
+	}
+	for _, pattern := range t.Buckets {
+		if ok, _ := path.Match(pattern, bucket); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Token) permits(op, bucket, file string) bool {
+	// no token gets to climb out of the bucket
+	for _, part := range strings.Split(file, "/") {
+		if part == ".." {
+			return false
+		}
+	}
+
+	// releasing a legal hold must always be granted
+	// explicitly, even to otherwise unrestricted tokens
+	if op == "release" && (t == nil || !t.grants(op)) {
+		return false
+	}
+
+	if t == nil {
+		return true
+	}
+
+	if len(t.Operations) > 0 && !t.grants(op) {
+		return false
+	}
+
+	if !t.sees(bucket) {
+		return false
+	}
+
+	if len(t.Prefixes) > 0 {
+		if file == "" {
+			return false
+		}
+		file = strings.TrimPrefix(path.Clean("/"+file), "/")
+		for _, prefix := range t.Prefixes {
+			if within(file, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// grants checks that op is explicitly listed in the
+// operations of the token.
+func (t *Token) grants(op string) bool {
+	for _, allowed := range t.Operations {
+		if allowed == op {
+			return true
+		}
+	}
+	return false
+}
+
+// within checks that file is prefix, or lives under it,
+// matching on whole path components.
+func within(file, prefix string) bool {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+	return file == prefix || strings.HasPrefix(file, prefix+"/")
+}
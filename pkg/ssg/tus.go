@@ -0,0 +1,260 @@
+package ssg
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jhunt/go-log"
+	"github.com/jhunt/go-route"
+)
+
+const TusVersion = "1.0.0"
+
+// tus exposes upload streams via the tus.io resumable
+// upload protocol (v1.0.0), with support for the core
+// protocol, and the creation and termination extensions.
+//
+// Upload streams are still created via POST /control;
+// tus clients then supply the stream token as a bearer
+// token, and identify the stream to upload to via the
+// `stream` key of the Upload-Metadata header.
+//
+// Browser-based clients on other origins are supported
+// via CORS, for the origins listed in `tus.allowedOrigins`.
+func (s *Server) tus(r *route.Router) {
+	options := func(r *route.Request) {
+		if s.cors(r) {
+			r.Header().Set("Access-Control-Allow-Methods", "POST, HEAD, PATCH, DELETE, OPTIONS")
+			r.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset")
+			r.Header().Set("Access-Control-Max-Age", "86400")
+		}
+		r.Header().Set("Tus-Resumable", TusVersion)
+		r.Header().Set("Tus-Version", TusVersion)
+		r.Header().Set("Tus-Extension", "creation,termination")
+		r.Respond(204, "text/plain", "")
+	}
+	r.Dispatch("OPTIONS /tus/?", options)
+	r.Dispatch("OPTIONS /tus/:id", options)
+
+	r.Dispatch("POST /tus/?", func(r *route.Request) {
+		s.cors(r)
+		if !tusResumable(r) {
+			return
+		}
+		token, present := requireBearerToken(r, "blob auth")
+		if !present {
+			return
+		}
+
+		md, err := tusMetadata(r.Req.Header.Get("Upload-Metadata"))
+		if err != nil {
+			r.Fail(route.Bad(err, "invalid Upload-Metadata header: %s", err))
+			return
+		}
+		id, ok := md["stream"]
+		if !ok {
+			r.Fail(route.Bad(nil, "missing `stream` key in Upload-Metadata header"))
+			return
+		}
+
+		v := r.Req.Header.Get("Upload-Length")
+		if v == "" {
+			r.Fail(route.Bad(nil, "missing Upload-Length header"))
+			return
+		}
+		length, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || length < 0 {
+			r.Fail(route.Bad(err, "invalid Upload-Length header '%s'", v))
+			return
+		}
+
+		upstream, ok := s.getUpload(id, token)
+		if !ok {
+			r.Fail(route.NotFound(nil, "stream not found"))
+			return
+		}
+		if err := upstream.declareLength(length); err != nil {
+			r.Fail(route.Errorf(409, err, "%s", err))
+			return
+		}
+
+		log.Debugf(LOG+"tus client created upload for stream %v (length %d)", upstream.id, length)
+		r.Header().Set("Tus-Resumable", TusVersion)
+		r.Header().Set("Location", "/tus/"+upstream.id)
+		r.Respond(201, "text/plain", "")
+	})
+
+	r.Dispatch("HEAD /tus/:id", func(r *route.Request) {
+		s.cors(r)
+		if !tusResumable(r) {
+			return
+		}
+		token, present := requireBearerToken(r, "blob auth")
+		if !present {
+			return
+		}
+
+		upstream, ok := s.getUpload(r.Args[1], token)
+		if !ok {
+			r.Fail(route.NotFound(nil, "stream not found"))
+			return
+		}
+
+		p := upstream.progress(time.Now())
+		r.Header().Set("Tus-Resumable", TusVersion)
+		r.Header().Set("Cache-Control", "no-store")
+		r.Header().Set("Upload-Offset", fmt.Sprintf("%d", p.offset))
+		if p.length >= 0 {
+			r.Header().Set("Upload-Length", fmt.Sprintf("%d", p.length))
+		}
+		r.Respond(200, "text/plain", "")
+	})
+
+	r.Dispatch("PATCH /tus/:id", func(r *route.Request) {
+		s.cors(r)
+		if !tusResumable(r) {
+			return
+		}
+		token, present := requireBearerToken(r, "blob auth")
+		if !present {
+			return
+		}
+
+		if typ := r.Req.Header.Get("Content-Type"); typ != "application/offset+octet-stream" {
+			r.Fail(route.Errorf(415, nil, "unsupported content type '%s'", typ))
+			return
+		}
+
+		upstream, ok := s.getUpload(r.Args[1], token)
+		if !ok {
+			r.Fail(route.NotFound(nil, "stream not found"))
+			return
+		}
+		p := upstream.progress(time.Now())
+		committed, length := p.offset, p.length
+		if length < 0 {
+			r.Fail(route.Errorf(409, nil, "upload length not yet known; create the upload first"))
+			return
+		}
+
+		v := r.Req.Header.Get("Upload-Offset")
+		offset, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			r.Fail(route.Bad(err, "invalid Upload-Offset header '%s'", v))
+			return
+		}
+		if offset != committed {
+			r.Fail(route.Errorf(409, nil, "Upload-Offset %d does not match committed offset %d", offset, committed))
+			return
+		}
+
+		// refuse (before writing it) anything past the declared Upload-Length
+		r.Req.Body = ioutil.NopCloser(&bounded{r: r.Req.Body, n: length - committed})
+		if _, ok := s.receive(r, upstream, true); !ok {
+			return
+		}
+		if committed = upstream.progress(time.Now()).offset; committed == length {
+			log.Debugf(LOG+"tus upload to stream %v is complete; closing it", upstream.id)
+			if !s.finish(r, upstream) {
+				return
+			}
+		}
+
+		r.Header().Set("Tus-Resumable", TusVersion)
+		r.Header().Set("Upload-Offset", fmt.Sprintf("%d", committed))
+		r.Respond(204, "text/plain", "")
+	})
+
+	r.Dispatch("DELETE /tus/:id", func(r *route.Request) {
+		s.cors(r)
+		if !tusResumable(r) {
+			return
+		}
+		token, present := requireBearerToken(r, "blob auth")
+		if !present {
+			return
+		}
+
+		upstream, ok := s.getUpload(r.Args[1], token)
+		if !ok {
+			r.Fail(route.NotFound(nil, "stream not found"))
+			return
+		}
+
+		log.Debugf(LOG+"tus client terminating upload to stream %v", upstream.id)
+		s.cancel(upstream, "terminated by tus client")
+		r.Header().Set("Tus-Resumable", TusVersion)
+		r.Respond(204, "text/plain", "")
+	})
+}
+
+// declareLength sets the tus Upload-Length of an upload
+// stream, which can only be set once.
+func (x *stream) declareLength(length int64) error {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if x.length >= 0 && x.length != length {
+		return fmt.Errorf("upload length already set to %d", x.length)
+	}
+	if length < x.offset {
+		return fmt.Errorf("upload length %d is less than the %d bytes already committed", length, x.offset)
+	}
+	x.length = length
+	return nil
+}
+
+// cors sets the CORS response headers for tus requests from
+// allowed (cross-) origins, returning true if it did.
+func (s *Server) cors(r *route.Request) bool {
+	origin := r.Req.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+
+	for _, allowed := range s.allowedOrigins() {
+		if allowed == "*" || allowed == origin {
+			r.Header().Set("Access-Control-Allow-Origin", origin)
+			r.Header().Add("Vary", "Origin")
+			r.Header().Set("Access-Control-Expose-Headers", "Location, Upload-Offset, Upload-Length, Tus-Resumable, Tus-Version, Tus-Extension")
+			return true
+		}
+	}
+	return false
+}
+
+func tusResumable(r *route.Request) bool {
+	if v := r.Req.Header.Get("Tus-Resumable"); v != TusVersion {
+		r.Header().Set("Tus-Version", TusVersion)
+		r.Fail(route.Errorf(412, nil, "unsupported tus protocol version '%s'", v))
+		return false
+	}
+	return true
+}
+
+func tusMetadata(in string) (map[string]string, error) {
+	md := make(map[string]string)
+	for _, pair := range strings.Split(in, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		l := strings.SplitN(pair, " ", 2)
+		if len(l) == 1 {
+			md[l[0]] = ""
+			continue
+		}
+
+		v, err := base64.StdEncoding.DecodeString(l[1])
+		if err != nil {
+			return nil, fmt.Errorf("value for key '%s' is not base64-encoded", l[0])
+		}
+		md[l[0]] = string(v)
+	}
+	return md, nil
+}
@@ -1,9 +1,14 @@
 package ssg
 
 import (
+	"crypto/tls"
+	"net/http"
 	"sync"
 	"time"
 
+	"github.com/jhunt/ssg/pkg/secret"
+	"github.com/jhunt/ssg/pkg/ssg/audit"
+	"github.com/jhunt/ssg/pkg/ssg/config"
 	"github.com/jhunt/ssg/pkg/ssg/provider"
 	"github.com/jhunt/ssg/pkg/ssg/vault"
 )
@@ -11,19 +16,56 @@ import (
 type stream struct {
 	id    string
 	canon string
+	path  string // in the bucket, as the provider sees it
 
 	secret  string
+	created time.Time
 	leased  time.Time
 	expires time.Time
 	renewal time.Duration
 
+	// lock guards the transfer progress (segments through
+	// rate), which is updated by the handler moving the
+	// data, and read by everyone else.
+	lock     sync.Mutex
 	segments int
+	offset   int64
+	length   int64
+	last     struct {
+		offset int64
+		n      int
+	}
+	encoding string
+
+	// busy serializes writing to (or closing) the
+	// uploader with canceling it, so that a canceled
+	// upload is never written to afterwards.
+	busy     sync.Mutex
+	canceled bool
 	writer   provider.Uploader
 	reader   provider.Downloader
+	pipe     *pipeline
 	bucket   *bucket
+	reserved int64
 
+	active       time.Time
 	compressed   delta
 	uncompressed delta
+	rate         rate
+
+	requester requester
+}
+
+type requester struct {
+	remote   string
+	identity string
+	limits   *limits
+}
+
+type rate struct {
+	since time.Time
+	n     int64
+	bps   float64
 }
 
 type bucket struct {
@@ -37,24 +79,66 @@ type bucket struct {
 	provider provider.Provider
 	vault    vault.Vault
 	metrics  *metrics
+	limits   *limits
+
+	concurrency *config.Concurrency
+	buffers     map[string]int64 // by stream kind
+
+	lister    provider.Lister
+	lifecycle []config.Lifecycle
+
+	stater    provider.Stater
+	holder    provider.Holder
+	retention time.Duration
 }
 
 type Server struct {
-	Cluster       string
-	Bind          string
-	ControlTokens []string
-	MonitorTokens []string
-	MaxLease      time.Duration
-	SweepInterval time.Duration
-	ReservoirSize int
-
-	lock      sync.Mutex
-	buckets   []*bucket
-	uploads   map[string]*stream
-	downloads map[string]*stream
+	Cluster           string
+	Bind              string
+	TLS               *tls.Config
+	ControlTokens     []Token
+	MonitorTokens     []string
+	MaxLease          time.Duration
+	LeaseCeiling      time.Duration
+	SweepInterval     time.Duration
+	LifecycleInterval time.Duration
+	ShutdownGrace     time.Duration
+	ReservoirSize     int
+	WriteQueue        int
+
+	Timeouts struct {
+		ReadHeader time.Duration
+		Read       time.Duration
+		Write      time.Duration
+		Idle       time.Duration
+	}
+
+	lock        sync.Mutex
+	path        string
+	http        *http.Server
+	draining    bool
+	done        chan struct{}
+	controls    *secret.Set
+	monitors    *secret.Set
+	roles       []config.TLSRole
+	jwt         *verifier
+	signing     *config.Signing
+	origins     []string
+	policy      *policy
+	identities  map[string]*limits
+	concurrency *config.Concurrency
+	usage       usage
+	inUse       map[string]*usage
+	auditor     audit.Logger
+	buckets     []*bucket
+	uploads     map[string]*stream
+	downloads   map[string]*stream
 }
 
 func (s *Server) bucket(key string) *bucket {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	for i := range s.buckets {
 		if s.buckets[i].key == key {
 			return s.buckets[i]
@@ -62,3 +146,12 @@ func (s *Server) bucket(key string) *bucket {
 	}
 	return nil
 }
+
+func (s *Server) allBuckets() []*bucket {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	l := make([]*bucket, len(s.buckets))
+	copy(l, s.buckets)
+	return l
+}
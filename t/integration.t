@@ -226,6 +226,8 @@ for my $BUCKET (grep { m/^base-/ } @buckets) {
 						out => 0,
 					},
 				},
+				backend => ignore,
+				lifecycle => ignore,
 			},
 		}), "metrics should be initially blank");
 
@@ -252,11 +254,21 @@ for my $BUCKET (grep { m/^base-/ } @buckets) {
 			or diag $res->as_string;
 		cmp_deeply($RESPONSE, [
 			{
-				kind     => 'upload',
-				id       => $id,
-				canon    => $CANON,
-				received => 0,
-				expires  => ignore(),
+				kind         => 'upload',
+				id           => $id,
+				canon        => $CANON,
+				bucket       => $BUCKET,
+				created      => ignore(),
+				active       => ignore(),
+				expires      => ignore(),
+				received     => 0,
+				sent         => 0,
+				compressed   => 0,
+				uncompressed => 0,
+				segments     => 0,
+				throughput   => 0,
+				remote       => ignore(),
+				identity     => ignore(),
 			},
 		], "our upload stream should be listed");
 
@@ -294,6 +306,8 @@ for my $BUCKET (grep { m/^base-/ } @buckets) {
 						out => 0,
 					},
 				},
+				backend => ignore,
+				lifecycle => ignore,
 			},
 		}), "metrics should reflect our new upload operation");
 
@@ -359,6 +373,8 @@ for my $BUCKET (grep { m/^base-/ } @buckets) {
 						out => atleast(35), # "compressed"
 					},
 				},
+				backend => ignore,
+				lifecycle => ignore,
 			},
 		}), "metrics should reflect our new upload operation");
 
@@ -414,6 +430,8 @@ for my $BUCKET (grep { m/^base-/ } @buckets) {
 					bytes => ignore,
 				},
 				transfer => ignore,
+				backend => ignore,
+				lifecycle => ignore,
 			},
 		}), "metrics should reflect our new download operation");
 
@@ -423,11 +441,21 @@ for my $BUCKET (grep { m/^base-/ } @buckets) {
 			or diag $res->as_string;
 		cmp_deeply($RESPONSE, [
 			{
-				kind     => 'download',
-				id       => $id,
-				canon    => $CANON,
-				received => 0,
-				expires  => ignore(),
+				kind         => 'download',
+				id           => $id,
+				canon        => $CANON,
+				bucket       => $BUCKET,
+				created      => ignore(),
+				active       => ignore(),
+				expires      => ignore(),
+				received     => 0,
+				sent         => 0,
+				compressed   => 0,
+				uncompressed => 0,
+				segments     => 0,
+				throughput   => 0,
+				remote       => ignore(),
+				identity     => ignore(),
 			},
 		], "our download stream should be listed");
 
@@ -472,6 +500,8 @@ for my $BUCKET (grep { m/^base-/ } @buckets) {
 						out => atleast(35), # "compressed"
 					},
 				},
+				backend => ignore,
+				lifecycle => ignore,
 			},
 		}), "metrics should reflect our new upload operation");
 
@@ -504,6 +534,8 @@ for my $BUCKET (grep { m/^base-/ } @buckets) {
 				},
 				segments => ignore,
 				transfer => ignore,
+				backend => ignore,
+				lifecycle => ignore,
 			},
 		}), "metrics should reflect our new expunge operation");
 
@@ -541,6 +573,8 @@ for my $BUCKET (grep { m/^base-/ } @buckets) {
 				},
 				segments => ignore,
 				transfer => ignore,
+				backend => ignore,
+				lifecycle => ignore,
 			},
 		}), "metrics should reflect our new upload operation");
 
@@ -593,6 +627,8 @@ for my $BUCKET (grep { m/^base-/ } @buckets) {
 						# data to make it worthwhile, dictionary-wise).
 					},
 				},
+				backend => ignore,
+				lifecycle => ignore,
 			},
 		}), "metrics should reflect our second segment");
 
@@ -625,6 +661,8 @@ for my $BUCKET (grep { m/^base-/ } @buckets) {
 				},
 				segments => ignore,
 				transfer => ignore,
+				backend => ignore,
+				lifecycle => ignore,
 			},
 		}), "metrics should reflect our canceled upload");
 